@@ -0,0 +1,321 @@
+/*
+	Copyright (c) 2020 Martin Sustrik
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy
+	of this software and associated documentation files (the "Software"),
+	to deal in the Software without restriction, including without limitation
+	the rights to use, copy, modify, merge, publish, distribute, sublicense,
+	and/or sell copies of the Software, and to permit persons to whom
+	the Software is furnished to do so, subject to the following conditions:
+	The above copyright notice and this permission notice shall be included
+	in all copies or substantial portions of the Software.
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+	FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+	IN THE SOFTWARE.
+*/
+
+// Package browse implements a web interface for exploring grison graphs.
+package browse
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sustrik/grison"
+)
+
+// PageSize is the maximum number of nodes listed on a single page.
+const PageSize = 100
+
+// Handler serves a grison graph as a set of interlinked HTML pages.
+//
+// The following pages are available:
+//
+//	/              list of collections and a search box
+//	/type?name=T   list of nodes in collection T
+//	/node?ref=R    fields of node R and references pointing to it
+//	/search?q=Q    nodes whose reference or scalar fields contain Q
+type Handler struct {
+	mu   sync.Mutex
+	load func() (*grison.Document, error)
+	mux  *http.ServeMux
+}
+
+// NewHandler returns a handler serving the grison document b.
+func NewHandler(b []byte) (*Handler, error) {
+	doc, err := grison.ParseDocument(b)
+	if err != nil {
+		return nil, err
+	}
+	return NewDocumentHandler(doc), nil
+}
+
+// NewDocumentHandler returns a handler serving the document. The document
+// must not be modified while the handler is in use.
+func NewDocumentHandler(doc *grison.Document) *Handler {
+	return newHandler(func() (*grison.Document, error) { return doc, nil })
+}
+
+// NewMasterHandler returns a handler serving the graph reachable from
+// the master structure m. The master structure is marshaled anew on every
+// request so that the pages reflect its current state. Access to m must be
+// synchronized by the caller.
+func NewMasterHandler(m interface{}, opts grison.MarshalOpts) *Handler {
+	return newHandler(func() (*grison.Document, error) {
+		return grison.MarshalDocument(m, opts)
+	})
+}
+
+func newHandler(load func() (*grison.Document, error)) *Handler {
+	h := &Handler{load: load, mux: http.NewServeMux()}
+	h.mux.HandleFunc("/", h.serveIndex)
+	h.mux.HandleFunc("/type", h.serveType)
+	h.mux.HandleFunc("/node", h.serveNode)
+	h.mux.HandleFunc("/search", h.serveSearch)
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) document() (*grison.Document, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.load()
+}
+
+type collectionItem struct {
+	Name  string
+	Count int
+}
+
+func (h *Handler) serveIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	doc, err := h.document()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var items []collectionItem
+	for _, tp := range doc.Types() {
+		items = append(items, collectionItem{Name: tp, Count: len(doc.Nodes(tp))})
+	}
+	render(w, indexTemplate, items)
+}
+
+type typePage struct {
+	Name  string
+	Nodes []*grison.Node
+	Page  int
+	Pages int
+}
+
+func (h *Handler) serveType(w http.ResponseWriter, r *http.Request) {
+	doc, err := h.document()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	name := r.URL.Query().Get("name")
+	if !doc.HasType(name) {
+		http.NotFound(w, r)
+		return
+	}
+	nodes := doc.Nodes(name)
+	p := typePage{Name: name, Pages: (len(nodes) + PageSize - 1) / PageSize}
+	p.Page, _ = strconv.Atoi(r.URL.Query().Get("page"))
+	if p.Page > p.Pages {
+		p.Page = p.Pages
+	}
+	if p.Page < 1 {
+		p.Page = 1
+	}
+	start := (p.Page - 1) * PageSize
+	for i := start; i < len(nodes) && i < start+PageSize; i++ {
+		p.Nodes = append(p.Nodes, nodes[i])
+	}
+	render(w, typeTemplate, p)
+}
+
+type fieldItem struct {
+	Name  string
+	Value template.HTML
+}
+
+type nodePage struct {
+	Node     *grison.Node
+	Fields   []fieldItem
+	Backrefs []grison.Edge
+}
+
+func (h *Handler) serveNode(w http.ResponseWriter, r *http.Request) {
+	doc, err := h.document()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ref, err := grison.ParseRef(r.URL.Query().Get("ref"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	n := doc.Resolve(ref)
+	if n == nil {
+		http.NotFound(w, r)
+		return
+	}
+	p := nodePage{Node: n, Backrefs: n.Backrefs()}
+	for _, name := range n.FieldNames() {
+		v, err := n.Value(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var sb strings.Builder
+		RenderValue(&sb, doc, v, nodeURL)
+		p.Fields = append(p.Fields, fieldItem{Name: name, Value: template.HTML(sb.String())})
+	}
+	render(w, nodeTemplate, p)
+}
+
+type searchResult struct {
+	Ref   grison.Ref
+	Field string
+	Value string
+}
+
+type searchPage struct {
+	Query     string
+	Results   []searchResult
+	Truncated bool
+}
+
+func (h *Handler) serveSearch(w http.ResponseWriter, r *http.Request) {
+	doc, err := h.document()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	p := searchPage{Query: r.URL.Query().Get("q")}
+	if p.Query != "" {
+		p.Results, p.Truncated = search(doc, p.Query, PageSize)
+	}
+	render(w, searchTemplate, p)
+}
+
+// search finds nodes whose reference string or scalar field values
+// contain the query. The match is case-insensitive.
+func search(doc *grison.Document, query string, limit int) ([]searchResult, bool) {
+	q := strings.ToLower(query)
+	var results []searchResult
+	for _, n := range doc.AllNodes() {
+		if strings.Contains(strings.ToLower(n.Ref().String()), q) {
+			results = append(results, searchResult{Ref: n.Ref()})
+		} else {
+			for _, name := range n.FieldNames() {
+				v, err := n.Value(name)
+				if err != nil {
+					continue
+				}
+				s, ok := scalarString(v)
+				if ok && strings.Contains(strings.ToLower(s), q) {
+					results = append(results, searchResult{Ref: n.Ref(), Field: name, Value: s})
+					break
+				}
+			}
+		}
+		if len(results) > limit {
+			return results[:limit], true
+		}
+	}
+	return results, false
+}
+
+func scalarString(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
+
+func nodeURL(ref grison.Ref) string {
+	return "/node?ref=" + url.QueryEscape(ref.String())
+}
+
+const pad = "&nbsp;&nbsp;&nbsp;&nbsp;"
+
+// RenderValue writes the decoded value of a field as HTML. References
+// to existing nodes are rendered as links to the URLs returned by the link
+// function, dangling references are struck through.
+func RenderValue(sb *strings.Builder, doc *grison.Document, v interface{}, link func(grison.Ref) string) {
+	renderValue(sb, doc, v, link, 0)
+}
+
+func renderValue(sb *strings.Builder, doc *grison.Document, v interface{}, link func(grison.Ref) string, indent int) {
+	switch v := v.(type) {
+	case grison.Ref:
+		if doc.Resolve(v) != nil {
+			fmt.Fprintf(sb, "<a href=\"%s\">%s</a>",
+				template.HTMLEscapeString(link(v)), template.HTMLEscapeString(v.String()))
+		} else {
+			fmt.Fprintf(sb, "<s title=\"dangling reference\">%s</s>", template.HTMLEscapeString(v.String()))
+		}
+	case map[string]interface{}:
+		var keys []string
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		sb.WriteString("{")
+		for _, k := range keys {
+			sb.WriteString("<br>")
+			sb.WriteString(strings.Repeat(pad, indent+1))
+			fmt.Fprintf(sb, "<span class=\"prop\">%s</span>: ", template.HTMLEscapeString(k))
+			renderValue(sb, doc, v[k], link, indent+1)
+		}
+		sb.WriteString("<br>")
+		sb.WriteString(strings.Repeat(pad, indent))
+		sb.WriteString("}")
+	case []interface{}:
+		sb.WriteString("[")
+		for _, item := range v {
+			sb.WriteString("<br>")
+			sb.WriteString(strings.Repeat(pad, indent+1))
+			renderValue(sb, doc, item, link, indent+1)
+		}
+		sb.WriteString("<br>")
+		sb.WriteString(strings.Repeat(pad, indent))
+		sb.WriteString("]")
+	default:
+		b, _ := json.Marshal(v)
+		sb.WriteString(template.HTMLEscapeString(string(b)))
+	}
+}
+
+func render(w http.ResponseWriter, t *template.Template, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := t.Execute(w, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
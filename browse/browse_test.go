@@ -0,0 +1,121 @@
+package browse
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sustrik/grison"
+)
+
+const testDoc = `{"Children":{"#3":{"Father":{"$ref":"Parents:#2"},"Name":"Carol"}},` +
+	`"Parents":{"#1":{"Name":"Alice","Spouse":{"$ref":"Parents:#2"}},` +
+	`"#2":{"Children":[{"$ref":"Children:#3"}],"Name":"Bob","Spouse":{"$ref":"Parents:#1"}}}}`
+
+func get(t *testing.T, h http.Handler, target string) (int, string) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+	b, err := ioutil.ReadAll(w.Result().Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, string(b)
+}
+
+func expectPage(t *testing.T, h http.Handler, target string, fragments ...string) {
+	code, body := get(t, h, target)
+	if code != http.StatusOK {
+		t.Errorf("%s: unexpected status %d", target, code)
+		return
+	}
+	for _, f := range fragments {
+		if !strings.Contains(body, f) {
+			t.Errorf("%s: page doesn't contain %q\n%s", target, f, body)
+		}
+	}
+}
+
+func TestPages(t *testing.T) {
+	h, err := NewHandler([]byte(testDoc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectPage(t, h, "/", `Children</a> (1)`, `Parents</a> (2)`)
+	expectPage(t, h, "/type?name=Parents", `#1</a>`, `#2</a>`)
+	expectPage(t, h, "/node?ref="+url.QueryEscape("Parents:#2"),
+		`<a href="/node?ref=Children%3A%233">Children:#3</a>`,
+		`&#34;Bob&#34;`,
+		// Backlinks.
+		`<a href="/node?ref=Parents%3A%231">Parents:#1</a> <span class="prop">Spouse</span>`,
+		`<a href="/node?ref=Children%3A%233">Children:#3</a> <span class="prop">Father</span>`)
+	expectPage(t, h, "/search?q=car", `Children:#3</a> <span class="prop">Name</span>: Carol`)
+	expectPage(t, h, "/search?q=parents", `Parents:#1</a>`, `Parents:#2</a>`)
+	code, _ := get(t, h, "/node?ref="+url.QueryEscape("Parents:#9"))
+	if code != http.StatusNotFound {
+		t.Errorf("unexpected status %d for missing node", code)
+	}
+}
+
+func TestPaging(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString(`{"Node":{`)
+	for i := 1; i <= PageSize+1; i++ {
+		if i > 1 {
+			sb.WriteString(",")
+		}
+		sb.WriteString(`"#` + strings.Repeat("1", i) + `":{}`)
+	}
+	sb.WriteString(`}}`)
+	h, err := NewHandler([]byte(sb.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectPage(t, h, "/type?name=Node", "page 1 of 2", "next")
+	expectPage(t, h, "/type?name=Node&page=2", "page 2 of 2", "previous")
+}
+
+func TestMasterHandler(t *testing.T) {
+	type Node struct {
+		Name string
+		Next *Node
+	}
+	type Master struct {
+		Node []*Node
+	}
+	m := &Master{Node: []*Node{{Name: "foo"}}}
+	h := NewMasterHandler(m, grison.MarshalOpts{})
+	expectPage(t, h, "/node?ref="+url.QueryEscape("Node:#1"), `&#34;foo&#34;`)
+	m.Node[0].Name = "bar"
+	expectPage(t, h, "/node?ref="+url.QueryEscape("Node:#1"), `&#34;bar&#34;`)
+}
+
+func TestPageOutOfRange(t *testing.T) {
+	h, err := NewHandler([]byte(testDoc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, page := range []string{"92233720368547760", "-5", "x"} {
+		expectPage(t, h, "/type?name=Parents&page="+page, `#1</a>`, `#2</a>`)
+	}
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	h, err := NewHandler([]byte(testDoc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", "/node?ref="+url.QueryEscape("Parents:#1"), nil))
+		}()
+	}
+	for i := 0; i < 8; i++ {
+		<-done
+	}
+}
@@ -0,0 +1,89 @@
+/*
+	Copyright (c) 2020 Martin Sustrik
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy
+	of this software and associated documentation files (the "Software"),
+	to deal in the Software without restriction, including without limitation
+	the rights to use, copy, modify, merge, publish, distribute, sublicense,
+	and/or sell copies of the Software, and to permit persons to whom
+	the Software is furnished to do so, subject to the following conditions:
+	The above copyright notice and this permission notice shall be included
+	in all copies or substantial portions of the Software.
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+	FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+	IN THE SOFTWARE.
+*/
+
+package browse
+
+import (
+	"html/template"
+)
+
+const layout = `{{define "header"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="utf-8">
+    <title>grison browse</title>
+    <style>
+        body {
+            font-family: "Courier New", Courier, monospace
+        }
+        a {
+            color: red;
+        }
+        .prop {
+            color: blue;
+        }
+    </style>
+</head>
+<body>
+<form action="/search"><a href="/">index</a> <input name="q" value="{{.}}"> <input type="submit" value="search"></form>
+<hr>
+{{end}}
+{{define "footer"}}</body>
+</html>
+{{end}}`
+
+var funcs = template.FuncMap{
+	"nodeURL": nodeURL,
+	"inc":     func(i int) int { return i + 1 },
+	"dec":     func(i int) int { return i - 1 },
+}
+
+func newTemplate(body string) *template.Template {
+	return template.Must(template.Must(template.New("").Funcs(funcs).Parse(layout)).Parse(body))
+}
+
+var indexTemplate = newTemplate(`{{template "header" ""}}
+<h3>collections</h3>
+{{range .}}<a href="/type?name={{.Name}}">{{.Name}}</a> ({{.Count}})<br>
+{{end}}{{template "footer"}}`)
+
+var typeTemplate = newTemplate(`{{template "header" ""}}
+<h3>{{.Name}}</h3>
+{{range .Nodes}}<a href="{{nodeURL .Ref}}">{{.ID}}</a><br>
+{{end}}{{if gt .Pages 1}}<p>
+{{if gt .Page 1}}<a href="/type?name={{.Name}}&page={{dec .Page}}">previous</a>{{end}}
+page {{.Page}} of {{.Pages}}
+{{if lt .Page .Pages}}<a href="/type?name={{.Name}}&page={{inc .Page}}">next</a>{{end}}
+</p>{{end}}{{template "footer"}}`)
+
+var nodeTemplate = newTemplate(`{{template "header" ""}}
+<h3><a href="/type?name={{.Node.Type}}">{{.Node.Type}}</a>:{{.Node.ID}}</h3>
+{{range .Fields}}<span class="prop">{{.Name}}</span>: {{.Value}}<br>
+{{end}}
+<h4>referenced by</h4>
+{{range .Backrefs}}<a href="{{nodeURL .From.Ref}}">{{.From.Ref}}</a> <span class="prop">{{.Path}}</span><br>
+{{else}}nothing<br>
+{{end}}{{template "footer"}}`)
+
+var searchTemplate = newTemplate(`{{template "header" .Query}}
+<h3>search results for "{{.Query}}"</h3>
+{{range .Results}}<a href="{{nodeURL .Ref}}">{{.Ref}}</a>{{if .Field}} <span class="prop">{{.Field}}</span>: {{.Value}}{{end}}<br>
+{{else}}nothing found<br>
+{{end}}{{if .Truncated}}<p>more results omitted</p>{{end}}{{template "footer"}}`)
@@ -0,0 +1,315 @@
+/*
+	Copyright (c) 2020 Martin Sustrik
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy
+	of this software and associated documentation files (the "Software"),
+	to deal in the Software without restriction, including without limitation
+	the rights to use, copy, modify, merge, publish, distribute, sublicense,
+	and/or sell copies of the Software, and to permit persons to whom
+	the Software is furnished to do so, subject to the following conditions:
+	The above copyright notice and this permission notice shall be included
+	in all copies or substantial portions of the Software.
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+	FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+	IN THE SOFTWARE.
+*/
+
+package grison
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// MarshalCanonical returns the canonical form of the graph. The canonical
+// form is compact grison JSON with automatic IDs assigned in the order in
+// which the nodes are reached from the master structure, ignoring IDProvider.
+// Keys of the objects are sorted and numbers are formatted the same way
+// regardless of how they were produced, e.g. by custom marshalers. Graphs
+// that marshal the same way, except for the IDs, have the same canonical form.
+func MarshalCanonical(m interface{}) ([]byte, error) {
+	doc, err := canonicalDocument(m)
+	if err != nil {
+		return nil, err
+	}
+	return doc.Marshal(MarshalOpts{})
+}
+
+// Hash returns a digest of the canonical form of the graph as a hexadecimal
+// string. It can be used to check whether the graph has changed.
+func Hash(m interface{}) (string, error) {
+	b, err := MarshalCanonical(m)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// NodeHashes returns Merkle-style hashes of all the nodes reachable from
+// the master structure. The map is keyed by the pointers to the nodes.
+// The hash of a node is computed from its values and the hashes of the nodes
+// it references, so it changes only if the node itself or any node reachable
+// from it changes. It doesn't depend on node IDs or on the position of the
+// node within the graph. Nodes that are part of a cycle are hashed together
+// with the rest of the cycle. Identical subgraphs get identical hashes.
+func NodeHashes(m interface{}) (map[interface{}]string, error) {
+	doc, err := canonicalDocument(m)
+	if err != nil {
+		return nil, err
+	}
+	w, err := newWalker(m, MarshalOpts{})
+	if err != nil {
+		return nil, err
+	}
+	hashes := doc.nodeHashes()
+	res := make(map[interface{}]string)
+	for _, wn := range w.nodes {
+		res[wn.val.Addr().Interface()] = hashes[wn.ref]
+	}
+	return res, nil
+}
+
+// canonicalDocument converts the master structure into a document
+// with automatic IDs and canonical values.
+func canonicalDocument(m interface{}) (*Document, error) {
+	doc, err := MarshalDocument(m, MarshalOpts{})
+	if err != nil {
+		return nil, err
+	}
+	err = doc.canonicalize()
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// canonicalize converts all the values in the document to canonical form.
+func (d *Document) canonicalize() error {
+	for _, n := range d.AllNodes() {
+		for _, name := range n.FieldNames() {
+			v, err := n.Value(name)
+			if err != nil {
+				return err
+			}
+			err = n.SetValue(name, canonicalValue(v))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// canonicalValue returns the value with all the numbers in canonical format.
+func canonicalValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		return json.Number(canonicalNumber(v))
+	case []interface{}:
+		for i, item := range v {
+			v[i] = canonicalValue(item)
+		}
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = canonicalValue(item)
+		}
+	}
+	return v
+}
+
+// canonicalNumber formats integers in decimal notation and other numbers
+// as the closest float64, in the same way encoding/json does.
+func canonicalNumber(n json.Number) string {
+	num := parseNumber(n)
+	switch num.kind {
+	case uintNumber:
+		return strconv.FormatUint(num.u, 10)
+	case intNumber:
+		return strconv.FormatInt(num.i, 10)
+	}
+	f, _ := strconv.ParseFloat(n.String(), 64)
+	return floatText(f, 64)
+}
+
+// nodeHashes computes Merkle-style hashes of the nodes. The strongly
+// connected components of the graph are processed so that the nodes
+// referenced from a component are hashed before the component itself.
+// A component is hashed starting from the member that yields the lowest
+// hash. The other members get hashes derived from the hash of the component
+// and the order in which they are reached, so that symmetric nodes, e.g.
+// the nodes of a ring, get distinct hashes.
+func (d *Document) nodeHashes() map[Ref]string {
+	hashes := make(map[Ref]string)
+	for _, scc := range d.components() {
+		members := make(map[Ref]bool)
+		for _, n := range scc {
+			members[n.Ref()] = true
+		}
+		var hash string
+		var order []*Node
+		for _, n := range scc {
+			h, o := d.componentHash(n, members, hashes)
+			if hash == "" || h < hash {
+				hash, order = h, o
+			}
+		}
+		if len(order) == 1 {
+			hashes[order[0].Ref()] = hash
+			continue
+		}
+		for i, n := range order {
+			sum := sha256.Sum256([]byte(hash + ":" + strconv.Itoa(i)))
+			hashes[n.Ref()] = hex.EncodeToString(sum[:])
+		}
+	}
+	return hashes
+}
+
+// componentHash hashes the component starting from the node. References
+// within the component are represented by the order in which the nodes
+// were reached, references outside of the component by the hashes of
+// the nodes they point to. Returns the hash and the members of the
+// component in the order they were reached.
+func (d *Document) componentHash(n *Node, members map[Ref]bool, hashes map[Ref]string) (string, []*Node) {
+	order := []*Node{n}
+	local := map[Ref]int{n.Ref(): 0}
+	ref := func(r Ref) string {
+		if members[r] {
+			i, ok := local[r]
+			if !ok {
+				i = len(order)
+				local[r] = i
+				order = append(order, d.Resolve(r))
+			}
+			return "l" + strconv.Itoa(i)
+		}
+		if h, ok := hashes[r]; ok {
+			return "h" + h
+		}
+		return "d" + r.String()
+	}
+	var sb strings.Builder
+	for i := 0; i < len(order); i++ {
+		sb.WriteString(strconv.Quote(order[i].Type()))
+		// IDs taken from ID fields are part of the content.
+		if !isAutoID(order[i].ID()) {
+			sb.WriteString("@" + strconv.Quote(order[i].ID()))
+		}
+		sb.WriteString("{")
+		for _, name := range order[i].FieldNames() {
+			v, _ := order[i].Value(name)
+			sb.WriteString(strconv.Quote(name) + ":")
+			writeCanonical(&sb, v, ref)
+			sb.WriteString(",")
+		}
+		sb.WriteString("}")
+	}
+	sum := sha256.Sum256([]byte(sb.String()))
+	return hex.EncodeToString(sum[:]), order
+}
+
+// components returns the strongly connected components of the graph
+// using Tarjan's algorithm. Each component is listed after all the
+// components reachable from it.
+func (d *Document) components() [][]*Node {
+	index := make(map[*Node]int)
+	low := make(map[*Node]int)
+	onStack := make(map[*Node]bool)
+	var stack []*Node
+	var res [][]*Node
+	var visit func(n *Node)
+	visit = func(n *Node) {
+		index[n] = len(index)
+		low[n] = index[n]
+		stack = append(stack, n)
+		onStack[n] = true
+		for _, e := range n.Refs() {
+			t := e.Target()
+			if t == nil {
+				continue
+			}
+			if _, ok := index[t]; !ok {
+				visit(t)
+				if low[t] < low[n] {
+					low[n] = low[t]
+				}
+			} else if onStack[t] && index[t] < low[n] {
+				low[n] = index[t]
+			}
+		}
+		if low[n] == index[n] {
+			var scc []*Node
+			for {
+				t := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[t] = false
+				scc = append(scc, t)
+				if t == n {
+					break
+				}
+			}
+			res = append(res, scc)
+		}
+	}
+	for _, n := range d.AllNodes() {
+		if _, ok := index[n]; !ok {
+			visit(n)
+		}
+	}
+	return res
+}
+
+// assignContentIDs replaces the automatic IDs of the marshalled nodes
+// by their hashes. Identical nodes are merged.
+func (enc *encoder) assignContentIDs() error {
+	doc, err := documentFromObjects(enc.objects)
+	if err != nil {
+		return err
+	}
+	err = doc.canonicalize()
+	if err != nil {
+		return err
+	}
+	hashes := doc.nodeHashes()
+	rename := func(ref Ref) Ref {
+		if h, ok := hashes[ref]; ok {
+			return Ref{Type: ref.Type, ID: h}
+		}
+		return ref
+	}
+	for tp := range enc.objects {
+		enc.objects[tp] = make(map[string]json.RawMessage)
+	}
+	for _, n := range doc.AllNodes() {
+		fields := make(map[string]json.RawMessage)
+		for _, name := range n.FieldNames() {
+			v, err := n.Value(name)
+			if err != nil {
+				return err
+			}
+			fields[name], err = encodeValue(mapRefs(v, rename))
+			if err != nil {
+				return err
+			}
+		}
+		rm, err := json.Marshal(fields)
+		if err != nil {
+			return err
+		}
+		enc.objects[n.Type()][hashes[n.Ref()]] = rm
+	}
+	for p, id := range enc.ids {
+		tp := enc.types[reflect.TypeOf(p).Elem()]
+		enc.ids[p] = hashes[Ref{Type: tp, ID: id}]
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package grison
+
+import (
+	"testing"
+)
+
+type canonicalValue1 struct{}
+
+func (v canonicalValue1) MarshalJSON() ([]byte, error) {
+	return []byte(`{"b":1.50,"a":[1e2, -0.0]}`), nil
+}
+
+type canonicalValue2 struct{}
+
+func (v canonicalValue2) MarshalJSON() ([]byte, error) {
+	return []byte(`{"a":[100,-0],"b":1.5}`), nil
+}
+
+type canonicalNode1 struct {
+	V canonicalValue1
+}
+
+type canonicalNode2 struct {
+	V canonicalValue2
+}
+
+type canonicalMaster1 struct {
+	Nodes []*canonicalNode1
+}
+
+type canonicalMaster2 struct {
+	Nodes []*canonicalNode2
+}
+
+func TestMarshalCanonical(t *testing.T) {
+	b1, err := MarshalCanonical(&canonicalMaster1{Nodes: []*canonicalNode1{{}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b2, err := MarshalCanonical(&canonicalMaster2{Nodes: []*canonicalNode2{{}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"Nodes":{"#1":{"V":{"a":[100,-0],"b":1.5}}}}`
+	if string(b1) != expected || string(b2) != expected {
+		t.Errorf("unexpected canonical form %s %s", b1, b2)
+	}
+}
+
+func TestHash(t *testing.T) {
+	h1, err := Hash(newGraphMaster())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, err := Hash(newGraphMaster())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newGraphMaster()
+	m.Nodes[2].Age = 1
+	h3, err := Hash(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h1) != 64 || h1 != h2 || h1 == h3 {
+		t.Errorf("unexpected hashes %s %s %s", h1, h2, h3)
+	}
+}
+
+func newHashMaster() *graphMaster {
+	m := newGraphMaster()
+	leaf := &graphNode{Name: "leaf"}
+	m.Nodes = append(m.Nodes, leaf, &graphNode{Next: leaf}, &graphNode{Name: "leaf"})
+	// Two identical cycles.
+	a1, a2, b1, b2 := &graphNode{}, &graphNode{}, &graphNode{}, &graphNode{}
+	a1.Next, a2.Next, b1.Next, b2.Next = a2, a1, b2, b1
+	m.Nodes = append(m.Nodes, a1, a2, b1, b2)
+	return m
+}
+
+func TestNodeHashes(t *testing.T) {
+	m := newHashMaster()
+	h, err := NodeHashes(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h) != len(m.Nodes) {
+		t.Fatalf("unexpected number of hashes %d", len(h))
+	}
+	n := m.Nodes
+	if h[n[3]] != h[n[5]] || h[n[3]] == h[n[4]] || h[n[0]] == h[n[1]] {
+		t.Errorf("unexpected hashes")
+	}
+	// Nodes of a ring get distinct hashes, but identical rings get the same ones.
+	if h[n[6]] == h[n[7]] || !(h[n[6]] == h[n[8]] && h[n[7]] == h[n[9]] || h[n[6]] == h[n[9]] && h[n[7]] == h[n[8]]) {
+		t.Errorf("unexpected hashes")
+	}
+	// Changing the leaf changes the nodes referring to it.
+	m2 := newHashMaster()
+	m2.Nodes[3].Age = 1
+	h2, err := NodeHashes(m2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n2 := m2.Nodes
+	if h2[n2[3]] == h[n[3]] || h2[n2[4]] == h[n[4]] || h2[n2[0]] != h[n[0]] || h2[n2[5]] != h[n[5]] {
+		t.Errorf("unexpected hashes after changing the leaf")
+	}
+	// Changing a node in a cycle changes the whole cycle.
+	m2 = newHashMaster()
+	m2.Nodes[2].Age = 1
+	h2, err = NodeHashes(m2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n2 = m2.Nodes
+	if h2[n2[0]] == h[n[0]] || h2[n2[1]] == h[n[1]] || h2[n2[2]] == h[n[2]] || h2[n2[3]] != h[n[3]] || h2[n2[4]] != h[n[4]] {
+		t.Errorf("unexpected hashes after changing the cycle")
+	}
+}
+
+func TestContentIDs(t *testing.T) {
+	m := newHashMaster()
+	b, err := MarshalWithOpts(m, MarshalOpts{ContentIDs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// IDs don't depend on the order of the nodes in the master.
+	m2 := newHashMaster()
+	m2.Nodes[0], m2.Nodes[9] = m2.Nodes[9], m2.Nodes[0]
+	b2, err := MarshalWithOpts(m2, MarshalOpts{ContentIDs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != string(b2) {
+		t.Errorf("IDs depend on the order of nodes\n%s\n%s", b, b2)
+	}
+	hashes, err := NodeHashes(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m3 graphMaster
+	err = Unmarshal(b, &m3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The duplicate leaf and the duplicate ring are stored only once.
+	if len(m3.Nodes) != 7 {
+		t.Fatalf("unexpected number of nodes %d\n%s", len(m3.Nodes), b)
+	}
+	doc, err := ParseDocument(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Node("Nodes", hashes[m.Nodes[3]]) == nil {
+		t.Errorf("node ID is not its hash")
+	}
+	// Marshalling the deduplicated graph yields the same result.
+	b3, err := MarshalWithOpts(&m3, MarshalOpts{ContentIDs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != string(b3) {
+		t.Errorf("unexpected result after round trip\n%s\n%s", b, b3)
+	}
+}
+
+func TestContentIDsGetIDs(t *testing.T) {
+	_, err := MarshalWithOpts(newHashMaster(), MarshalOpts{ContentIDs: true, GetIDs: true})
+	if err == nil {
+		t.Errorf("expected error")
+	}
+}
@@ -0,0 +1,589 @@
+/*
+	Copyright (c) 2020 Martin Sustrik
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy
+	of this software and associated documentation files (the "Software"),
+	to deal in the Software without restriction, including without limitation
+	the rights to use, copy, modify, merge, publish, distribute, sublicense,
+	and/or sell copies of the Software, and to permit persons to whom
+	the Software is furnished to do so, subject to the following conditions:
+	The above copyright notice and this permission notice shall be included
+	in all copies or substantial portions of the Software.
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+	FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+	IN THE SOFTWARE.
+*/
+
+package grison
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+// CBOR tags for value sharing, see http://cbor.schmorp.de/value-sharing
+const (
+	cborTagShareable    = 28
+	cborTagSharedRef    = 29
+	cborTagSelfDescribe = 55799
+)
+
+// MarshalCBOR converts the graph reachable from the master structure into
+// CBOR (RFC 8949). The layout is the same as that of the JSON format, i.e.
+// a map of collections, each being a map of nodes, except for references.
+// A node is written out, marked as shareable (tag 28), at the place where
+// it is first encountered. All other references to it, including those from
+// the collections, are shared references (tag 29).
+func MarshalCBOR(m interface{}, opts MarshalOpts) ([]byte, error) {
+	w, err := newWalker(m, opts)
+	if err != nil {
+		return nil, err
+	}
+	enc := &cborEncoder{w: w, nodes: make(map[Ref]walkedNode), shared: make(map[Ref]uint64)}
+	colls := make(map[string][]string)
+	for name := range w.enc.objects {
+		colls[name] = nil
+	}
+	for _, wn := range w.nodes {
+		enc.nodes[wn.ref] = wn
+		colls[wn.ref.Type] = append(colls[wn.ref.Type], wn.ref.ID)
+	}
+	for _, name := range w.enc.omitEmpty {
+		if len(colls[name]) == 0 {
+			delete(colls, name)
+		}
+	}
+	tps := make([]string, 0, len(colls))
+	for tp := range colls {
+		tps = append(tps, tp)
+	}
+	sort.Strings(tps)
+	enc.writeHead(5, uint64(len(tps)))
+	for _, tp := range tps {
+		enc.writeString(tp)
+		ids := colls[tp]
+		sort.Slice(ids, func(i, j int) bool { return idLess(ids[i], ids[j]) })
+		enc.writeHead(5, uint64(len(ids)))
+		for _, id := range ids {
+			enc.writeString(id)
+			err := enc.writeRef(Ref{Type: tp, ID: id})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return enc.buf.Bytes(), nil
+}
+
+// UnmarshalCBOR fills in the master structure from CBOR produced by MarshalCBOR.
+func UnmarshalCBOR(b []byte, m interface{}) error {
+	dec := &cborDecoder{b: b}
+	v, err := dec.readValue()
+	if err != nil {
+		return err
+	}
+	if dec.pos != len(b) {
+		return fmt.Errorf("unexpected data after the end of CBOR document")
+	}
+	return unmarshalShared(v, m)
+}
+
+// MarshalCBOR converts the document into CBOR. See MarshalCBOR function for
+// details. Converting a document produced by Marshal into CBOR and back yields
+// the same JSON.
+func (d *Document) MarshalCBOR() ([]byte, error) {
+	enc := &cborEncoder{doc: d, shared: make(map[Ref]uint64)}
+	tps := d.Types()
+	enc.writeHead(5, uint64(len(tps)))
+	for _, tp := range tps {
+		enc.writeString(tp)
+		nodes := d.Nodes(tp)
+		enc.writeHead(5, uint64(len(nodes)))
+		for _, n := range nodes {
+			enc.writeString(n.ID())
+			err := enc.writeValue(n.Ref())
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return enc.buf.Bytes(), nil
+}
+
+// ParseCBORDocument parses CBOR produced by MarshalCBOR into a document.
+func ParseCBORDocument(b []byte) (*Document, error) {
+	dec := &cborDecoder{b: b}
+	v, err := dec.readValue()
+	if err != nil {
+		return nil, err
+	}
+	if dec.pos != len(b) {
+		return nil, fmt.Errorf("unexpected data after the end of CBOR document")
+	}
+	return documentFromShared(v)
+}
+
+// cborEncoder writes either a document or, if w is set, the nodes found
+// by the walker.
+type cborEncoder struct {
+	doc    *Document
+	w      *walker
+	nodes  map[Ref]walkedNode
+	buf    bytes.Buffer
+	shared map[Ref]uint64
+}
+
+// writeHead writes the initial byte of a data item along with its argument.
+func (enc *cborEncoder) writeHead(major byte, arg uint64) {
+	major <<= 5
+	switch {
+	case arg < 24:
+		enc.buf.WriteByte(major | byte(arg))
+	case arg <= math.MaxUint8:
+		enc.buf.Write([]byte{major | 24, byte(arg)})
+	case arg <= math.MaxUint16:
+		enc.buf.WriteByte(major | 25)
+		binary.Write(&enc.buf, binary.BigEndian, uint16(arg))
+	case arg <= math.MaxUint32:
+		enc.buf.WriteByte(major | 26)
+		binary.Write(&enc.buf, binary.BigEndian, uint32(arg))
+	default:
+		enc.buf.WriteByte(major | 27)
+		binary.Write(&enc.buf, binary.BigEndian, arg)
+	}
+}
+
+func (enc *cborEncoder) writeString(s string) {
+	enc.writeHead(3, uint64(len(s)))
+	enc.buf.WriteString(s)
+}
+
+func (enc *cborEncoder) writeValue(v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		enc.buf.WriteByte(0xf6)
+	case bool:
+		if v {
+			enc.buf.WriteByte(0xf5)
+		} else {
+			enc.buf.WriteByte(0xf4)
+		}
+	case string:
+		enc.writeString(v)
+	case json.Number:
+		enc.writeNumber(v)
+	case []interface{}:
+		enc.writeHead(4, uint64(len(v)))
+		for _, item := range v {
+			err := enc.writeValue(item)
+			if err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		enc.writeHead(5, uint64(len(keys)))
+		for _, k := range keys {
+			enc.writeString(k)
+			err := enc.writeValue(v[k])
+			if err != nil {
+				return err
+			}
+		}
+	case Ref:
+		return enc.writeRef(v)
+	default:
+		return fmt.Errorf("unexpected value %v", v)
+	}
+	return nil
+}
+
+// writeRef writes the node if it's encountered for the first time, a shared
+// reference to it otherwise.
+func (enc *cborEncoder) writeRef(ref Ref) error {
+	if idx, ok := enc.shared[ref]; ok {
+		enc.writeHead(6, cborTagSharedRef)
+		enc.writeHead(0, idx)
+		return nil
+	}
+	if enc.w != nil {
+		wn, ok := enc.nodes[ref]
+		if !ok {
+			return fmt.Errorf("dangling reference %s", ref)
+		}
+		enc.shared[ref] = uint64(len(enc.shared))
+		enc.writeHead(6, cborTagShareable)
+		flds := enc.w.fields(wn)
+		sort.Slice(flds, func(i, j int) bool { return flds[i].name < flds[j].name })
+		enc.writeHead(5, uint64(len(flds)))
+		for _, f := range flds {
+			enc.writeString(f.name)
+			err := enc.writeReflect(wn.val.Field(f.index))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	n := enc.doc.Resolve(ref)
+	if n == nil {
+		return fmt.Errorf("dangling reference %s", ref)
+	}
+	enc.shared[ref] = uint64(len(enc.shared))
+	enc.writeHead(6, cborTagShareable)
+	names := n.FieldNames()
+	enc.writeHead(5, uint64(len(names)))
+	for _, name := range names {
+		fv, err := n.Value(name)
+		if err != nil {
+			return err
+		}
+		enc.writeString(name)
+		err = enc.writeValue(fv)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeReflect writes the Go value the same way as writeValue would write
+// its JSON representation. Values with custom marshalers and the values
+// that encoding/json treats specially are converted to JSON first.
+func (enc *cborEncoder) writeReflect(v reflect.Value) error {
+	if v.CanAddr() && v.Addr().Type().Implements(marshalerType) {
+		return enc.writeJSON(v)
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			enc.buf.WriteByte(0xf6)
+			return nil
+		}
+		if !enc.w.enc.isNodeType(v.Elem().Type()) {
+			return enc.writeReflect(v.Elem())
+		}
+		ref, _ := enc.w.ref(v)
+		return enc.writeRef(ref)
+	case reflect.Interface:
+		if v.IsNil() {
+			enc.buf.WriteByte(0xf6)
+			return nil
+		}
+		tp := v.Elem().Elem().Type()
+		if !enc.w.enc.isNodeType(tp) {
+			return fmt.Errorf("object behind an interface is not a node, it is %v", tp)
+		}
+		ref, _ := enc.w.ref(v)
+		return enc.writeRef(ref)
+	case reflect.Struct:
+		tp := v.Type()
+		var names []string
+		flds := make(map[string]reflect.Value)
+		for i := 0; i < v.NumField(); i++ {
+			ft := getFieldTags(tp.Field(i))
+			if ft.ignore || ft.omitEmpty && v.Field(i).IsZero() {
+				continue
+			}
+			names = append(names, ft.name)
+			flds[ft.name] = v.Field(i)
+		}
+		sort.Strings(names)
+		enc.writeHead(5, uint64(len(names)))
+		for _, name := range names {
+			enc.writeString(name)
+			err := enc.writeReflect(flds[name])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			enc.buf.WriteByte(0xf6)
+			return nil
+		}
+		if v.Type() == reflect.TypeOf([]byte{}) {
+			return enc.writeJSON(v)
+		}
+		enc.writeHead(4, uint64(v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			err := enc.writeReflect(v.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		if v.IsNil() {
+			enc.buf.WriteByte(0xf6)
+			return nil
+		}
+		keys := sortedMapKeys(v)
+		enc.writeHead(5, uint64(len(keys)))
+		for _, k := range keys {
+			enc.writeString(fmt.Sprintf("%v", k.Interface()))
+			err := enc.writeReflect(v.MapIndex(k))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if v.Type().Implements(marshalerType) || v.Type().Implements(textMarshalerType) {
+		return enc.writeJSON(v)
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return enc.writeValue(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i := v.Int(); i >= 0 {
+			enc.writeHead(0, uint64(i))
+		} else {
+			enc.writeHead(1, uint64(-(i + 1)))
+		}
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		enc.writeHead(0, v.Uint())
+		return nil
+	case reflect.Float32, reflect.Float64:
+		// NaN and infinities are rejected by encoding/json.
+		if f := v.Float(); !math.IsNaN(f) && !math.IsInf(f, 0) {
+			enc.writeNumber(json.Number(floatText(f, v.Type().Bits())))
+			return nil
+		}
+	case reflect.String:
+		// Invalid UTF-8 is replaced by encoding/json.
+		if utf8.ValidString(v.String()) {
+			enc.writeString(v.String())
+			return nil
+		}
+	}
+	return enc.writeJSON(v)
+}
+
+// writeJSON converts the value to JSON, as Marshal would do, and writes it.
+func (enc *cborEncoder) writeJSON(v reflect.Value) error {
+	b, err := enc.w.marshalValue(v)
+	if err != nil {
+		return err
+	}
+	val, err := decodeValue(b)
+	if err != nil {
+		return err
+	}
+	return enc.writeValue(val)
+}
+
+func (enc *cborEncoder) writeNumber(n json.Number) {
+	num := parseNumber(n)
+	switch num.kind {
+	case uintNumber:
+		enc.writeHead(0, num.u)
+	case intNumber:
+		enc.writeHead(1, uint64(-(num.i + 1)))
+	case float32Number:
+		enc.buf.WriteByte(0xfa)
+		binary.Write(&enc.buf, binary.BigEndian, math.Float32bits(float32(num.f)))
+	default:
+		enc.buf.WriteByte(0xfb)
+		binary.Write(&enc.buf, binary.BigEndian, math.Float64bits(num.f))
+	}
+}
+
+type cborDecoder struct {
+	b      []byte
+	pos    int
+	shared []*sharedValue
+}
+
+var errCBORBreak = fmt.Errorf("unexpected break")
+
+func (dec *cborDecoder) readHead() (byte, byte, uint64, error) {
+	if dec.pos >= len(dec.b) {
+		return 0, 0, 0, fmt.Errorf("unexpected end of CBOR data")
+	}
+	ib := dec.b[dec.pos]
+	dec.pos++
+	major, info := ib>>5, ib&0x1f
+	var size int
+	switch {
+	case info < 24:
+		return major, info, uint64(info), nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	case info == 31:
+		return major, info, 0, nil
+	default:
+		return 0, 0, 0, fmt.Errorf("invalid CBOR additional information %d", info)
+	}
+	if dec.pos+size > len(dec.b) {
+		return 0, 0, 0, fmt.Errorf("unexpected end of CBOR data")
+	}
+	var arg uint64
+	for _, c := range dec.b[dec.pos : dec.pos+size] {
+		arg = arg<<8 | uint64(c)
+	}
+	dec.pos += size
+	return major, info, arg, nil
+}
+
+func (dec *cborDecoder) readValue() (interface{}, error) {
+	major, info, arg, err := dec.readHead()
+	if err != nil {
+		return nil, err
+	}
+	indefinite := info == 31
+	switch major {
+	case 0:
+		return json.Number(strconv.FormatUint(arg, 10)), nil
+	case 1:
+		if arg == math.MaxUint64 {
+			return json.Number("-18446744073709551616"), nil
+		}
+		return json.Number("-" + strconv.FormatUint(arg+1, 10)), nil
+	case 2, 3:
+		var s []byte
+		if indefinite {
+			for {
+				chunk, err := dec.readValue()
+				if err == errCBORBreak {
+					break
+				}
+				if err != nil {
+					return nil, err
+				}
+				str, ok := chunk.(string)
+				if !ok {
+					return nil, fmt.Errorf("invalid chunk of indefinite length string")
+				}
+				s = append(s, str...)
+			}
+		} else {
+			if arg > uint64(len(dec.b)-dec.pos) {
+				return nil, fmt.Errorf("unexpected end of CBOR data")
+			}
+			s = dec.b[dec.pos : dec.pos+int(arg)]
+			dec.pos += int(arg)
+		}
+		if major == 3 && !utf8.Valid(s) {
+			return nil, fmt.Errorf("invalid UTF-8 in CBOR text string")
+		}
+		// Byte strings are not produced by the encoder, treat them as text.
+		return string(s), nil
+	case 4:
+		s := []interface{}{}
+		for i := uint64(0); indefinite || i < arg; i++ {
+			item, err := dec.readValue()
+			if err == errCBORBreak && indefinite {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, item)
+		}
+		return s, nil
+	case 5:
+		m := make(map[string]interface{})
+		for i := uint64(0); indefinite || i < arg; i++ {
+			k, err := dec.readValue()
+			if err == errCBORBreak && indefinite {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("CBOR map keys must be strings")
+			}
+			v, err := dec.readValue()
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+		}
+		return m, nil
+	case 6:
+		switch arg {
+		case cborTagShareable:
+			sv := &sharedValue{label: fmt.Sprintf("#%d", len(dec.shared))}
+			dec.shared = append(dec.shared, sv)
+			sv.v, err = dec.readValue()
+			if err != nil {
+				return nil, err
+			}
+			return sv, nil
+		case cborTagSharedRef:
+			idx, err := dec.readValue()
+			if err != nil {
+				return nil, err
+			}
+			i, err := strconv.Atoi(fmt.Sprintf("%v", idx))
+			if err != nil || i < 0 || i >= len(dec.shared) {
+				return nil, fmt.Errorf("invalid shared reference %v", idx)
+			}
+			return dec.shared[i], nil
+		case cborTagSelfDescribe:
+			return dec.readValue()
+		}
+		return nil, fmt.Errorf("unsupported CBOR tag %d", arg)
+	default:
+		switch {
+		case info == 20:
+			return false, nil
+		case info == 21:
+			return true, nil
+		case info == 22 || info == 23:
+			return nil, nil
+		case info == 25:
+			return json.Number(floatText(float64(halfToFloat(uint16(arg))), 32)), nil
+		case info == 26:
+			return json.Number(floatText(float64(math.Float32frombits(uint32(arg))), 32)), nil
+		case info == 27:
+			return json.Number(floatText(math.Float64frombits(arg), 64)), nil
+		case info == 31:
+			return nil, errCBORBreak
+		}
+		return nil, fmt.Errorf("unsupported CBOR simple value %d", arg)
+	}
+}
+
+func halfToFloat(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := uint32(h>>10) & 0x1f
+	frac := uint32(h) & 0x3ff
+	switch exp {
+	case 0:
+		f := float32(frac) / (1 << 24)
+		if sign != 0 {
+			f = -f
+		}
+		return f
+	case 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | frac<<13)
+	}
+	return math.Float32frombits(sign | (exp+112)<<23 | frac<<13)
+}
@@ -0,0 +1,245 @@
+package grison
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCBORMinimal(t *testing.T) {
+	type Node struct {
+		A int
+		N *Node
+	}
+	type Master struct {
+		Node []*Node
+	}
+	m := &Master{Node: []*Node{{A: 2}}}
+	m.Node[0].N = m.Node[0]
+	b, err := MarshalCBOR(m, MarshalOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// {"Node": {"#1": 28({"A": 2, "N": 29(0)})}}
+	expected := "a1644e6f6465a1622331d81ca2614102614ed81d00"
+	if hex.EncodeToString(b) != expected {
+		t.Errorf("unexpected CBOR.\nexpect=%s\nactual=%s", expected, hex.EncodeToString(b))
+	}
+}
+
+func TestCBORJSONRoundTrip(t *testing.T) {
+	docs := []string{
+		exampleDoc,
+		`{"Node":{"#1":{"A":-42,"B":42,"C":1.1,"D":true,"E":"foo","F":"AQID","G":[4,5,6],"H":null}}}`,
+		`{"Node":{"#1":{"A":0.1,"B":1e+21,"C":-0,"D":18446744073709551615,"E":-9223372036854775808,"F":3.141592653589793}}}`,
+		`{"Node":{"#1":{"A":{"x":[[],{}],"y":{"$ref":"Node:#1"}}}}}`,
+	}
+	for _, s := range docs {
+		doc, err := ParseDocument([]byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := doc.MarshalCBOR()
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc2, err := ParseCBORDocument(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		j, err := doc2.Marshal(MarshalOpts{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(j) != s {
+			t.Errorf("unexpected round trip result.\nexpect=%s\nactual=%s", s, string(j))
+		}
+	}
+}
+
+func TestCBORMaster(t *testing.T) {
+	m := newGraphMaster()
+	b, err := MarshalCBOR(m, MarshalOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m2 graphMaster
+	err = UnmarshalCBOR(b, &m2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, &m2) {
+		t.Errorf("unexpected unmarshal result")
+	}
+}
+
+func TestCBORDecode(t *testing.T) {
+	// Indefinite lengths, half floats and the self-describe tag.
+	b, _ := hex.DecodeString("d9d9f7bf644e6f6465bf622331d81cbf6141f93e00614a9f01ff6173" +
+		"7f626162626163ffffffff")
+	doc, err := ParseCBORDocument(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := doc.Marshal(MarshalOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"Node":{"#1":{"A":1.5,"J":[1],"s":"abac"}}}`
+	if string(j) != expected {
+		t.Errorf("unexpected decode result.\nexpect=%s\nactual=%s", expected, string(j))
+	}
+	for _, s := range []string{"a1", "a1644e6f6465a1622331d81d05", "a1644e6f6465a1622331a1614101ff"} {
+		b, _ := hex.DecodeString(s)
+		_, err = ParseCBORDocument(b)
+		if err == nil {
+			t.Errorf("invalid CBOR %s was accepted", s)
+		}
+	}
+}
+
+type cborNode struct {
+	Key     int `grison:",id"`
+	P       Prop
+	PP      *Prop
+	F       float64
+	G       float32
+	U       uint64
+	I       int64
+	S       string
+	B       []byte
+	Bytes   namedBytes
+	Arr     [2]int8
+	M       map[string]int
+	T       time.Time
+	Any     interface{}
+	Next    *cborNode
+	Nested  struct{ N []*cborNode }
+	Skipped string `grison:",omitempty"`
+}
+
+type namedBytes []byte
+
+type cborMaster struct {
+	Nodes []*cborNode
+	Other []*graphNode `grison:",omitempty"`
+}
+
+func TestCBORDirect(t *testing.T) {
+	m := &cborMaster{Nodes: []*cborNode{
+		{Key: 2, P: 1, F: 3, G: 0.1, U: math.MaxUint64, I: math.MinInt64, S: "bad \xff utf-8",
+			B: []byte{1, 2}, Bytes: namedBytes{3}, Arr: [2]int8{-1, 1}, M: map[string]int{"b": 10, "a": 9},
+			T: time.Unix(1600000000, 0).UTC()},
+		{Key: 1, F: -0.5, G: 1e30},
+	}}
+	m.Nodes[0].Any = m.Nodes[1]
+	m.Nodes[1].Next = m.Nodes[0]
+	m.Nodes[1].Nested.N = []*cborNode{nil, m.Nodes[1]}
+	// Encoding straight from the master yields the same as encoding the document.
+	for _, m := range []interface{}{m, newGraphMaster(), newProtoMaster()} {
+		for _, opts := range []MarshalOpts{{}, {ContentIDs: true}} {
+			if opts.ContentIDs && reflect.TypeOf(m) == reflect.TypeOf(&cborMaster{}) {
+				continue
+			}
+			b, err := MarshalCBOR(m, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			doc, err := MarshalDocument(m, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected, err := doc.MarshalCBOR()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(b, expected) {
+				t.Errorf("unexpected CBOR.\nexpect=%x\nactual=%x", expected, b)
+			}
+			// Decoding straight into the master yields the same as
+			// decoding the document.
+			m1 := reflect.New(reflect.TypeOf(m).Elem()).Interface()
+			err = UnmarshalCBOR(b, m1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m2 := reflect.New(reflect.TypeOf(m).Elem()).Interface()
+			err = UnmarshalDocument(doc, m2, UnmarshalOpts{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(m1, m2) {
+				t.Errorf("unexpected unmarshal result %+v", m1)
+			}
+		}
+	}
+}
+
+func benchmarkMaster(n int) *graphMaster {
+	m := &graphMaster{}
+	for i := 0; i < n; i++ {
+		m.Nodes = append(m.Nodes, &graphNode{Name: fmt.Sprintf("node %d", i), Age: i, Weight: float64(i) / 3})
+	}
+	for i, node := range m.Nodes {
+		node.Next = m.Nodes[(i+1)%n]
+		node.List = []*graphNode{m.Nodes[(i*7)%n], m.Nodes[(i*13)%n]}
+	}
+	return m
+}
+
+func BenchmarkMarshalJSON(b *testing.B) {
+	m := benchmarkMaster(1000)
+	var size int
+	for i := 0; i < b.N; i++ {
+		out, err := Marshal(m)
+		if err != nil {
+			b.Fatal(err)
+		}
+		size = len(out)
+	}
+	b.ReportMetric(float64(size), "bytes")
+}
+
+func BenchmarkMarshalCBOR(b *testing.B) {
+	m := benchmarkMaster(1000)
+	var size int
+	for i := 0; i < b.N; i++ {
+		out, err := MarshalCBOR(m, MarshalOpts{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		size = len(out)
+	}
+	b.ReportMetric(float64(size), "bytes")
+}
+
+func BenchmarkUnmarshalJSON(b *testing.B) {
+	in, err := Marshal(benchmarkMaster(1000))
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < b.N; i++ {
+		var m graphMaster
+		err = Unmarshal(in, &m)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalCBOR(b *testing.B) {
+	in, err := MarshalCBOR(benchmarkMaster(1000), MarshalOpts{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < b.N; i++ {
+		var m graphMaster
+		err = UnmarshalCBOR(in, &m)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
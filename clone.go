@@ -0,0 +1,122 @@
+/*
+	Copyright (c) 2020 Martin Sustrik
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy
+	of this software and associated documentation files (the "Software"),
+	to deal in the Software without restriction, including without limitation
+	the rights to use, copy, modify, merge, publish, distribute, sublicense,
+	and/or sell copies of the Software, and to permit persons to whom
+	the Software is furnished to do so, subject to the following conditions:
+	The above copyright notice and this permission notice shall be included
+	in all copies or substantial portions of the Software.
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+	FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+	IN THE SOFTWARE.
+*/
+
+package grison
+
+import (
+	"reflect"
+)
+
+// Clone returns a deep copy of the graph reachable from the master structure.
+// Nodes referenced from multiple places are copied once, so sharing and
+// cycles are preserved. The returned master structure has the same type as
+// the original one. The second return value maps pointers to the original
+// nodes to pointers to their copies. Fields marked with `grison:"-"` are
+// copied shallowly, i.e. the copy points to the same data as the original.
+func Clone(m interface{}) (interface{}, map[interface{}]interface{}, error) {
+	tps, _, _, err := scrapeMasterStruct(m, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	c := &cloner{types: tps, copies: make(map[interface{}]interface{})}
+	ms := reflect.ValueOf(m).Elem()
+	res := reflect.New(ms.Type())
+	res.Elem().Set(ms)
+	for i := 0; i < ms.NumField(); i++ {
+		if getFieldTags(ms.Type().Field(i)).ignore {
+			continue
+		}
+		res.Elem().Field(i).Set(c.copy(ms.Field(i)))
+	}
+	return res.Interface(), c.copies, nil
+}
+
+type cloner struct {
+	// Node types (the structs, not the pointers).
+	types map[reflect.Type]string
+	// Map of pointers to original nodes to pointers to their copies.
+	copies map[interface{}]interface{}
+}
+
+// copy returns a deep copy of the value.
+func (c *cloner) copy(v reflect.Value) reflect.Value {
+	res := reflect.New(v.Type()).Elem()
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return res
+		}
+		if _, ok := c.types[v.Type().Elem()]; ok {
+			return c.copyNode(v)
+		}
+		res.Set(reflect.New(v.Type().Elem()))
+		res.Elem().Set(c.copy(v.Elem()))
+	case reflect.Interface:
+		if v.IsNil() {
+			return res
+		}
+		res.Set(c.copy(v.Elem()))
+	case reflect.Struct:
+		res.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			fld := v.Type().Field(i)
+			if fld.PkgPath != "" || getFieldTags(fld).ignore {
+				continue
+			}
+			res.Field(i).Set(c.copy(v.Field(i)))
+		}
+	case reflect.Slice:
+		if v.IsNil() {
+			return res
+		}
+		res.Set(reflect.MakeSlice(v.Type(), v.Len(), v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			res.Index(i).Set(c.copy(v.Index(i)))
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			res.Index(i).Set(c.copy(v.Index(i)))
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			return res
+		}
+		res.Set(reflect.MakeMapWithSize(v.Type(), v.Len()))
+		iter := v.MapRange()
+		for iter.Next() {
+			res.SetMapIndex(c.copy(iter.Key()), c.copy(iter.Value()))
+		}
+	default:
+		res.Set(v)
+	}
+	return res
+}
+
+// copyNode returns the copy of the node, creating it if needed.
+func (c *cloner) copyNode(v reflect.Value) reflect.Value {
+	if cp, ok := c.copies[v.Interface()]; ok {
+		return reflect.ValueOf(cp)
+	}
+	cp := reflect.New(v.Type().Elem())
+	// Register the copy before copying the fields to handle cycles.
+	c.copies[v.Interface()] = cp.Interface()
+	cp.Elem().Set(c.copy(v.Elem()))
+	return cp
+}
@@ -0,0 +1,46 @@
+package grison
+
+import (
+	"testing"
+)
+
+func TestClone(t *testing.T) {
+	m := newGraphMaster()
+	res, copies, err := Clone(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := res.(*graphMaster)
+	eq, diff, err := Equal(m, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !eq {
+		t.Errorf("clone differs: %s", diff)
+	}
+	if len(copies) != len(m.Nodes) {
+		t.Errorf("unexpected number of copies %d", len(copies))
+	}
+	for i, n := range m.Nodes {
+		if c.Nodes[i] == n || copies[n] != c.Nodes[i] {
+			t.Errorf("node %d not copied", i)
+		}
+	}
+	// Sharing and cycles are preserved.
+	if c.Nodes[0].List[2] != c.Nodes[0] || c.Nodes[1].Prev != c.Nodes[0] || c.Nodes[1].Map["first"] != c.Nodes[0] {
+		t.Errorf("references not preserved")
+	}
+	// The copy is independent of the original.
+	c.Nodes[0].Tags[0] = "changed"
+	c.Nodes[1].Map["second"] = c.Nodes[1]
+	if m.Nodes[0].Tags[0] != "x" || len(m.Nodes[1].Map) != 2 {
+		t.Errorf("original modified")
+	}
+}
+
+func TestCloneError(t *testing.T) {
+	_, _, err := Clone(graphMaster{})
+	if err == nil {
+		t.Errorf("expected error")
+	}
+}
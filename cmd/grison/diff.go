@@ -0,0 +1,65 @@
+/*
+	Copyright (c) 2020 Martin Sustrik
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy
+	of this software and associated documentation files (the "Software"),
+	to deal in the Software without restriction, including without limitation
+	the rights to use, copy, modify, merge, publish, distribute, sublicense,
+	and/or sell copies of the Software, and to permit persons to whom
+	the Software is furnished to do so, subject to the following conditions:
+	The above copyright notice and this permission notice shall be included
+	in all copies or substantial portions of the Software.
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+	FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+	IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sustrik/grison"
+)
+
+const diffUsage = "diff <old> <new>"
+
+func runDiff(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: grison %s", diffUsage)
+	}
+	n, err := diffFiles(os.Stdout, args[0], args[1])
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return fmt.Errorf("files differ")
+	}
+	return nil
+}
+
+// diffFiles writes the changes between the files and returns their number.
+func diffFiles(w io.Writer, oldName string, newName string) (int, error) {
+	a, err := loadDocument(oldName)
+	if err != nil {
+		return 0, err
+	}
+	b, err := loadDocument(newName)
+	if err != nil {
+		return 0, err
+	}
+	changes, err := grison.Diff(a, b)
+	if err != nil {
+		return 0, err
+	}
+	for _, c := range changes {
+		fmt.Fprintln(w, c)
+	}
+	return len(changes), nil
+}
@@ -0,0 +1,75 @@
+/*
+	Copyright (c) 2020 Martin Sustrik
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy
+	of this software and associated documentation files (the "Software"),
+	to deal in the Software without restriction, including without limitation
+	the rights to use, copy, modify, merge, publish, distribute, sublicense,
+	and/or sell copies of the Software, and to permit persons to whom
+	the Software is furnished to do so, subject to the following conditions:
+	The above copyright notice and this permission notice shall be included
+	in all copies or substantial portions of the Software.
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+	FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+	IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sustrik/grison"
+)
+
+const dotUsage = "dot [-types T,...] [-fields T.F,...] [-root T:ID] [-depth n] <file>"
+
+func runDOT(args []string) error {
+	fs := flag.NewFlagSet("dot", flag.ContinueOnError)
+	types := fs.String("types", "", "comma-separated list of collections to include")
+	fields := fs.String("fields", "", "comma-separated list of fields to show in labels, in Collection.Field format")
+	root := fs.String("root", "", "show only nodes around this node")
+	depth := fs.Int("depth", 1, "maximum distance from the root node")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: grison %s", dotUsage)
+	}
+	doc, err := loadDocument(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	opts := grison.DOTOpts{
+		Fields: make(map[string][]string),
+		Depth:  *depth,
+	}
+	if *types != "" {
+		opts.Types = strings.Split(*types, ",")
+	}
+	if *fields != "" {
+		for _, f := range strings.Split(*fields, ",") {
+			parts := strings.SplitN(f, ".", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("field %s is not in Collection.Field format", f)
+			}
+			opts.Fields[parts[0]] = append(opts.Fields[parts[0]], parts[1])
+		}
+	}
+	if *root != "" {
+		ref, err := grison.ParseRef(*root)
+		if err != nil {
+			return err
+		}
+		opts.Root = &ref
+	}
+	return doc.WriteDOT(os.Stdout, opts)
+}
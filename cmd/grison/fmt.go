@@ -0,0 +1,70 @@
+/*
+	Copyright (c) 2020 Martin Sustrik
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy
+	of this software and associated documentation files (the "Software"),
+	to deal in the Software without restriction, including without limitation
+	the rights to use, copy, modify, merge, publish, distribute, sublicense,
+	and/or sell copies of the Software, and to permit persons to whom
+	the Software is furnished to do so, subject to the following conditions:
+	The above copyright notice and this permission notice shall be included
+	in all copies or substantial portions of the Software.
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+	FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+	IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/sustrik/grison"
+)
+
+const fmtUsage = "fmt [-w] [-indent str] [-compact] <file>..."
+
+func runFmt(args []string) error {
+	fs := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	write := fs.Bool("w", false, "write the result to the file instead of the standard output")
+	indent := fs.String("indent", "    ", "indentation string")
+	compact := fs.Bool("compact", false, "produce compact output with no indentation")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: grison %s", fmtUsage)
+	}
+	for _, name := range fs.Args() {
+		doc, err := loadDocument(name)
+		if err != nil {
+			return err
+		}
+		opts := grison.MarshalOpts{Indent: *indent}
+		if *compact {
+			opts.Indent = ""
+		}
+		b, err := doc.Marshal(opts)
+		if err != nil {
+			return err
+		}
+		b = append(b, '\n')
+		if *write && name != "-" {
+			err = ioutil.WriteFile(name, b, 0644)
+		} else {
+			_, err = os.Stdout.Write(b)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
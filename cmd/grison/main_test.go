@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sustrik/grison"
+)
+
+const testDoc = `{"Node":{"#1":{"N":{"$ref":"Node:#2"}},"#2":{"N":[{"$ref":"Node:#3"}]}}}`
+
+func writeTestFile(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "grison-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	name := filepath.Join(dir, "test.json")
+	err = ioutil.WriteFile(name, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestValidateFile(t *testing.T) {
+	problems, err := validateFile(writeTestFile(t, testDoc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 || problems[0] != "1:51: Node:#2: N[0]: dangling reference Node:#3" {
+		t.Errorf("unexpected problems: %v", problems)
+	}
+}
+
+func TestWritePages(t *testing.T) {
+	doc, err := loadDocument(writeTestFile(t, testDoc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "grison-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = writePages(doc, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"index.html", "type_4e6f6465.html", nodePage(grison.Ref{Type: "Node", ID: "#1"}), nodePage(grison.Ref{Type: "Node", ID: "#2"})} {
+		_, err = os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("page not generated: %v", err)
+		}
+	}
+}
+
+func TestDiffFiles(t *testing.T) {
+	a := writeTestFile(t, testDoc)
+	b := writeTestFile(t, `{"Node":{"#1":{"N":{"$ref":"Node:#2"}},"#2":{"N":[null]}}}`)
+	var buf bytes.Buffer
+	n, err := diffFiles(&buf, a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "~ Node:#2 N[0]: Node:#3 -> null\n"
+	if n != 1 || buf.String() != expected {
+		t.Errorf("unexpected diff %q", buf.String())
+	}
+}
+
+func TestMergeFiles(t *testing.T) {
+	base := writeTestFile(t, `{"P":{"#1":{"A":1,"B":1}}}`)
+	ours := writeTestFile(t, `{"P":{"#1":{"A":2,"B":1}}}`)
+	theirs := writeTestFile(t, `{"P":{"#1":{"A":3,"B":2}}}`)
+	var buf bytes.Buffer
+	n, err := mergeFiles(&buf, base, ours, theirs, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || buf.String() != "conflict: set P:#1 A: expected 1, found 2\n" {
+		t.Errorf("unexpected conflicts %q", buf.String())
+	}
+	b, err := ioutil.ReadFile(ours)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"P":{"#1":{"A":2,"B":2}}}`+"\n" {
+		t.Errorf("unexpected result %s", b)
+	}
+}
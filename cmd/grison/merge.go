@@ -0,0 +1,90 @@
+/*
+	Copyright (c) 2020 Martin Sustrik
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy
+	of this software and associated documentation files (the "Software"),
+	to deal in the Software without restriction, including without limitation
+	the rights to use, copy, modify, merge, publish, distribute, sublicense,
+	and/or sell copies of the Software, and to permit persons to whom
+	the Software is furnished to do so, subject to the following conditions:
+	The above copyright notice and this permission notice shall be included
+	in all copies or substantial portions of the Software.
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+	FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+	IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/sustrik/grison"
+)
+
+const mergeDriverUsage = "merge-driver [-indent str] <base> <ours> <theirs>"
+
+// runMergeDriver implements git's custom merge driver protocol. It's meant
+// to be configured as "grison merge-driver %O %A %B". The result is written
+// to the ours file and the command fails if there are conflicts.
+func runMergeDriver(args []string) error {
+	fs := flag.NewFlagSet("merge-driver", flag.ContinueOnError)
+	indent := fs.String("indent", "    ", "indentation string")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 3 {
+		return fmt.Errorf("usage: grison %s", mergeDriverUsage)
+	}
+	n, err := mergeFiles(os.Stderr, fs.Arg(0), fs.Arg(1), fs.Arg(2), *indent)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return fmt.Errorf("%d conflicts", n)
+	}
+	return nil
+}
+
+// mergeFiles merges the changes into the ours file, writes the conflicts
+// and returns their number.
+func mergeFiles(w io.Writer, baseName string, oursName string, theirsName string, indent string) (int, error) {
+	base, err := loadDocument(baseName)
+	if err != nil {
+		return 0, err
+	}
+	ours, err := loadDocument(oursName)
+	if err != nil {
+		return 0, err
+	}
+	theirs, err := loadDocument(theirsName)
+	if err != nil {
+		return 0, err
+	}
+	doc, conflicts, err := grison.Merge(base, ours, theirs)
+	if err != nil {
+		return 0, err
+	}
+	for _, c := range conflicts {
+		fmt.Fprintf(w, "conflict: %v\n", c)
+	}
+	b, err := doc.Marshal(grison.MarshalOpts{Indent: indent})
+	if err != nil {
+		return 0, err
+	}
+	b = append(b, '\n')
+	err = ioutil.WriteFile(oursName, b, 0644)
+	if err != nil {
+		return 0, err
+	}
+	return len(conflicts), nil
+}
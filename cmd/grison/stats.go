@@ -0,0 +1,81 @@
+/*
+	Copyright (c) 2020 Martin Sustrik
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy
+	of this software and associated documentation files (the "Software"),
+	to deal in the Software without restriction, including without limitation
+	the rights to use, copy, modify, merge, publish, distribute, sublicense,
+	and/or sell copies of the Software, and to permit persons to whom
+	the Software is furnished to do so, subject to the following conditions:
+	The above copyright notice and this permission notice shall be included
+	in all copies or substantial portions of the Software.
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+	FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+	IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+type collectionStats struct {
+	nodes        int
+	fields       int
+	refs         int
+	dangling     int
+	unreferenced int
+}
+
+const statsUsage = "stats <file>"
+
+func runStats(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: grison %s", statsUsage)
+	}
+	doc, err := loadDocument(args[0])
+	if err != nil {
+		return err
+	}
+	stats := make(map[string]*collectionStats)
+	for _, tp := range doc.Types() {
+		st := &collectionStats{}
+		stats[tp] = st
+		for _, n := range doc.Nodes(tp) {
+			st.nodes++
+			st.fields += len(n.FieldNames())
+			for _, e := range n.Refs() {
+				st.refs++
+				if e.Target() == nil {
+					st.dangling++
+				}
+			}
+			if len(n.Backrefs()) == 0 {
+				st.unreferenced++
+			}
+		}
+	}
+	var total collectionStats
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "collection\tnodes\tfields\trefs\tdangling\tunreferenced\t\n")
+	for _, tp := range doc.Types() {
+		st := stats[tp]
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t\n",
+			tp, st.nodes, st.fields, st.refs, st.dangling, st.unreferenced)
+		total.nodes += st.nodes
+		total.fields += st.fields
+		total.refs += st.refs
+		total.dangling += st.dangling
+		total.unreferenced += st.unreferenced
+	}
+	fmt.Fprintf(w, "total\t%d\t%d\t%d\t%d\t%d\t\n",
+		total.nodes, total.fields, total.refs, total.dangling, total.unreferenced)
+	return w.Flush()
+}
@@ -0,0 +1,66 @@
+/*
+	Copyright (c) 2020 Martin Sustrik
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy
+	of this software and associated documentation files (the "Software"),
+	to deal in the Software without restriction, including without limitation
+	the rights to use, copy, modify, merge, publish, distribute, sublicense,
+	and/or sell copies of the Software, and to permit persons to whom
+	the Software is furnished to do so, subject to the following conditions:
+	The above copyright notice and this permission notice shall be included
+	in all copies or substantial portions of the Software.
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+	FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+	IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sustrik/grison"
+)
+
+const validateUsage = "validate <file>..."
+
+func runValidate(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: grison %s", validateUsage)
+	}
+	failed := false
+	for _, name := range args {
+		problems, err := validateFile(name)
+		if err != nil {
+			return err
+		}
+		for _, p := range problems {
+			fmt.Fprintf(os.Stderr, "%s:%s\n", name, p)
+		}
+		if len(problems) > 0 {
+			failed = true
+		}
+	}
+	if failed {
+		return fmt.Errorf("validation failed")
+	}
+	return nil
+}
+
+// validateFile returns the list of problems found in the file.
+func validateFile(name string) ([]string, error) {
+	b, err := readInput(name)
+	if err != nil {
+		return nil, err
+	}
+	var problems []string
+	for _, e := range grison.Validate(b) {
+		problems = append(problems, e.Error())
+	}
+	return problems, nil
+}
@@ -0,0 +1,170 @@
+/*
+	Copyright (c) 2020 Martin Sustrik
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy
+	of this software and associated documentation files (the "Software"),
+	to deal in the Software without restriction, including without limitation
+	the rights to use, copy, modify, merge, publish, distribute, sublicense,
+	and/or sell copies of the Software, and to permit persons to whom
+	the Software is furnished to do so, subject to the following conditions:
+	The above copyright notice and this permission notice shall be included
+	in all copies or substantial portions of the Software.
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+	FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+	IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/sustrik/grison"
+	"github.com/sustrik/grison/browse"
+)
+
+const pageHeader = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="utf-8">
+    <title>grison view</title>
+    <style>
+        body {
+            font-family: "Courier New", Courier, monospace
+        }
+        a {
+            color: red;
+        }
+        .prop {
+            color: blue;
+        }
+    </style>
+</head>
+<body>
+`
+
+const pageFooter = `</body>
+</html>
+`
+
+const viewUsage = "view [-o dir] [-no-open] <file>"
+
+func runView(args []string) error {
+	fs := flag.NewFlagSet("view", flag.ContinueOnError)
+	out := fs.String("o", "", "directory to write the pages to (default: a new temporary directory)")
+	noOpen := fs.Bool("no-open", false, "don't open the pages in a web browser")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: grison %s", viewUsage)
+	}
+	doc, err := loadDocument(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	dir := *out
+	if dir == "" {
+		dir, err = ioutil.TempDir("", "grison-view-")
+		if err != nil {
+			return err
+		}
+	} else {
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			return err
+		}
+	}
+	err = writePages(doc, dir)
+	if err != nil {
+		return err
+	}
+	index := filepath.Join(dir, "index.html")
+	if *noOpen {
+		fmt.Println(index)
+		return nil
+	}
+	return openBrowser(index)
+}
+
+// writePages renders the document as a set of interlinked HTML pages.
+func writePages(doc *grison.Document, dir string) error {
+	var index strings.Builder
+	index.WriteString(pageHeader)
+	for _, tp := range doc.Types() {
+		fmt.Fprintf(&index, "<a href=\"%s\">%s</a><br>\n", typePage(tp), html.EscapeString(tp))
+	}
+	index.WriteString(pageFooter)
+	err := ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte(index.String()), 0644)
+	if err != nil {
+		return err
+	}
+	for _, tp := range doc.Types() {
+		var page strings.Builder
+		page.WriteString(pageHeader)
+		fmt.Fprintf(&page, "<h3>%s</h3>\n", html.EscapeString(tp))
+		for _, n := range doc.Nodes(tp) {
+			fmt.Fprintf(&page, "<a href=\"%s\">%s</a><br>\n", nodePage(n.Ref()), html.EscapeString(n.ID()))
+		}
+		page.WriteString(pageFooter)
+		err = ioutil.WriteFile(filepath.Join(dir, typePage(tp)), []byte(page.String()), 0644)
+		if err != nil {
+			return err
+		}
+	}
+	for _, n := range doc.AllNodes() {
+		var page strings.Builder
+		page.WriteString(pageHeader)
+		fmt.Fprintf(&page, "<h3>%s</h3>\n", html.EscapeString(n.Ref().String()))
+		for _, name := range n.FieldNames() {
+			v, err := n.Value(name)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(&page, "<span class=\"prop\">%s</span>: ", html.EscapeString(name))
+			browse.RenderValue(&page, doc, v, nodePage)
+			page.WriteString("<br>\n")
+		}
+		page.WriteString(pageFooter)
+		err = ioutil.WriteFile(filepath.Join(dir, nodePage(n.Ref())), []byte(page.String()), 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func typePage(tp string) string {
+	return fmt.Sprintf("type_%s.html", hex.EncodeToString([]byte(tp)))
+}
+
+func nodePage(ref grison.Ref) string {
+	return fmt.Sprintf("node_%s.html", hex.EncodeToString([]byte(ref.String())))
+}
+
+func openBrowser(path string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", path)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", path)
+	default:
+		cmd = exec.Command("xdg-open", path)
+	}
+	return cmd.Start()
+}
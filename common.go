@@ -23,6 +23,7 @@ package grison
 import (
 	"fmt"
 	"reflect"
+	"strconv"
 	"strings"
 )
 
@@ -65,6 +66,14 @@ func scrapeMasterStruct(m interface{}, getIDs bool) (map[reflect.Type]string, ma
 		if fldtp.Kind() != reflect.Struct {
 			return nil, nil, nil, fmt.Errorf("master field %s doesn't contain pointers to structs", fldname)
 		}
+		if i := idField(fldtp); i >= 0 {
+			switch fldtp.Field(i).Type.Kind() {
+			case reflect.String, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			default:
+				return nil, nil, nil, fmt.Errorf("ID field of %v must be a string or an integer", fldtp)
+			}
+		}
 		// TODO: Check for duplicate types.
 		tps[fldtp] = fldname
 		nms[fldname] = fldtp
@@ -80,7 +89,9 @@ func scrapeMasterStruct(m interface{}, getIDs bool) (map[reflect.Type]string, ma
 type fieldTags struct {
 	ignore    bool
 	omitEmpty bool
-	name      string
+	// The field holds the ID of the node.
+	id   bool
+	name string
 }
 
 func getFieldTags(fld reflect.StructField) fieldTags {
@@ -91,19 +102,59 @@ func getFieldTags(fld reflect.StructField) fieldTags {
 	if t == "-" {
 		return fieldTags{ignore: true}
 	}
-	parts := strings.SplitN(t, ",", 2)
+	parts := strings.Split(t, ",")
 	var ft fieldTags
-	if len(parts) == 0 || parts[0] == "" {
+	if parts[0] == "" {
 		ft.name = fld.Name
 	} else {
 		ft.name = parts[0]
 	}
-	if len(parts) == 2 && parts[1] == "omitempty" {
-		ft.omitEmpty = true
+	for _, opt := range parts[1:] {
+		switch opt {
+		case "omitempty":
+			ft.omitEmpty = true
+		case "id":
+			ft.id = true
+		}
 	}
 	return ft
 }
 
+// idField returns the index of the field tagged as the ID of the node
+// or -1 if there's no such field.
+func idField(tp reflect.Type) int {
+	for i := 0; i < tp.NumField(); i++ {
+		if getFieldTags(tp.Field(i)).id {
+			return i
+		}
+	}
+	return -1
+}
+
+// setIDField parses the ID and stores it in the field.
+func setIDField(fld reflect.Value, id string) error {
+	switch fld.Kind() {
+	case reflect.String:
+		fld.SetString(id)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(id, 10, fld.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid ID %q", id)
+		}
+		fld.SetInt(i)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(id, 10, fld.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid ID %q", id)
+		}
+		fld.SetUint(u)
+		return nil
+	}
+	return fmt.Errorf("ID field must be a string or an integer, it is %v", fld.Type())
+}
+
 func getFieldByName(v reflect.Value, name string) reflect.Value {
 	for i := 0; i < v.Type().NumField(); i++ {
 		fld := v.Type().Field(i)
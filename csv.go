@@ -0,0 +1,235 @@
+/*
+	Copyright (c) 2020 Martin Sustrik
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy
+	of this software and associated documentation files (the "Software"),
+	to deal in the Software without restriction, including without limitation
+	the rights to use, copy, modify, merge, publish, distribute, sublicense,
+	and/or sell copies of the Software, and to permit persons to whom
+	the Software is furnished to do so, subject to the following conditions:
+	The above copyright notice and this permission notice shall be included
+	in all copies or substantial portions of the Software.
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+	FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+	IN THE SOFTWARE.
+*/
+
+package grison
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// MarshalCSV converts the graph reachable from the master structure into
+// a set of CSV tables, keyed by table name. Each collection becomes a table
+// with a row per node. The first column, "id", contains the node ID, the
+// other columns contain the fields. Single references are stored as IDs of
+// the nodes they refer to. Slices and maps of references are stored in join
+// tables named "<collection>_<field>", with columns "id", "index" (or "key"
+// for maps) and "ref". The column in the node table contains the number
+// of elements. Empty cells stand for nil. References stored in interface
+// fields are in "Type:ID" format. Fields that are neither scalars nor
+// references are stored as JSON. Each table has a header row.
+func MarshalCSV(m interface{}, opts MarshalOpts) (map[string][]byte, error) {
+	tables, err := marshalRelational(m, opts)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string][]byte)
+	for _, t := range tables {
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		var header []string
+		for _, col := range t.columns {
+			header = append(header, col.name)
+		}
+		err = w.Write(header)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range t.rows {
+			record := make([]string, len(row))
+			for i, v := range row {
+				if v != nil {
+					record[i] = fmt.Sprintf("%v", v)
+				}
+			}
+			err = w.Write(record)
+			if err != nil {
+				return nil, err
+			}
+		}
+		w.Flush()
+		err = w.Error()
+		if err != nil {
+			return nil, err
+		}
+		files[t.name] = buf.Bytes()
+	}
+	return files, nil
+}
+
+// UnmarshalCSV fills in the master structure from CSV tables produced by
+// MarshalCSV. Tables that are missing are considered to be empty. The order
+// of columns within a table doesn't matter.
+func UnmarshalCSV(files map[string][]byte, m interface{}) error {
+	sch, err := newSchema(m)
+	if err != nil {
+		return err
+	}
+	tables := relTables(sch)
+	byName := make(map[string]*relTable)
+	for _, t := range tables {
+		byName[t.name] = t
+	}
+	for name := range files {
+		if _, ok := byName[name]; !ok {
+			return fmt.Errorf("unknown table %s", name)
+		}
+	}
+	doc := sch.newDocument()
+	values := make(map[*Node]map[string]interface{})
+	// Node tables go before their join tables.
+	for _, t := range tables {
+		records, err := readCSVTable(t, files[t.name])
+		if err != nil {
+			return err
+		}
+		for i, record := range records {
+			err = t.unmarshalRecord(record, doc, values)
+			if err != nil {
+				return fmt.Errorf("table %s, row %d: %v", t.name, i+1, err)
+			}
+		}
+	}
+	for n, vals := range values {
+		for name, v := range vals {
+			err = n.SetValue(name, v)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return UnmarshalDocument(doc, m, UnmarshalOpts{})
+}
+
+// readCSVTable returns the records of the table with the columns ordered
+// as in the table definition.
+func readCSVTable(t *relTable, b []byte) ([][]string, error) {
+	if b == nil {
+		return nil, nil
+	}
+	records, err := csv.NewReader(bytes.NewReader(b)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("table %s: %v", t.name, err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("table %s has no header", t.name)
+	}
+	pos := make(map[string]int)
+	for i, name := range records[0] {
+		pos[name] = i
+	}
+	for _, col := range t.columns {
+		if _, ok := pos[col.name]; !ok {
+			return nil, fmt.Errorf("table %s has no column %s", t.name, col.name)
+		}
+	}
+	if len(pos) != len(t.columns) {
+		return nil, fmt.Errorf("table %s has unknown columns", t.name)
+	}
+	var ordered [][]string
+	for _, record := range records[1:] {
+		row := make([]string, len(t.columns))
+		for i, col := range t.columns {
+			row[i] = record[pos[col.name]]
+		}
+		ordered = append(ordered, row)
+	}
+	return ordered, nil
+}
+
+// unmarshalRecord adds the record to the document. Values of the fields are
+// collected in values so that join tables can fill in slices and maps.
+func (t *relTable) unmarshalRecord(record []string, doc *Document, values map[*Node]map[string]interface{}) error {
+	if t.field == nil {
+		n, err := doc.AddNode(t.coll, record[0])
+		if err != nil {
+			return err
+		}
+		vals := make(map[string]interface{})
+		values[n] = vals
+		for i, col := range t.columns[1:] {
+			cell := record[i+1]
+			var v interface{}
+			switch col.field.kind {
+			case scalarField:
+				v, err = parseScalar(cell, col.field.tp)
+			case refField:
+				v, err = relRefValue(cell, col)
+			case refListField, refMapField:
+				// Empty cell means nil, otherwise the elements
+				// are filled in from the join table.
+				if cell == "" {
+					continue
+				}
+				l, err := strconv.Atoi(cell)
+				if err != nil || l < 0 {
+					return fmt.Errorf("column %s: invalid length %s", col.name, cell)
+				}
+				if col.field.kind == refListField {
+					v = make([]interface{}, l)
+				} else {
+					v = make(map[string]interface{})
+				}
+			default:
+				v, err = decodeValue(json.RawMessage(cell))
+			}
+			if err != nil {
+				return fmt.Errorf("column %s: %v", col.name, err)
+			}
+			vals[col.name] = v
+		}
+		return nil
+	}
+	n := doc.Node(t.coll, record[0])
+	if n == nil {
+		return fmt.Errorf("unknown node %s:%s", t.coll, record[0])
+	}
+	ref, err := relRefValue(record[2], t.columns[2])
+	if err != nil {
+		return err
+	}
+	switch v := values[n][t.field.name].(type) {
+	case []interface{}:
+		i, err := strconv.Atoi(record[1])
+		if err != nil || i < 0 || i >= len(v) {
+			return fmt.Errorf("invalid index %s", record[1])
+		}
+		v[i] = ref
+	case map[string]interface{}:
+		v[record[1]] = ref
+	default:
+		return fmt.Errorf("%s of %s:%s is nil", t.field.name, t.coll, record[0])
+	}
+	return nil
+}
+
+// relRefValue converts the cell of a reference column into a reference.
+func relRefValue(cell string, col relColumn) (interface{}, error) {
+	if cell == "" {
+		return nil, nil
+	}
+	if col.kind == relAnyRef {
+		return ParseRef(cell)
+	}
+	return Ref{Type: col.refTable, ID: cell}, nil
+}
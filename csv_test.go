@@ -0,0 +1,87 @@
+package grison
+
+import (
+	"testing"
+)
+
+func TestCSVRoundTrip(t *testing.T) {
+	m := newGraphMaster()
+	files, err := MarshalCSV(m, MarshalOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m2 graphMaster
+	err = UnmarshalCSV(files, &m2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j1, err := Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j2, err := Marshal(&m2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(j1) != string(j2) {
+		t.Errorf("unexpected round trip result.\nexpect=%s\nactual=%s", j1, j2)
+	}
+}
+
+func TestCSVOutput(t *testing.T) {
+	files, err := MarshalCSV(newGraphMaster(), MarshalOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"Nodes": "id,Name,Age,Weight,Alive,Tags,Next,Prev,List,Empty,Map,Nested,Skipped\n" +
+			"#1,a <&> b,42,1.5,true,\"[\"\"x\"\",\"\"y\"\"]\",#2,,3,,,\"{\"\"N\"\":null}\",0\n" +
+			"#2,c,-1,0,false,null,,Nodes:#1,,0,2,\"{\"\"N\"\":null}\",0\n" +
+			"#3,,0,0,false,null,,,,,,\"{\"\"N\"\":{\"\"$ref\"\":\"\"Nodes:#2\"\"}}\",0\n",
+		"Nodes_List":  "id,index,ref\n#1,0,#3\n#1,1,\n#1,2,#1\n",
+		"Nodes_Empty": "id,index,ref\n",
+		"Nodes_Map":   "id,key,ref\n#2,first,#1\n#2,none,\n",
+		"Other":       "id\n",
+	}
+	if len(files) != len(expected) {
+		t.Errorf("unexpected tables %v", files)
+	}
+	for name, s := range expected {
+		if string(files[name]) != s {
+			t.Errorf("unexpected table %s.\nexpect=%s\nactual=%s", name, s, files[name])
+		}
+	}
+}
+
+func TestCSVImport(t *testing.T) {
+	// Columns in different order, missing join tables.
+	files := map[string][]byte{
+		"Nodes": []byte("Next,id,Name,Age,Weight,Alive,Tags,Prev,List,Empty,Map,Nested,Skipped\n" +
+			"#2,#1,a,1,0,false,null,,,,,{},0\n" +
+			",#2,b,2,0,false,null,Nodes:#1,,,,{},0\n"),
+	}
+	var m graphMaster
+	err := UnmarshalCSV(files, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Marshal(&m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"Nodes":{"#1":{"Age":1,"Alive":false,"Empty":null,"List":null,"Map":null,"Name":"a","Nested":{"N":null},"Next":{"$ref":"Nodes:#2"},"Prev":null,"Tags":null,"Weight":0},` +
+		`"#2":{"Age":2,"Alive":false,"Empty":null,"List":null,"Map":null,"Name":"b","Nested":{"N":null},"Next":null,"Prev":{"$ref":"Nodes:#1"},"Tags":null,"Weight":0}}}`
+	if string(b) != expected {
+		t.Errorf("unexpected result.\nexpect=%s\nactual=%s", expected, b)
+	}
+	files["Nodes_List"] = []byte("id,index,ref\n#1,0,#2\n")
+	err = UnmarshalCSV(files, &m)
+	if err == nil {
+		t.Errorf("join table rows for nil slice accepted")
+	}
+	files = map[string][]byte{"Foo": []byte("id\n")}
+	err = UnmarshalCSV(files, &m)
+	if err == nil {
+		t.Errorf("unknown table accepted")
+	}
+}
@@ -0,0 +1,206 @@
+/*
+	Copyright (c) 2020 Martin Sustrik
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy
+	of this software and associated documentation files (the "Software"),
+	to deal in the Software without restriction, including without limitation
+	the rights to use, copy, modify, merge, publish, distribute, sublicense,
+	and/or sell copies of the Software, and to permit persons to whom
+	the Software is furnished to do so, subject to the following conditions:
+	The above copyright notice and this permission notice shall be included
+	in all copies or substantial portions of the Software.
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+	FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+	IN THE SOFTWARE.
+*/
+
+package grison
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// CypherIDProperty is the property holding the node ID in Cypher export.
+const CypherIDProperty = "_id"
+
+// MarshalCypher converts the graph reachable from the master structure into
+// a Cypher script for property graph databases. Each node gets the label of
+// its collection and the properties for its scalar fields. Other fields that
+// are not references are stored as JSON strings. Each reference becomes
+// a relationship with the type named after the field. References from slices
+// have an index property, references from maps a key property and references
+// nested in other values a path property. The script uses MERGE on node IDs,
+// stored in _id property, so that it can be run repeatedly to update the data.
+// The properties of each node are replaced as a whole and its outgoing
+// relationships of the types named after its fields are deleted and
+// re-created. Nodes that are no longer in the
+// graph are left in the database. Automatic IDs depend on the order in which
+// the nodes are found, so the updates are only reliable if the nodes have
+// stable IDs, i.e. if GetIDs option or ID fields are used.
+func MarshalCypher(m interface{}, opts MarshalOpts) ([]byte, error) {
+	w, err := newWalker(m, opts)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	labels := make(map[string]bool)
+	for _, wn := range w.nodes {
+		if labels[wn.ref.Type] {
+			continue
+		}
+		labels[wn.ref.Type] = true
+		fmt.Fprintf(&buf, "CREATE CONSTRAINT IF NOT EXISTS FOR (n:%s) REQUIRE n.%s IS UNIQUE;\n",
+			cypherIdent(wn.ref.Type), cypherIdent(CypherIDProperty))
+	}
+	var rels bytes.Buffer
+	relTypes := make(map[reflect.Type]string)
+	for _, wn := range w.nodes {
+		fmt.Fprintf(&buf, "MERGE (n:%s {%s: %s})", cypherIdent(wn.ref.Type),
+			cypherIdent(CypherIDProperty), cypherString(wn.ref.ID))
+		props := []string{fmt.Sprintf("%s: %s", cypherIdent(CypherIDProperty), cypherString(wn.ref.ID))}
+		addRel := func(ref Ref, name string, propName string, prop string) {
+			fmt.Fprintf(&rels, "MATCH (a:%s {%s: %s}), (b:%s {%s: %s}) MERGE (a)-[:%s",
+				cypherIdent(wn.ref.Type), cypherIdent(CypherIDProperty), cypherString(wn.ref.ID),
+				cypherIdent(ref.Type), cypherIdent(CypherIDProperty), cypherString(ref.ID),
+				cypherIdent(name))
+			if propName != "" {
+				fmt.Fprintf(&rels, " {%s: %s}", cypherIdent(propName), prop)
+			}
+			rels.WriteString("]->(b);\n")
+		}
+		for _, f := range w.fields(wn) {
+			fv := wn.val.Field(f.index)
+			switch f.kind {
+			case scalarField:
+				lit, err := cypherLiteral(fv)
+				if err != nil {
+					return nil, err
+				}
+				props = append(props, fmt.Sprintf("%s: %s", cypherIdent(f.name), lit))
+			case refField:
+				if ref, ok := w.ref(fv); ok {
+					addRel(ref, f.name, "", "")
+				}
+			case refListField:
+				for i := 0; i < fv.Len(); i++ {
+					if ref, ok := w.ref(fv.Index(i)); ok {
+						addRel(ref, f.name, "index", fmt.Sprintf("%d", i))
+					}
+				}
+			case refMapField:
+				for _, k := range sortedMapKeys(fv) {
+					if ref, ok := w.ref(fv.MapIndex(k)); ok {
+						addRel(ref, f.name, "key", cypherString(fmt.Sprintf("%v", k.Interface())))
+					}
+				}
+			default:
+				b, err := w.marshalValue(fv)
+				if err != nil {
+					return nil, err
+				}
+				props = append(props, fmt.Sprintf("%s: %s", cypherIdent(f.name), cypherString(string(b))))
+				v, err := decodeValue(b)
+				if err != nil {
+					return nil, err
+				}
+				walkRefs(v, f.name, func(path string, ref Ref) {
+					addRel(ref, f.name, "path", cypherString(path))
+				})
+			}
+		}
+		// Replace all the properties so that the ones of omitted fields
+		// don't survive from the previous run.
+		fmt.Fprintf(&buf, " SET n = {%s}", strings.Join(props, ", "))
+		// Drop the relationships from the previous run. The current ones
+		// are re-created below. Relationships of other types may have been
+		// created by someone else and are left alone.
+		tps, ok := relTypes[wn.val.Type()]
+		if !ok {
+			var names []string
+			for _, f := range nodeFields(wn.val.Type(), w.enc.types) {
+				if holdsRefs(f.tp, w.enc.types, make(map[reflect.Type]bool)) {
+					names = append(names, cypherIdent(f.name))
+				}
+			}
+			tps = strings.Join(names, "|")
+			relTypes[wn.val.Type()] = tps
+		}
+		if tps != "" {
+			fmt.Fprintf(&buf, " WITH n OPTIONAL MATCH (n)-[r:%s]->() DELETE r", tps)
+		}
+		buf.WriteString(";\n")
+	}
+	buf.Write(rels.Bytes())
+	return buf.Bytes(), nil
+}
+
+// holdsRefs returns true if values of the type may contain references.
+func holdsRefs(tp reflect.Type, nodeTypes map[reflect.Type]string, seen map[reflect.Type]bool) bool {
+	if hasCustomMarshaler(tp) || seen[tp] {
+		return false
+	}
+	seen[tp] = true
+	switch tp.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Ptr:
+		if _, ok := nodeTypes[tp.Elem()]; ok {
+			return true
+		}
+		return holdsRefs(tp.Elem(), nodeTypes, seen)
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return holdsRefs(tp.Elem(), nodeTypes, seen)
+	case reflect.Struct:
+		for i := 0; i < tp.NumField(); i++ {
+			if !getFieldTags(tp.Field(i)).ignore && holdsRefs(tp.Field(i).Type, nodeTypes, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func cypherIdent(s string) string {
+	return "`" + strings.ReplaceAll(s, "`", "``") + "`"
+}
+
+var cypherReplacer = strings.NewReplacer(
+	`\`, `\\`, `'`, `\'`, "\n", `\n`, "\r", `\r`, "\t", `\t`, "\b", `\b`, "\f", `\f`)
+
+func cypherString(s string) string {
+	return "'" + cypherReplacer.Replace(s) + "'"
+}
+
+// cypherLiteral returns the literal for a scalar value. Floats always have
+// a decimal point or an exponent so that they are not mistaken for integers.
+// Cypher integers are signed 64-bit, larger unsigned values are rejected.
+func cypherLiteral(v reflect.Value) (string, error) {
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return "", fmt.Errorf("%d is out of range of Cypher integers", v.Uint())
+		}
+	}
+	s, err := scalarText(v)
+	if err != nil {
+		return "", err
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return cypherString(s), nil
+	case reflect.Float32, reflect.Float64:
+		s = strings.Replace(s, "e+", "e", 1)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,93 @@
+package grison
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestCypher(t *testing.T) {
+	b, err := MarshalCypher(newLDMaster(), MarshalOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "CREATE CONSTRAINT IF NOT EXISTS FOR (n:`People`) REQUIRE n.`_id` IS UNIQUE;\n" +
+		"MERGE (n:`People` {`_id`: '#1'}) SET n = {`_id`: '#1', `Name`: 'Alice', `Age`: 30} WITH n OPTIONAL MATCH (n)-[r:`Spouse`|`Friends`]->() DELETE r;\n" +
+		"MERGE (n:`People` {`_id`: '#2'}) SET n = {`_id`: '#2', `Name`: 'Bob', `Age`: 0} WITH n OPTIONAL MATCH (n)-[r:`Spouse`|`Friends`]->() DELETE r;\n" +
+		"MATCH (a:`People` {`_id`: '#1'}), (b:`People` {`_id`: '#2'}) MERGE (a)-[:`Spouse`]->(b);\n" +
+		"MATCH (a:`People` {`_id`: '#2'}), (b:`People` {`_id`: '#1'}) MERGE (a)-[:`Spouse`]->(b);\n" +
+		"MATCH (a:`People` {`_id`: '#2'}), (b:`People` {`_id`: '#1'}) MERGE (a)-[:`Friends` {`index`: 0}]->(b);\n"
+	if string(b) != expected {
+		t.Errorf("unexpected Cypher.\nexpect=%s\nactual=%s", expected, b)
+	}
+}
+
+func TestCypherValues(t *testing.T) {
+	b, err := MarshalCypher(newGraphMaster(), MarshalOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"`Name`: 'a <&> b', `Age`: 42, `Weight`: 1.5, `Alive`: true, `Tags`: '[\"x\",\"y\"]'",
+		"`Name`: 'c', `Age`: -1, `Weight`: 0.0,",
+		"MERGE (a)-[:`Map` {`key`: 'first'}]->(b);",
+		"MERGE (a)-[:`Nested` {`path`: 'Nested.N'}]->(b);",
+		"OPTIONAL MATCH (n)-[r:`Next`|`Prev`|`List`|`Empty`|`Map`|`Nested`]->() DELETE r;",
+	} {
+		if !strings.Contains(string(b), s) {
+			t.Errorf("missing %s in:\n%s", s, b)
+		}
+	}
+	if cypherString("it's\\") != `'it\'s\\'` {
+		t.Errorf("unexpected quoting %s", cypherString("it's\\"))
+	}
+}
+
+func TestCypherReimport(t *testing.T) {
+	type Node struct {
+		ID   string
+		Note string `grison:",omitempty"`
+	}
+	type Master struct {
+		Node []*Node
+	}
+	m := &Master{Node: []*Node{{ID: "a", Note: "old"}}}
+	b, err := MarshalCypher(m, MarshalOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "SET n = {`_id`: '#1', `ID`: 'a', `Note`: 'old'}") {
+		t.Errorf("unexpected Cypher:\n%s", b)
+	}
+	// The whole property map is replaced, so the note is removed when
+	// the script is run again.
+	m.Node[0].Note = ""
+	b, err = MarshalCypher(m, MarshalOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The node has no reference fields, so no relationships are deleted.
+	if !strings.Contains(string(b), "SET n = {`_id`: '#1', `ID`: 'a'};\n") {
+		t.Errorf("unexpected Cypher:\n%s", b)
+	}
+}
+
+func TestCypherLargeUint(t *testing.T) {
+	type Node struct {
+		U uint64
+	}
+	type Master struct {
+		Node []*Node
+	}
+	b, err := MarshalCypher(&Master{Node: []*Node{{U: math.MaxInt64}}}, MarshalOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "`U`: 9223372036854775807}") {
+		t.Errorf("unexpected Cypher:\n%s", b)
+	}
+	_, err = MarshalCypher(&Master{Node: []*Node{{U: math.MaxInt64 + 1}}}, MarshalOpts{})
+	if err == nil {
+		t.Errorf("out of range integer accepted")
+	}
+}
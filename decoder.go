@@ -32,6 +32,11 @@ type decoder struct {
 	types  map[reflect.Type]string
 	master reflect.Value
 	refmap map[string]reflect.Value
+	// Nodes of the formats using shared values, see unmarshalShared.
+	shared map[*sharedValue]Ref
+	// Existing nodes to fill in instead of allocating new ones, keyed by
+	// references in "Type:ID" format.
+	reuse map[string]reflect.Value
 }
 
 func newDecoder(m interface{}) (*decoder, error) {
@@ -105,6 +110,10 @@ func (dec *decoder) unmarshalStruct(b []byte, v reflect.Value) error {
 		if ft.ignore {
 			continue
 		}
+		// ID of the node is set from the key.
+		if _, ok := dec.types[tp]; ok && ft.id {
+			continue
+		}
 		fld := v.Elem().Field(i)
 		v := reflect.New(fld.Type())
 		rm, ok := rmm[ft.name]
@@ -204,40 +213,57 @@ func (dec *decoder) unmarshalAny(b []byte, v reflect.Value) error {
 }
 
 type UnmarshalOpts struct {
+	// Format of the input.
+	Format Format
 }
 
 func UnmarshalWithOpts(b []byte, m interface{}, opts UnmarshalOpts) error {
+	var parse func([]byte) (*Document, error)
+	switch opts.Format {
+	case FormatJSON:
+	case FormatYAML:
+		parse = ParseYAMLDocument
+	case FormatCBOR:
+		parse = ParseCBORDocument
+	case FormatMsgPack:
+		parse = ParseMsgPackDocument
+	case FormatJSONLD:
+		return unmarshalJSONLD(b, m, opts)
+	case FormatProto:
+		return UnmarshalProto(b, m)
+	default:
+		return fmt.Errorf("unknown format %d", opts.Format)
+	}
+	if parse != nil {
+		doc, err := parse(b)
+		if err != nil {
+			return err
+		}
+		return UnmarshalDocument(doc, m, opts)
+	}
 	dec, err := newDecoder(m)
 	if err != nil {
 		return err
 	}
+	return dec.unmarshalJSON(b)
+}
+
+// unmarshalJSON fills in the master structure from grison JSON.
+func (dec *decoder) unmarshalJSON(b []byte) error {
 	var rmm map[string]map[string]json.RawMessage
-	err = json.Unmarshal(b, &rmm)
+	err := json.Unmarshal(b, &rmm)
 	if err != nil {
 		return err
 	}
-	// Create empty shells of individual objects so that we
-	// can create pointers to them.
-	mv := reflect.ValueOf(m).Elem()
 	for tp, rms := range rmm {
-		fld := getFieldByName(mv, tp)
-		if !fld.IsValid() {
-			return fmt.Errorf("unknown node type %s", tp)
-		}
-		// Order by IDs.
 		var ids []string
 		for id := range rms {
 			ids = append(ids, id)
 		}
-		sort.Strings(ids)
-		s := reflect.MakeSlice(fld.Type(), len(ids), len(ids))
-		for i, id := range ids {
-			v := reflect.New(fld.Type().Elem().Elem())
-			s.Index(i).Set(v)
-			ref := fmt.Sprintf("%s:%s", tp, id)
-			dec.refmap[ref] = v
+		err = dec.addNodes(tp, ids)
+		if err != nil {
+			return err
 		}
-		fld.Set(s)
 	}
 	// Now we can unmarshal individual nodes.
 	for tp, rms := range rmm {
@@ -252,6 +278,45 @@ func UnmarshalWithOpts(b []byte, m interface{}, opts UnmarshalOpts) error {
 	return nil
 }
 
+// addNodes creates empty shells of the nodes of the collection so that
+// we can create pointers to them.
+func (dec *decoder) addNodes(tp string, ids []string) error {
+	fld := getFieldByName(dec.master, tp)
+	if !fld.IsValid() {
+		return fmt.Errorf("unknown node type %s", tp)
+	}
+	// Order by IDs.
+	sort.Strings(ids)
+	s := reflect.MakeSlice(fld.Type(), len(ids), len(ids))
+	idfld := idField(fld.Type().Elem().Elem())
+	seen := make(map[string]bool)
+	for i, id := range ids {
+		ref := fmt.Sprintf("%s:%s", tp, id)
+		v, ok := dec.reuse[ref]
+		if ok {
+			v.Elem().Set(reflect.Zero(v.Elem().Type()))
+		} else {
+			v = reflect.New(fld.Type().Elem().Elem())
+		}
+		if idfld >= 0 {
+			err := setIDField(v.Elem().Field(idfld), id)
+			if err != nil {
+				return err
+			}
+			// Different keys may parse to the same number.
+			key, _ := idFieldValue(v.Elem().Field(idfld))
+			if seen[key] {
+				return fmt.Errorf("duplicate ID %q in %s", key, tp)
+			}
+			seen[key] = true
+		}
+		s.Index(i).Set(v)
+		dec.refmap[ref] = v
+	}
+	fld.Set(s)
+	return nil
+}
+
 func Unmarshal(b []byte, m interface{}) error {
 	return UnmarshalWithOpts(b, m, UnmarshalOpts{})
 }
@@ -0,0 +1,666 @@
+/*
+	Copyright (c) 2020 Martin Sustrik
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy
+	of this software and associated documentation files (the "Software"),
+	to deal in the Software without restriction, including without limitation
+	the rights to use, copy, modify, merge, publish, distribute, sublicense,
+	and/or sell copies of the Software, and to permit persons to whom
+	the Software is furnished to do so, subject to the following conditions:
+	The above copyright notice and this permission notice shall be included
+	in all copies or substantial portions of the Software.
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+	FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+	IN THE SOFTWARE.
+*/
+
+package grison
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ChangeKind is the kind of a change between two graphs.
+type ChangeKind int
+
+const (
+	// The node exists only in the second graph.
+	NodeAdded ChangeKind = iota
+	// The node exists only in the first graph.
+	NodeRemoved
+	// Value of a field has changed.
+	FieldChanged
+	// A reference points to a different node.
+	RefChanged
+)
+
+// Change is a single difference between two graphs.
+type Change struct {
+	Kind ChangeKind
+	// The node in the first and in the second graph. A is zero for added
+	// nodes, B is zero for removed nodes. The IDs of the node may differ
+	// if the IDs were generated automatically.
+	A Ref
+	B Ref
+	// Path of the changed value within the node, e.g. "Children[1]".
+	// Empty for added and removed nodes.
+	Path string
+	// The value in the first and in the second graph. For added and removed
+	// nodes, it's a map of all the fields of the node. Values are nil,
+	// bool, json.Number, string, Ref, []interface{} or map[string]interface{}.
+	// References in Before refer to the nodes of the first graph, references
+	// in After to the nodes of the second graph.
+	Before interface{}
+	After  interface{}
+}
+
+func (c Change) String() string {
+	switch c.Kind {
+	case NodeAdded:
+		return "+ " + c.B.String()
+	case NodeRemoved:
+		return "- " + c.A.String()
+	}
+	node := c.A.String()
+	if c.A.ID != c.B.ID {
+		node += " (" + c.B.ID + ")"
+	}
+	return fmt.Sprintf("~ %s %s: %s -> %s", node, c.Path, formatValue(c.Before), formatValue(c.After))
+}
+
+// formatValue returns a compact textual representation of the value.
+// References are shown in Type:ID format.
+func formatValue(v interface{}) string {
+	if ref, ok := v.(Ref); ok {
+		return ref.String()
+	}
+	rm, err := encodeValue(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(rm)
+}
+
+// Diff compares two graphs. Each of the arguments is either a document or
+// a master structure. Nodes of master structures are identified by the IDs
+// returned by IDProvider if they implement it. Nodes with explicit IDs
+// are matched by ID. Nodes with automatically generated IDs (#1, #2 etc.)
+// are matched by their content and by their position in the graph, so that
+// inserting a node doesn't show up as a change of all the subsequent nodes.
+// Nodes that can't be matched that way are matched by ID as a last resort.
+// Changes are ordered by collection and by node ID.
+func Diff(a, b interface{}) ([]Change, error) {
+	da, err := toDocument(a)
+	if err != nil {
+		return nil, err
+	}
+	db, err := toDocument(b)
+	if err != nil {
+		return nil, err
+	}
+	changes, _ := diffDocuments(da, db)
+	return changes, nil
+}
+
+// toDocument converts the argument, either a document or a master
+// structure, into a document.
+func toDocument(v interface{}) (*Document, error) {
+	if doc, ok := v.(*Document); ok {
+		return doc, nil
+	}
+	_, _, _, err := scrapeMasterStruct(v, true)
+	return MarshalDocument(v, MarshalOpts{GetIDs: err == nil})
+}
+
+// diffDocuments returns the changes along with the matching of the nodes.
+func diffDocuments(da, db *Document) ([]Change, *matching) {
+	mt := matchNodes(da, db)
+	var changes []Change
+	types := da.Types()
+	for _, tp := range db.Types() {
+		if !da.HasType(tp) {
+			types = append(types, tp)
+		}
+	}
+	sort.Strings(types)
+	for _, tp := range types {
+		for _, na := range da.Nodes(tp) {
+			rb, ok := mt.ab[na.Ref()]
+			if !ok {
+				changes = append(changes, Change{Kind: NodeRemoved, A: na.Ref(), Before: nodeValues(na)})
+				continue
+			}
+			nb := db.Resolve(rb)
+			for _, name := range unionFields(na, nb) {
+				va, _ := na.Value(name)
+				vb, _ := nb.Value(name)
+				changes = mt.diffValue(changes, na.Ref(), rb, pathKey(name), va, vb)
+			}
+		}
+		for _, nb := range db.Nodes(tp) {
+			if _, ok := mt.ba[nb.Ref()]; !ok {
+				changes = append(changes, Change{Kind: NodeAdded, B: nb.Ref(), After: nodeValues(nb)})
+			}
+		}
+	}
+	return changes, mt
+}
+
+// nodeValues returns all the fields of the node.
+func nodeValues(n *Node) map[string]interface{} {
+	vals := make(map[string]interface{})
+	for _, name := range n.FieldNames() {
+		vals[name], _ = n.Value(name)
+	}
+	return vals
+}
+
+// unionFields returns the names of the fields present in either node.
+func unionFields(na, nb *Node) []string {
+	names := na.FieldNames()
+	for _, name := range nb.FieldNames() {
+		if na.Field(name) == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (mt *matching) diffValue(changes []Change, ra, rb Ref, path string, va, vb interface{}) []Change {
+	if mt.equal(va, vb) {
+		return changes
+	}
+	switch a := va.(type) {
+	case map[string]interface{}:
+		if b, ok := vb.(map[string]interface{}); ok {
+			for _, k := range unionKeys(a, b) {
+				changes = mt.diffValue(changes, ra, rb, path+"."+pathKey(k), a[k], b[k])
+			}
+			return changes
+		}
+	case []interface{}:
+		if b, ok := vb.([]interface{}); ok && len(a) == len(b) {
+			for i := range a {
+				changes = mt.diffValue(changes, ra, rb, fmt.Sprintf("%s[%d]", path, i), a[i], b[i])
+			}
+			return changes
+		}
+	}
+	kind := FieldChanged
+	_, refA := va.(Ref)
+	_, refB := vb.(Ref)
+	if (refA || va == nil) && (refB || vb == nil) {
+		kind = RefChanged
+	}
+	return append(changes, Change{Kind: kind, A: ra, B: rb, Path: path, Before: va, After: vb})
+}
+
+func unionKeys(a, b map[string]interface{}) []string {
+	var keys []string
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// matching is a one-to-one mapping between the nodes of two documents.
+type matching struct {
+	da *Document
+	db *Document
+	ab map[Ref]Ref
+	ba map[Ref]Ref
+	// Decoded values of the nodes.
+	vals map[*Node]map[string]interface{}
+}
+
+func newMatching(da, db *Document) *matching {
+	return &matching{
+		da:   da,
+		db:   db,
+		ab:   make(map[Ref]Ref),
+		ba:   make(map[Ref]Ref),
+		vals: make(map[*Node]map[string]interface{}),
+	}
+}
+
+// values returns the decoded values of the node. The values must not be modified.
+func (mt *matching) values(n *Node) map[string]interface{} {
+	v, ok := mt.vals[n]
+	if !ok {
+		v = nodeValues(n)
+		mt.vals[n] = v
+	}
+	return v
+}
+
+func (mt *matching) match(ra, rb Ref) {
+	mt.ab[ra] = rb
+	mt.ba[rb] = ra
+}
+
+// equal compares values from the two documents. References are equal if
+// they point to matching nodes. Dangling references are equal if they are
+// the same.
+func (mt *matching) equal(va, vb interface{}) bool {
+	switch a := va.(type) {
+	case Ref:
+		b, ok := vb.(Ref)
+		if !ok {
+			return false
+		}
+		if mt.da.Resolve(a) == nil {
+			return a == b && mt.db.Resolve(b) == nil
+		}
+		m, ok := mt.ab[a]
+		return ok && m == b
+	case map[string]interface{}:
+		b, ok := vb.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, item := range a {
+			bitem, ok := b[k]
+			if !ok || !mt.equal(item, bitem) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		b, ok := vb.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !mt.equal(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return va == vb
+}
+
+// isAutoID returns true if the ID looks like generated by Marshal.
+func isAutoID(id string) bool {
+	if len(id) < 2 || id[0] != '#' {
+		return false
+	}
+	_, err := strconv.ParseUint(id[1:], 10, 64)
+	return err == nil
+}
+
+// matchNodes finds the corresponding nodes in the two documents. Nodes with
+// explicit IDs are matched by ID. Nodes with automatic IDs are matched if
+// they are structurally indistinguishable, i.e. they have the same values
+// and their references lead to indistinguishable nodes. The remaining nodes
+// are matched if at least half of their values are equal.
+func matchNodes(da, db *Document) *matching {
+	mt := newMatching(da, db)
+	for _, na := range da.AllNodes() {
+		if !isAutoID(na.ID()) && db.Resolve(na.Ref()) != nil {
+			mt.match(na.Ref(), na.Ref())
+		}
+	}
+	for {
+		for mt.matchStructurally() {
+		}
+		if !mt.matchSimilar() {
+			break
+		}
+	}
+	// As a last resort, nodes that changed too much to be recognized are
+	// matched if they have the same ID.
+	for _, na := range mt.unmatched(da, mt.ab) {
+		if _, ok := mt.ba[na.Ref()]; !ok && db.Resolve(na.Ref()) != nil {
+			mt.match(na.Ref(), na.Ref())
+		}
+	}
+	return mt
+}
+
+// unmatched returns the nodes with automatic IDs that are not matched yet.
+func (mt *matching) unmatched(doc *Document, matched map[Ref]Ref) []*Node {
+	var nodes []*Node
+	for _, n := range doc.AllNodes() {
+		if _, ok := matched[n.Ref()]; !ok && isAutoID(n.ID()) {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// matchStructurally matches unmatched nodes that are indistinguishable.
+// Returns true if any new nodes were matched.
+func (mt *matching) matchStructurally() bool {
+	ua, ub, colors := mt.refine()
+	if len(ua) == 0 || len(ub) == 0 {
+		return false
+	}
+	classes := make(map[string][]*Node)
+	for _, n := range ub {
+		classes[colors[n]] = append(classes[colors[n]], n)
+	}
+	found := false
+	for _, na := range ua {
+		c := classes[colors[na]]
+		if len(c) == 0 {
+			continue
+		}
+		mt.match(na.Ref(), c[0].Ref())
+		classes[colors[na]] = c[1:]
+		found = true
+	}
+	return found
+}
+
+// refine returns the unmatched nodes from both documents and their colors.
+// Each node gets a color describing its content. References to matched
+// nodes are described by the matched node, references to unmatched nodes
+// by the color of the node they refer to. Colors are refined until the
+// number of distinct colors stops growing.
+func (mt *matching) refine() ([]*Node, []*Node, map[*Node]string) {
+	ua := mt.unmatched(mt.da, mt.ab)
+	ub := mt.unmatched(mt.db, mt.ba)
+	colors := make(map[*Node]string)
+	if len(ua) == 0 || len(ub) == 0 {
+		return ua, ub, colors
+	}
+	distinct := 0
+	for {
+		next := make(map[*Node]string)
+		set := make(map[string]bool)
+		for _, n := range ua {
+			next[n] = mt.color(n, true, colors)
+			set[next[n]] = true
+		}
+		for _, n := range ub {
+			next[n] = mt.color(n, false, colors)
+			set[next[n]] = true
+		}
+		colors = next
+		if len(set) <= distinct {
+			break
+		}
+		distinct = len(set)
+	}
+	return ua, ub, colors
+}
+
+// color returns the color of the node given the colors from the previous round.
+func (mt *matching) color(n *Node, sideA bool, colors map[*Node]string) string {
+	doc, matched := mt.db, mt.ba
+	if sideA {
+		doc, matched = mt.da, mt.ab
+	}
+	var sb strings.Builder
+	sb.WriteString(strconv.Quote(n.Type()))
+	vals := mt.values(n)
+	for _, name := range n.FieldNames() {
+		v := vals[name]
+		sb.WriteString(strconv.Quote(name))
+		writeCanonical(&sb, v, func(ref Ref) string {
+			if sideA {
+				if m, ok := matched[ref]; ok {
+					return "m" + m.String()
+				}
+			} else if _, ok := matched[ref]; ok {
+				return "m" + ref.String()
+			}
+			target := doc.Resolve(ref)
+			if target == nil {
+				return "d" + ref.String()
+			}
+			return "c" + ref.Type + ":" + colors[target]
+		})
+	}
+	sum := sha256.Sum256([]byte(sb.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// writeCanonical writes a deterministic representation of the value.
+// References are represented by the result of the supplied function.
+func writeCanonical(sb *strings.Builder, v interface{}, ref func(Ref) string) {
+	switch v := v.(type) {
+	case nil:
+		sb.WriteString("null")
+	case bool:
+		sb.WriteString(strconv.FormatBool(v))
+	case string:
+		sb.WriteString(strconv.Quote(v))
+	case Ref:
+		sb.WriteString("<" + ref(v) + ">")
+	case []interface{}:
+		sb.WriteString("[")
+		for _, item := range v {
+			writeCanonical(sb, item, ref)
+			sb.WriteString(",")
+		}
+		sb.WriteString("]")
+	case map[string]interface{}:
+		sb.WriteString("{")
+		for _, k := range sortedKeys(v) {
+			sb.WriteString(strconv.Quote(k) + ":")
+			writeCanonical(sb, v[k], ref)
+			sb.WriteString(",")
+		}
+		sb.WriteString("}")
+	default:
+		sb.WriteString(fmt.Sprintf("%v", v))
+	}
+}
+
+// matchSimilar matches the remaining nodes of the same type if at least
+// half of their values are equal. The most similar pairs are matched first,
+// pairs of nodes with the same ID are preferred among equally similar ones.
+// References from matched nodes count as values as well. Returns true
+// if any new nodes were matched.
+func (mt *matching) matchSimilar() bool {
+	type pair struct {
+		a, b  *Node
+		score float64
+	}
+	ub := mt.unmatched(mt.db, mt.ba)
+	leaves := mt.leafIndex(ub)
+	var pairs []pair
+	for _, na := range mt.unmatched(mt.da, mt.ab) {
+		for _, nb := range mt.candidates(na, leaves) {
+			equal, total := mt.similarity(mt.values(na), mt.values(nb))
+			e, t := mt.backrefSimilarity(na, nb)
+			equal += e
+			total += t
+			if equal > 0 && equal*2 >= total {
+				pairs = append(pairs, pair{na, nb, float64(equal) / float64(total)})
+			}
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		if pairs[i].score != pairs[j].score {
+			return pairs[i].score > pairs[j].score
+		}
+		return pairs[i].a.ID() == pairs[i].b.ID() && pairs[j].a.ID() != pairs[j].b.ID()
+	})
+	found := false
+	for _, p := range pairs {
+		_, ma := mt.ab[p.a.Ref()]
+		_, mb := mt.ba[p.b.Ref()]
+		if !ma && !mb {
+			mt.match(p.a.Ref(), p.b.Ref())
+			found = true
+		}
+	}
+	return found
+}
+
+// Scalar values shared by more nodes than this are not used to find
+// candidates for matching, as they don't tell the nodes apart.
+const maxLeafBucket = 8
+
+// leafIndex indexes the nodes by their scalar values, including the type
+// of the node and the path of the value.
+func (mt *matching) leafIndex(nodes []*Node) map[string][]*Node {
+	index := make(map[string][]*Node)
+	for _, n := range nodes {
+		for _, leaf := range mt.leaves(n) {
+			index[leaf] = append(index[leaf], n)
+		}
+	}
+	return index
+}
+
+// leaves returns the scalar values of the node, qualified by the type of
+// the node and the path of the value.
+func (mt *matching) leaves(n *Node) []string {
+	var leaves []string
+	var walk func(path string, v interface{})
+	walk = func(path string, v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for k, item := range v {
+				walk(path+"."+pathKey(k), item)
+			}
+		case []interface{}:
+			for i, item := range v {
+				walk(fmt.Sprintf("%s[%d]", path, i), item)
+			}
+		case Ref:
+		default:
+			leaves = append(leaves, fmt.Sprintf("%s\x00%s\x00%v", n.Type(), path, v))
+		}
+	}
+	for name, v := range mt.values(n) {
+		walk(pathKey(name), v)
+	}
+	return leaves
+}
+
+// candidates returns the unmatched nodes from b that may be similar to the
+// node from a. Those are the nodes sharing a scalar value with it, nodes
+// in the same position relative to matched neighbours, and the node with
+// the same ID.
+func (mt *matching) candidates(na *Node, leaves map[string][]*Node) []*Node {
+	var res []*Node
+	seen := make(map[*Node]bool)
+	add := func(nb *Node) {
+		if nb == nil || seen[nb] || nb.Type() != na.Type() || !isAutoID(nb.ID()) {
+			return
+		}
+		if _, ok := mt.ba[nb.Ref()]; ok {
+			return
+		}
+		seen[nb] = true
+		res = append(res, nb)
+	}
+	add(mt.db.Resolve(na.Ref()))
+	for _, leaf := range mt.leaves(na) {
+		if bucket := leaves[leaf]; len(bucket) <= maxLeafBucket {
+			for _, nb := range bucket {
+				add(nb)
+			}
+		}
+	}
+	for _, e := range na.Refs() {
+		if m, ok := mt.ab[e.To]; ok {
+			if tb := mt.db.Resolve(m); tb != nil {
+				for _, eb := range tb.Backrefs() {
+					if eb.Path == e.Path {
+						add(eb.From)
+					}
+				}
+			}
+		}
+	}
+	for _, e := range na.Backrefs() {
+		if m, ok := mt.ab[e.From.Ref()]; ok {
+			if fb := mt.db.Resolve(m); fb != nil {
+				for _, eb := range fb.Refs() {
+					if eb.Path == e.Path {
+						add(eb.Target())
+					}
+				}
+			}
+		}
+	}
+	return res
+}
+
+// backrefSimilarity returns the number of references from matched nodes
+// that are the same for both nodes and the total number of such references.
+func (mt *matching) backrefSimilarity(na, nb *Node) (int, int) {
+	from := make(map[string]bool)
+	for _, e := range na.Backrefs() {
+		if m, ok := mt.ab[e.From.Ref()]; ok {
+			from[m.String()+" "+e.Path] = true
+		}
+	}
+	equal, total := 0, len(from)
+	for _, e := range nb.Backrefs() {
+		if _, ok := mt.ba[e.From.Ref()]; ok {
+			if from[e.From.Ref().String()+" "+e.Path] {
+				equal++
+			} else {
+				total++
+			}
+		}
+	}
+	return equal, total
+}
+
+// similarity returns the number of equal scalar values and references
+// and the total number of them. References to nodes that are not matched
+// yet are considered equal if the nodes are of the same type.
+func (mt *matching) similarity(va, vb interface{}) (int, int) {
+	switch a := va.(type) {
+	case map[string]interface{}:
+		if b, ok := vb.(map[string]interface{}); ok {
+			equal, total := 0, 0
+			for _, k := range unionKeys(a, b) {
+				e, t := mt.similarity(a[k], b[k])
+				equal += e
+				total += t
+			}
+			return equal, total
+		}
+	case []interface{}:
+		if b, ok := vb.([]interface{}); ok {
+			equal, total := 0, 0
+			for i := 0; i < len(a) || i < len(b); i++ {
+				if i >= len(a) || i >= len(b) {
+					total++
+					continue
+				}
+				e, t := mt.similarity(a[i], b[i])
+				equal += e
+				total += t
+			}
+			return equal, total
+		}
+	case Ref:
+		if b, ok := vb.(Ref); ok && a.Type == b.Type {
+			_, ma := mt.ab[a]
+			_, mb := mt.ba[b]
+			if !ma && !mb && mt.da.Resolve(a) != nil && mt.db.Resolve(b) != nil {
+				return 1, 1
+			}
+		}
+	}
+	if mt.equal(va, vb) {
+		return 1, 1
+	}
+	return 0, 1
+}
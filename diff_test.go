@@ -0,0 +1,140 @@
+package grison
+
+import (
+	"fmt"
+	"testing"
+)
+
+func diffStrings(t *testing.T, a, b string) []string {
+	da, err := ParseDocument([]byte(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := ParseDocument([]byte(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := Diff(da, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res []string
+	for _, c := range changes {
+		res = append(res, c.String())
+	}
+	return res
+}
+
+func checkDiff(t *testing.T, a, b string, expected ...string) {
+	res := diffStrings(t, a, b)
+	if len(res) != len(expected) {
+		t.Errorf("unexpected diff.\nexpect=%q\nactual=%q", expected, res)
+		return
+	}
+	for i := range res {
+		if res[i] != expected[i] {
+			t.Errorf("unexpected diff.\nexpect=%q\nactual=%q", expected, res)
+			return
+		}
+	}
+}
+
+func TestDiffSame(t *testing.T) {
+	checkDiff(t, exampleDoc, exampleDoc)
+}
+
+func TestDiffExplicitIDs(t *testing.T) {
+	checkDiff(t,
+		`{"P":{"alice":{"Name":"Alice","Spouse":{"$ref":"P:bob"}},"bob":{"Name":"Bob"},"carol":{}}}`,
+		`{"P":{"alice":{"Name":"Alicia","Spouse":{"$ref":"P:dan"}},"bob":{"Name":"Bob"},"dan":{}}}`,
+		`~ P:alice Name: "Alice" -> "Alicia"`,
+		`~ P:alice Spouse: P:bob -> P:dan`,
+		`- P:carol`,
+		`+ P:dan`,
+	)
+}
+
+func TestDiffShiftedIDs(t *testing.T) {
+	// A node inserted at the beginning shifts all the automatic IDs.
+	checkDiff(t,
+		`{"N":{"#1":{"Name":"a","Next":{"$ref":"N:#2"}},"#2":{"Name":"b","Next":{"$ref":"N:#1"}}}}`,
+		`{"N":{"#1":{"Name":"new"},"#2":{"Name":"a","Next":{"$ref":"N:#3"}},"#3":{"Name":"b","Next":{"$ref":"N:#2"}}}}`,
+		`+ N:#1`,
+	)
+}
+
+func TestDiffStructural(t *testing.T) {
+	// Nodes with the same content are told apart by their neighbours.
+	checkDiff(t,
+		`{"N":{"#1":{"X":1,"L":[{"$ref":"N:#3"}]},"#2":{"X":1,"L":[{"$ref":"N:#4"}]},"#3":{"Y":"a"},"#4":{"Y":"b"}}}`,
+		`{"N":{"#1":{"Y":"b"},"#2":{"X":1,"L":[{"$ref":"N:#1"}]},"#3":{"Y":"a"},"#4":{"X":1,"L":[{"$ref":"N:#3"},null]}}}`,
+		`~ N:#1 (#4) L: [{"$ref":"N:#3"}] -> [{"$ref":"N:#3"},null]`,
+	)
+}
+
+func TestDiffSimilar(t *testing.T) {
+	// Changed nodes are matched if most of their fields are the same.
+	checkDiff(t,
+		`{"N":{"#1":{"A":1,"B":2,"C":{"$ref":"N:#2"}},"#2":{"D":{"x":1,"y":[1,2]}}}}`,
+		`{"N":{"#1":{"D":{"x":1,"y":[1,3]}},"#2":{"A":1,"B":3,"C":{"$ref":"N:#1"}}}}`,
+		`~ N:#1 (#2) B: 2 -> 3`,
+		`~ N:#2 (#1) D.y[1]: 2 -> 3`,
+	)
+}
+
+func TestDiffMasters(t *testing.T) {
+	a := newGraphMaster()
+	b := newGraphMaster()
+	b.Nodes[1].Name = "d"
+	// Auto IDs in b are assigned in a different order.
+	b.Nodes[0].Next = b.Nodes[2]
+	changes, err := Diff(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 ||
+		changes[0].Kind != RefChanged || changes[0].Path != "Next" || changes[0].After != (Ref{"Nodes", "#2"}) ||
+		changes[1].Kind != FieldChanged || changes[1].Path != "Name" || changes[1].B != (Ref{"Nodes", "#3"}) {
+		t.Errorf("unexpected changes %v", changes)
+	}
+}
+
+// listDocuments returns two linked lists of n nodes that differ in
+// the values of all the nodes.
+func listDocuments(n int) (*Document, *Document) {
+	da, db := NewDocument(), NewDocument()
+	for i := 1; i <= n; i++ {
+		id := fmt.Sprintf("#%d", i)
+		na, _ := da.AddNode("N", id)
+		nb, _ := db.AddNode("N", id)
+		na.SetValue("V", i)
+		nb.SetValue("V", i+n)
+		if i < n {
+			next := Ref{Type: "N", ID: fmt.Sprintf("#%d", i+1)}
+			na.SetValue("Next", next)
+			nb.SetValue("Next", next)
+		}
+	}
+	return da, db
+}
+
+func TestDiffLargeList(t *testing.T) {
+	da, db := listDocuments(1000)
+	changes, err := Diff(da, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1000 || changes[0].Kind != FieldChanged || changes[0].A != changes[0].B {
+		t.Errorf("unexpected changes %d %v", len(changes), changes[0])
+	}
+}
+
+func BenchmarkDiffList(b *testing.B) {
+	da, db := listDocuments(1000)
+	for i := 0; i < b.N; i++ {
+		_, err := Diff(da, db)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,477 @@
+/*
+	Copyright (c) 2020 Martin Sustrik
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy
+	of this software and associated documentation files (the "Software"),
+	to deal in the Software without restriction, including without limitation
+	the rights to use, copy, modify, merge, publish, distribute, sublicense,
+	and/or sell copies of the Software, and to permit persons to whom
+	the Software is furnished to do so, subject to the following conditions:
+	The above copyright notice and this permission notice shall be included
+	in all copies or substantial portions of the Software.
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+	FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+	IN THE SOFTWARE.
+*/
+
+package grison
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Ref identifies a node by its collection name and ID.
+type Ref struct {
+	Type string
+	ID   string
+}
+
+// ParseRef parses reference string in "Type:ID" format.
+func ParseRef(s string) (Ref, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return Ref{}, fmt.Errorf("malformed reference %q", s)
+	}
+	return Ref{Type: parts[0], ID: parts[1]}, nil
+}
+
+func (r Ref) String() string {
+	return fmt.Sprintf("%s:%s", r.Type, r.ID)
+}
+
+// Document is a schema-less representation of a grison file. It can be used
+// to inspect and modify grison files without having the Go types that were
+// used to produce them. A document can be read from multiple goroutines
+// as long as it's not being modified.
+type Document struct {
+	nodes map[string]map[string]*Node
+	// Index of incoming references. Built on demand and dropped
+	// whenever the document changes.
+	backrefs map[Ref][]Edge
+	// Guards building of the index by concurrent readers.
+	backrefsMu sync.Mutex
+}
+
+// Node is a single node in a Document.
+type Node struct {
+	doc    *Document
+	ref    Ref
+	fields map[string]json.RawMessage
+}
+
+// Edge is a reference from a field of one node to another node.
+type Edge struct {
+	From *Node
+	// Path of the reference within the node, e.g. "Children[1]" or "Pets.cat".
+	Path string
+	To   Ref
+}
+
+// Target returns the node the edge points to or nil if the reference is dangling.
+func (e Edge) Target() *Node {
+	return e.From.doc.Node(e.To.Type, e.To.ID)
+}
+
+// NewDocument creates an empty document.
+func NewDocument() *Document {
+	return &Document{nodes: make(map[string]map[string]*Node)}
+}
+
+// ParseDocument parses a grison file. It checks that the file consists of
+// collections of nodes, but it doesn't check whether references are valid.
+func ParseDocument(b []byte) (*Document, error) {
+	var rmm map[string]map[string]json.RawMessage
+	err := json.Unmarshal(b, &rmm)
+	if err != nil {
+		return nil, err
+	}
+	if rmm == nil {
+		return nil, fmt.Errorf("grison document must be an object")
+	}
+	doc := NewDocument()
+	for tp, rms := range rmm {
+		if rms == nil {
+			return nil, fmt.Errorf("collection %s is not an object", tp)
+		}
+		doc.AddType(tp)
+		for id, rm := range rms {
+			var fields map[string]json.RawMessage
+			err = json.Unmarshal(rm, &fields)
+			if err != nil || fields == nil {
+				return nil, fmt.Errorf("node %s:%s is not an object", tp, id)
+			}
+			doc.nodes[tp][id] = &Node{doc: doc, ref: Ref{Type: tp, ID: id}, fields: fields}
+		}
+	}
+	return doc, nil
+}
+
+// MarshalDocument converts the graph reachable from the master structure
+// into a document. It is equivalent to parsing the output of MarshalWithOpts.
+func MarshalDocument(m interface{}, opts MarshalOpts) (*Document, error) {
+	enc, err := marshalInternal(m, opts)
+	if err != nil {
+		return nil, err
+	}
+	enc.filterEmpty()
+	return documentFromObjects(enc.objects)
+}
+
+// documentFromObjects creates a document from the objects produced by the encoder.
+func documentFromObjects(objects map[string]map[string]json.RawMessage) (*Document, error) {
+	doc := NewDocument()
+	for tp, rms := range objects {
+		doc.AddType(tp)
+		for id, rm := range rms {
+			var fields map[string]json.RawMessage
+			err := json.Unmarshal(rm, &fields)
+			if err != nil {
+				return nil, err
+			}
+			doc.nodes[tp][id] = &Node{doc: doc, ref: Ref{Type: tp, ID: id}, fields: fields}
+		}
+	}
+	return doc, nil
+}
+
+// UnmarshalDocument fills in the master structure from the document.
+func UnmarshalDocument(doc *Document, m interface{}, opts UnmarshalOpts) error {
+	b, err := doc.Marshal(MarshalOpts{})
+	if err != nil {
+		return err
+	}
+	opts.Format = FormatJSON
+	return UnmarshalWithOpts(b, m, opts)
+}
+
+// Marshal serializes the document. Prefix and Indent options are honored.
+func (d *Document) Marshal(opts MarshalOpts) ([]byte, error) {
+	objects := make(map[string]map[string]map[string]json.RawMessage)
+	for tp, nodes := range d.nodes {
+		objects[tp] = make(map[string]map[string]json.RawMessage)
+		for id, n := range nodes {
+			objects[tp][id] = n.fields
+		}
+	}
+	if opts.Prefix == "" && opts.Indent == "" {
+		return json.Marshal(objects)
+	}
+	return json.MarshalIndent(objects, opts.Prefix, opts.Indent)
+}
+
+// Types returns names of all collections in the document in alphabetical order.
+func (d *Document) Types() []string {
+	var tps []string
+	for tp := range d.nodes {
+		tps = append(tps, tp)
+	}
+	sort.Strings(tps)
+	return tps
+}
+
+// HasType returns true if the document contains the collection.
+func (d *Document) HasType(tp string) bool {
+	_, ok := d.nodes[tp]
+	return ok
+}
+
+// AddType creates an empty collection, unless it already exists.
+func (d *Document) AddType(tp string) {
+	if _, ok := d.nodes[tp]; !ok {
+		d.nodes[tp] = make(map[string]*Node)
+	}
+}
+
+// Nodes returns nodes of the collection ordered by ID. Numeric parts
+// of the IDs are compared by value so that "#2" sorts before "#10".
+func (d *Document) Nodes(tp string) []*Node {
+	nodes := make([]*Node, 0, len(d.nodes[tp]))
+	for _, n := range d.nodes[tp] {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return idLess(nodes[i].ref.ID, nodes[j].ref.ID)
+	})
+	return nodes
+}
+
+// AllNodes returns all the nodes in the document, ordered by collection and ID.
+func (d *Document) AllNodes() []*Node {
+	var nodes []*Node
+	for _, tp := range d.Types() {
+		nodes = append(nodes, d.Nodes(tp)...)
+	}
+	return nodes
+}
+
+// Node returns the node with the specified collection name and ID,
+// or nil if there's no such node.
+func (d *Document) Node(tp string, id string) *Node {
+	return d.nodes[tp][id]
+}
+
+// Resolve returns the node the reference points to or nil if there's no such node.
+func (d *Document) Resolve(ref Ref) *Node {
+	return d.Node(ref.Type, ref.ID)
+}
+
+// AddNode creates a new node with no fields. It fails if the node already exists.
+func (d *Document) AddNode(tp string, id string) (*Node, error) {
+	d.AddType(tp)
+	if _, ok := d.nodes[tp][id]; ok {
+		return nil, fmt.Errorf("node %s:%s already exists", tp, id)
+	}
+	n := &Node{doc: d, ref: Ref{Type: tp, ID: id}, fields: make(map[string]json.RawMessage)}
+	d.nodes[tp][id] = n
+	d.backrefs = nil
+	return n, nil
+}
+
+// RemoveNode removes the node from the document. References to the node,
+// if any, are left dangling.
+func (d *Document) RemoveNode(tp string, id string) {
+	delete(d.nodes[tp], id)
+	d.backrefs = nil
+}
+
+// Type returns the name of the collection the node belongs to.
+func (n *Node) Type() string {
+	return n.ref.Type
+}
+
+// ID returns ID of the node.
+func (n *Node) ID() string {
+	return n.ref.ID
+}
+
+// Ref returns the reference to the node.
+func (n *Node) Ref() Ref {
+	return n.ref
+}
+
+// FieldNames returns names of the node's fields in alphabetical order.
+func (n *Node) FieldNames() []string {
+	var names []string
+	for name := range n.fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Field returns the raw JSON value of the field or nil if there's no such field.
+func (n *Node) Field(name string) json.RawMessage {
+	return n.fields[name]
+}
+
+// SetField sets the field to the raw JSON value.
+func (n *Node) SetField(name string, rm json.RawMessage) error {
+	if !json.Valid(rm) {
+		return fmt.Errorf("invalid JSON value for field %s of %s", name, n.ref)
+	}
+	var b bytes.Buffer
+	err := json.Compact(&b, rm)
+	if err != nil {
+		return err
+	}
+	n.fields[name] = json.RawMessage(b.Bytes())
+	n.doc.backrefs = nil
+	return nil
+}
+
+// DeleteField removes the field from the node.
+func (n *Node) DeleteField(name string) {
+	delete(n.fields, name)
+	n.doc.backrefs = nil
+}
+
+// Value returns the decoded value of the field. JSON objects are returned
+// as map[string]interface{}, arrays as []interface{}, numbers as json.Number
+// and references as Ref. If there's no such field, nil is returned.
+func (n *Node) Value(name string) (interface{}, error) {
+	rm, ok := n.fields[name]
+	if !ok {
+		return nil, nil
+	}
+	return decodeValue(rm)
+}
+
+// SetValue encodes the value and stores it in the field. The value may
+// contain Ref values which will be encoded as references.
+func (n *Node) SetValue(name string, v interface{}) error {
+	rm, err := encodeValue(v)
+	if err != nil {
+		return err
+	}
+	return n.SetField(name, rm)
+}
+
+// Refs returns the references from the node to other nodes.
+func (n *Node) Refs() []Edge {
+	var edges []Edge
+	for _, name := range n.FieldNames() {
+		v, err := n.Value(name)
+		if err != nil {
+			continue
+		}
+		walkRefs(v, name, func(path string, ref Ref) {
+			edges = append(edges, Edge{From: n, Path: path, To: ref})
+		})
+	}
+	return edges
+}
+
+// Backrefs returns the references from other nodes to this node.
+func (n *Node) Backrefs() []Edge {
+	d := n.doc
+	d.backrefsMu.Lock()
+	defer d.backrefsMu.Unlock()
+	if d.backrefs == nil {
+		d.backrefs = make(map[Ref][]Edge)
+		for _, from := range d.AllNodes() {
+			for _, e := range from.Refs() {
+				d.backrefs[e.To] = append(d.backrefs[e.To], e)
+			}
+		}
+	}
+	return d.backrefs[n.ref]
+}
+
+// decodeValue decodes a JSON value into generic Go values, preserving
+// the formatting of numbers and turning references into Ref values.
+func decodeValue(rm json.RawMessage) (interface{}, error) {
+	d := json.NewDecoder(bytes.NewReader(rm))
+	d.UseNumber()
+	var v interface{}
+	err := d.Decode(&v)
+	if err != nil {
+		return nil, err
+	}
+	return resolveRefs(v)
+}
+
+func resolveRefs(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if len(v) == 1 {
+			if s, ok := v["$ref"]; ok {
+				str, ok := s.(string)
+				if !ok {
+					return nil, fmt.Errorf("invalid reference")
+				}
+				return ParseRef(str)
+			}
+		}
+		for k, item := range v {
+			item, err := resolveRefs(item)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = item
+		}
+	case []interface{}:
+		for i, item := range v {
+			item, err := resolveRefs(item)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = item
+		}
+	}
+	return v, nil
+}
+
+// encodeValue is the inverse of decodeValue.
+func encodeValue(v interface{}) (json.RawMessage, error) {
+	return json.Marshal(unresolveRefs(v))
+}
+
+func unresolveRefs(v interface{}) interface{} {
+	switch v := v.(type) {
+	case Ref:
+		return map[string]string{"$ref": v.String()}
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			m[k] = unresolveRefs(item)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, item := range v {
+			s[i] = unresolveRefs(item)
+		}
+		return s
+	}
+	return v
+}
+
+// walkRefs calls f for every reference in the decoded value, in a deterministic order.
+func walkRefs(v interface{}, path string, f func(path string, ref Ref)) {
+	switch v := v.(type) {
+	case Ref:
+		f(path, v)
+	case map[string]interface{}:
+		var keys []string
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			walkRefs(v[k], path+"."+k, f)
+		}
+	case []interface{}:
+		for i, item := range v {
+			walkRefs(item, fmt.Sprintf("%s[%d]", path, i), f)
+		}
+	}
+}
+
+// idLess orders node IDs. Runs of digits are compared by their numeric value.
+func idLess(a, b string) bool {
+	for a != "" && b != "" {
+		na, ra := splitNumber(a)
+		nb, rb := splitNumber(b)
+		if na != "" && nb != "" {
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			a, b = ra, rb
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+// splitNumber splits a leading run of digits off the string.
+// Leading zeros are stripped from the number.
+func splitNumber(s string) (string, string) {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	if i == 0 {
+		return "", s
+	}
+	n := strings.TrimLeft(s[:i], "0")
+	if n == "" {
+		n = "0"
+	}
+	return n, s[i:]
+}
@@ -0,0 +1,134 @@
+package grison
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+const exampleDoc = `{"Children":{"#3":{"Age":10,"Father":{"$ref":"Parents:#2"},"Mother":{"$ref":"Parents:#1"},"Name":"Carol"}},` +
+	`"Parents":{"#1":{"Children":[{"$ref":"Children:#3"}],"Name":"Alice","Spouse":{"$ref":"Parents:#2"}},` +
+	`"#2":{"Children":[{"$ref":"Children:#3"}],"Name":"Bob","Spouse":{"$ref":"Parents:#1"}}},"Pets":{}}`
+
+func TestDocumentRoundTrip(t *testing.T) {
+	doc, err := ParseDocument([]byte(exampleDoc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := doc.Marshal(MarshalOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != exampleDoc {
+		t.Errorf("document changed.\nexpect=%s\nactual=%s", exampleDoc, string(b))
+	}
+}
+
+func TestDocumentRefs(t *testing.T) {
+	doc, err := ParseDocument([]byte(exampleDoc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(doc.Types(), []string{"Children", "Parents", "Pets"}) {
+		t.Errorf("unexpected types %v", doc.Types())
+	}
+	alice := doc.Node("Parents", "#1")
+	if alice == nil {
+		t.Fatal("node not found")
+	}
+	var refs []string
+	for _, e := range alice.Refs() {
+		refs = append(refs, e.Path+"->"+e.To.String())
+		if e.Target() == nil {
+			t.Errorf("can't resolve %s", e.To)
+		}
+	}
+	if !reflect.DeepEqual(refs, []string{"Children[0]->Children:#3", "Spouse->Parents:#2"}) {
+		t.Errorf("unexpected refs %v", refs)
+	}
+	var backrefs []string
+	for _, e := range alice.Backrefs() {
+		backrefs = append(backrefs, e.From.Ref().String()+"."+e.Path)
+	}
+	if !reflect.DeepEqual(backrefs, []string{"Children:#3.Mother", "Parents:#2.Spouse"}) {
+		t.Errorf("unexpected backrefs %v", backrefs)
+	}
+	v, err := alice.Value("Name")
+	if err != nil || v != "Alice" {
+		t.Errorf("unexpected value %v (%v)", v, err)
+	}
+}
+
+func TestDocumentModify(t *testing.T) {
+	doc, err := ParseDocument([]byte(exampleDoc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := doc.AddNode("Pets", "#4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = n.SetValue("Owners", []interface{}{Ref{"Parents", "#1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(n.Field("Owners")) != `[{"$ref":"Parents:#1"}]` {
+		t.Errorf("unexpected field %s", n.Field("Owners"))
+	}
+	if len(doc.Node("Parents", "#1").Backrefs()) != 3 {
+		t.Errorf("backrefs not updated")
+	}
+	_, err = doc.AddNode("Pets", "#4")
+	if err == nil {
+		t.Errorf("duplicate node was added")
+	}
+	err = n.SetField("Name", json.RawMessage(`{"foo"`))
+	if err == nil {
+		t.Errorf("invalid JSON was accepted")
+	}
+}
+
+func TestDocumentFromMaster(t *testing.T) {
+	type Node struct {
+		N *Node
+	}
+	type Master struct {
+		Node []*Node
+	}
+	m := &Master{Node: []*Node{{}, {}}}
+	m.Node[0].N = m.Node[1]
+	doc, err := MarshalDocument(m, MarshalOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	edges := doc.Node("Node", "#2").Backrefs()
+	if len(edges) != 1 || edges[0].From.ID() != "#1" {
+		t.Errorf("unexpected backrefs %v", edges)
+	}
+	var m2 Master
+	err = UnmarshalDocument(doc, &m2, UnmarshalOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, &m2) {
+		t.Errorf("unexpected unmarshal result")
+	}
+}
+
+func TestDocumentInvalid(t *testing.T) {
+	for _, s := range []string{`[]`, `null`, `{"Node":null}`, `{"Node":{"#1":3}}`, `{"Node":{"#1":null}}`} {
+		_, err := ParseDocument([]byte(s))
+		if err == nil {
+			t.Errorf("%s was accepted", s)
+		}
+	}
+}
+
+func TestIDOrder(t *testing.T) {
+	ids := []string{"#1", "#2", "#10", "a", "a2", "a10", "b"}
+	for i := 0; i < len(ids)-1; i++ {
+		if !idLess(ids[i], ids[i+1]) || idLess(ids[i+1], ids[i]) {
+			t.Errorf("%s should sort before %s", ids[i], ids[i+1])
+		}
+	}
+}
@@ -0,0 +1,175 @@
+/*
+	Copyright (c) 2020 Martin Sustrik
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy
+	of this software and associated documentation files (the "Software"),
+	to deal in the Software without restriction, including without limitation
+	the rights to use, copy, modify, merge, publish, distribute, sublicense,
+	and/or sell copies of the Software, and to permit persons to whom
+	the Software is furnished to do so, subject to the following conditions:
+	The above copyright notice and this permission notice shall be included
+	in all copies or substantial portions of the Software.
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+	FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+	IN THE SOFTWARE.
+*/
+
+package grison
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DOTOpts control the Graphviz DOT output.
+type DOTOpts struct {
+	// Fields lists, for each collection, names of the fields to show in
+	// the vertex labels. Only scalar fields are shown.
+	Fields map[string][]string
+	// Types, if not empty, restricts the output to the listed collections.
+	Types []string
+	// Root, if set, restricts the output to the nodes that are at most
+	// Depth references away from the root node, in either direction.
+	Root  *Ref
+	Depth int
+	// GetIDs is used when marshaling a master structure. See MarshalOpts.
+	GetIDs bool
+}
+
+// MarshalDOT renders the graph reachable from the master structure in
+// Graphviz DOT format.
+func MarshalDOT(m interface{}, opts DOTOpts) ([]byte, error) {
+	doc, err := MarshalDocument(m, MarshalOpts{GetIDs: opts.GetIDs})
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	err = doc.WriteDOT(&b, opts)
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// WriteDOT renders the document in Graphviz DOT format. Each node becomes
+// a vertex, each reference becomes an edge labelled by the path of the field
+// it was found in. Nodes are clustered by collection. Dangling references
+// are omitted.
+func (d *Document) WriteDOT(w io.Writer, opts DOTOpts) error {
+	include, err := d.dotNodes(opts)
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	b.WriteString("digraph grison {\n")
+	for i, tp := range d.Types() {
+		first := true
+		for _, n := range d.Nodes(tp) {
+			if !include[n.Ref()] {
+				continue
+			}
+			if first {
+				fmt.Fprintf(&b, "    subgraph cluster_%d {\n", i)
+				fmt.Fprintf(&b, "        label=%s;\n", dotQuote(tp))
+				first = false
+			}
+			label := n.Ref().String()
+			for _, name := range opts.Fields[tp] {
+				v, err := n.Value(name)
+				if err != nil {
+					return err
+				}
+				switch v.(type) {
+				case string, json.Number, bool:
+					s, _ := json.Marshal(v)
+					label += fmt.Sprintf("\n%s=%s", name, s)
+				}
+			}
+			fmt.Fprintf(&b, "        %s [label=%s];\n", dotQuote(n.Ref().String()), dotQuote(label))
+		}
+		if !first {
+			b.WriteString("    }\n")
+		}
+	}
+	for _, n := range d.AllNodes() {
+		if !include[n.Ref()] {
+			continue
+		}
+		for _, e := range n.Refs() {
+			if !include[e.To] {
+				continue
+			}
+			fmt.Fprintf(&b, "    %s -> %s [label=%s];\n",
+				dotQuote(n.Ref().String()), dotQuote(e.To.String()), dotQuote(e.Path))
+		}
+	}
+	b.WriteString("}\n")
+	_, err = w.Write(b.Bytes())
+	return err
+}
+
+// dotNodes returns the set of nodes that pass the filters.
+func (d *Document) dotNodes(opts DOTOpts) (map[Ref]bool, error) {
+	types := make(map[string]bool)
+	for _, tp := range opts.Types {
+		types[tp] = true
+	}
+	include := make(map[Ref]bool)
+	if opts.Root == nil {
+		for _, n := range d.AllNodes() {
+			if len(types) == 0 || types[n.Type()] {
+				include[n.Ref()] = true
+			}
+		}
+		return include, nil
+	}
+	root := d.Resolve(*opts.Root)
+	if root == nil {
+		return nil, fmt.Errorf("node %s not found", *opts.Root)
+	}
+	// Breadth-first search ignoring the direction of the references.
+	dist := map[Ref]int{root.Ref(): 0}
+	queue := []*Node{root}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		if dist[n.Ref()] >= opts.Depth {
+			continue
+		}
+		var neighbours []*Node
+		for _, e := range n.Refs() {
+			if t := e.Target(); t != nil {
+				neighbours = append(neighbours, t)
+			}
+		}
+		for _, e := range n.Backrefs() {
+			neighbours = append(neighbours, e.From)
+		}
+		for _, nb := range neighbours {
+			if _, ok := dist[nb.Ref()]; !ok {
+				dist[nb.Ref()] = dist[n.Ref()] + 1
+				queue = append(queue, nb)
+			}
+		}
+	}
+	for ref := range dist {
+		if len(types) == 0 || types[ref.Type] {
+			include[ref] = true
+		}
+	}
+	return include, nil
+}
+
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, "\\", "\\\\")
+	s = strings.ReplaceAll(s, "\"", "\\\"")
+	s = strings.ReplaceAll(s, "\n", "\\n")
+	return "\"" + s + "\""
+}
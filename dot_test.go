@@ -0,0 +1,90 @@
+package grison
+
+import (
+	"bytes"
+	"testing"
+)
+
+func DOTTest(t *testing.T, opts DOTOpts, expected string) {
+	doc, err := ParseDocument([]byte(exampleDoc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	err = doc.WriteDOT(&b, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != expected {
+		t.Errorf("unexpected DOT output.\nexpect=%s\nactual=%s", expected, b.String())
+	}
+}
+
+func TestDOT(t *testing.T) {
+	DOTTest(t, DOTOpts{Fields: map[string][]string{"Parents": {"Name"}}}, `digraph grison {
+    subgraph cluster_0 {
+        label="Children";
+        "Children:#3" [label="Children:#3"];
+    }
+    subgraph cluster_1 {
+        label="Parents";
+        "Parents:#1" [label="Parents:#1\nName=\"Alice\""];
+        "Parents:#2" [label="Parents:#2\nName=\"Bob\""];
+    }
+    "Children:#3" -> "Parents:#2" [label="Father"];
+    "Children:#3" -> "Parents:#1" [label="Mother"];
+    "Parents:#1" -> "Children:#3" [label="Children[0]"];
+    "Parents:#1" -> "Parents:#2" [label="Spouse"];
+    "Parents:#2" -> "Children:#3" [label="Children[0]"];
+    "Parents:#2" -> "Parents:#1" [label="Spouse"];
+}
+`)
+}
+
+func TestDOTFilters(t *testing.T) {
+	DOTTest(t, DOTOpts{Types: []string{"Parents"}}, `digraph grison {
+    subgraph cluster_1 {
+        label="Parents";
+        "Parents:#1" [label="Parents:#1"];
+        "Parents:#2" [label="Parents:#2"];
+    }
+    "Parents:#1" -> "Parents:#2" [label="Spouse"];
+    "Parents:#2" -> "Parents:#1" [label="Spouse"];
+}
+`)
+	DOTTest(t, DOTOpts{Root: &Ref{"Children", "#3"}, Depth: 0}, `digraph grison {
+    subgraph cluster_0 {
+        label="Children";
+        "Children:#3" [label="Children:#3"];
+    }
+}
+`)
+}
+
+func TestMarshalDOT(t *testing.T) {
+	type Node struct {
+		N *Node
+	}
+	type Master struct {
+		Node []*Node
+	}
+	m := &Master{Node: []*Node{{}, {}, {}}}
+	m.Node[0].N = m.Node[1]
+	m.Node[1].N = m.Node[2]
+	b, err := MarshalDOT(m, DOTOpts{Root: &Ref{"Node", "#1"}, Depth: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `digraph grison {
+    subgraph cluster_0 {
+        label="Node";
+        "Node:#1" [label="Node:#1"];
+        "Node:#2" [label="Node:#2"];
+    }
+    "Node:#1" -> "Node:#2" [label="N"];
+}
+`
+	if string(b) != expected {
+		t.Errorf("unexpected DOT output.\nexpect=%s\nactual=%s", expected, string(b))
+	}
+}
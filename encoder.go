@@ -24,6 +24,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"sort"
 )
 
 // encoder handles encoding of graphs into grison format.
@@ -34,8 +35,14 @@ type encoder struct {
 	objects map[string]map[string]json.RawMessage
 	// Map of object pointers to IDs of the objects.
 	ids map[interface{}]string
+	// Pointers to the nodes in the order they were encountered.
+	nodes []reflect.Value
 	// Last generated object ID.
 	id uint64
+	// Number of nodes encountered so far in each collection.
+	counts map[string]int
+	// IDs allocated so far in each collection.
+	used map[string]map[string]bool
 	// Types marked with omitempty tag.
 	omitEmpty []string
 	opts      MarshalOpts
@@ -46,16 +53,35 @@ func newEncoder(m interface{}, opts MarshalOpts) (*encoder, error) {
 	enc := &encoder{
 		objects: make(map[string]map[string]json.RawMessage),
 		ids:     make(map[interface{}]string),
+		counts:  make(map[string]int),
+		used:    make(map[string]map[string]bool),
 		opts:    opts,
 	}
+	n := 0
+	for _, set := range []bool{opts.GetIDs, opts.ContentIDs, opts.IDAllocator != nil} {
+		if set {
+			n++
+		}
+	}
+	if n > 1 {
+		return nil, fmt.Errorf("only one of GetIDs, ContentIDs and IDAllocator options can be set")
+	}
 	tps, nms, oe, err := scrapeMasterStruct(m, opts.GetIDs)
 	if err != nil {
 		return nil, err
 	}
+	if opts.ContentIDs {
+		for tp := range tps {
+			if idField(tp) >= 0 {
+				return nil, fmt.Errorf("ContentIDs option can't be used with ID field in %v", tp)
+			}
+		}
+	}
 	enc.types = tps
 	enc.omitEmpty = oe
 	for nm := range nms {
 		enc.objects[nm] = make(map[string]json.RawMessage)
+		enc.used[nm] = make(map[string]bool)
 	}
 	return enc, nil
 }
@@ -65,20 +91,42 @@ func (enc *encoder) isNodeType(tp reflect.Type) bool {
 	return ok
 }
 
-func (enc *encoder) allocate(obj interface{}, newid string) (string, bool) {
+// allocate returns the ID of the node. If the node was encountered before,
+// true is returned along with the ID.
+func (enc *encoder) allocate(obj reflect.Value) (string, bool, error) {
 	// Use the pointer as a hash key.
-	id, ok := enc.ids[obj]
+	id, ok := enc.ids[obj.Interface()]
 	if ok {
-		return id, true
+		return id, true, nil
 	}
-	if newid == "" {
+	tp := enc.types[obj.Elem().Type()]
+	enc.counts[tp]++
+	idfld := idField(obj.Elem().Type())
+	switch {
+	case idfld >= 0:
+		var err error
+		id, err = idFieldValue(obj.Elem().Field(idfld))
+		if err != nil {
+			return "", false, err
+		}
+	case enc.opts.IDAllocator != nil:
+		var err error
+		id, err = enc.opts.IDAllocator.AllocateID(tp, enc.counts[tp], obj.Interface())
+		if err != nil {
+			return "", false, err
+		}
+	case enc.opts.GetIDs:
+		id = obj.Interface().(IDProvider).GetID()
+	default:
 		enc.id++
 		id = fmt.Sprintf("#%d", enc.id)
-	} else {
-		id = newid
 	}
-	enc.ids[obj] = id
-	return id, false
+	if enc.used[tp][id] {
+		return "", false, fmt.Errorf("duplicate ID %q in %s", id, tp)
+	}
+	enc.used[tp][id] = true
+	enc.ids[obj.Interface()] = id
+	return id, false, nil
 }
 
 func (enc *encoder) insert(tp reflect.Type, id string, rm json.RawMessage) {
@@ -150,13 +198,13 @@ func (enc *encoder) marshalInterface(obj reflect.Value) ([]byte, error) {
 }
 
 func (enc *encoder) marshalNode(obj reflect.Value) ([]byte, error) {
-	var id string
-	if enc.opts.GetIDs {
-		id = obj.Interface().(IDProvider).GetID()
+	id, exists, err := enc.allocate(obj)
+	if err != nil {
+		return nil, err
 	}
-	id, exists := enc.allocate(obj.Interface(), id)
 	eobj := obj.Elem()
 	if !exists {
+		enc.nodes = append(enc.nodes, obj)
 		rm, err := enc.marshalStruct(eobj)
 		if err != nil {
 			return nil, err
@@ -178,6 +226,10 @@ func (enc *encoder) marshalStruct(obj reflect.Value) ([]byte, error) {
 		if ft.omitEmpty && obj.Field(i).IsZero() {
 			continue
 		}
+		// ID of the node is stored as the key, not in the body.
+		if ft.id && enc.isNodeType(tp) {
+			continue
+		}
 		elem, err := enc.marshalAny(obj.Field(i))
 		if err != nil {
 			return []byte{}, err
@@ -214,10 +266,18 @@ func (enc *encoder) marshalMap(obj reflect.Value) ([]byte, error) {
 		return []byte("null"), nil
 	}
 	m := make(map[string]json.RawMessage)
-	keys := obj.MapKeys()
-	for _, k := range keys {
+	values := make(map[string]reflect.Value)
+	var keys []string
+	for _, k := range obj.MapKeys() {
 		key := fmt.Sprintf("%v", k.Interface())
-		elem, err := enc.marshalAny(obj.MapIndex(k))
+		values[key] = obj.MapIndex(k)
+		keys = append(keys, key)
+	}
+	// Marshal the elements in a fixed order so that the IDs of the nodes
+	// first encountered in the map are deterministic.
+	sort.Strings(keys)
+	for _, key := range keys {
+		elem, err := enc.marshalAny(values[key])
 		if err != nil {
 			return []byte{}, err
 		}
@@ -246,6 +306,12 @@ func marshalInternal(m interface{}, opts MarshalOpts) (*encoder, error) {
 			}
 		}
 	}
+	if opts.ContentIDs {
+		err = enc.assignContentIDs()
+		if err != nil {
+			return nil, err
+		}
+	}
 	return enc, nil
 }
 
@@ -253,13 +319,51 @@ type IDProvider interface {
 	GetID() string
 }
 
+// Format is the wire format used by MarshalWithOpts and UnmarshalWithOpts.
+type Format int
+
+const (
+	FormatJSON Format = iota
+	FormatYAML
+	FormatCBOR
+	FormatMsgPack
+	FormatJSONLD
+	FormatProto
+)
+
 type MarshalOpts struct {
 	Prefix string
 	Indent string
 	GetIDs bool
+	// Allocator of node IDs. If not set, IDs are generated automatically
+	// (#1, #2 etc.)
+	IDAllocator IDAllocator
+	// Derive node IDs from hashes of the nodes' content, see NodeHashes.
+	// Identical nodes get the same ID and are therefore stored only once.
+	// Can't be combined with GetIDs or IDAllocator.
+	ContentIDs bool
+	// Format of the output. Prefix and Indent apply only to JSON and JSON-LD.
+	Format Format
+	// Base IRI of the nodes in JSON-LD and N-Triples.
+	BaseIRI string
 }
 
 func MarshalWithOpts(m interface{}, opts MarshalOpts) ([]byte, error) {
+	switch opts.Format {
+	case FormatJSON:
+	case FormatYAML:
+		return MarshalYAML(m, opts)
+	case FormatCBOR:
+		return MarshalCBOR(m, opts)
+	case FormatMsgPack:
+		return MarshalMsgPack(m, opts)
+	case FormatJSONLD:
+		return marshalJSONLD(m, opts)
+	case FormatProto:
+		return MarshalProto(m, opts)
+	default:
+		return nil, fmt.Errorf("unknown format %d", opts.Format)
+	}
 	enc, err := marshalInternal(m, opts)
 	if err != nil {
 		return nil, err
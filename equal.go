@@ -0,0 +1,212 @@
+/*
+	Copyright (c) 2020 Martin Sustrik
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy
+	of this software and associated documentation files (the "Software"),
+	to deal in the Software without restriction, including without limitation
+	the rights to use, copy, modify, merge, publish, distribute, sublicense,
+	and/or sell copies of the Software, and to permit persons to whom
+	the Software is furnished to do so, subject to the following conditions:
+	The above copyright notice and this permission notice shall be included
+	in all copies or substantial portions of the Software.
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+	FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+	IN THE SOFTWARE.
+*/
+
+package grison
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Equal reports whether the two master structures describe the same graph,
+// i.e. whether their nodes can be paired so that the paired nodes have equal
+// values and their references point to paired nodes. Node IDs, except for
+// those stored in ID fields, and the order of the nodes in the master
+// collections don't matter. If the graphs are
+// not equal, the first difference found is described in the returned string,
+// with the nodes identified by their positions in the master collections,
+// e.g. `a.Parents[1].Name = "Alice", b.Parents[0].Name = "Alicia"`.
+func Equal(a, b interface{}) (bool, string, error) {
+	da, la, err := equalDocument(a, "a.")
+	if err != nil {
+		return false, "", err
+	}
+	db, lb, err := equalDocument(b, "b.")
+	if err != nil {
+		return false, "", err
+	}
+	types := da.Types()
+	for _, tp := range db.Types() {
+		if !da.HasType(tp) {
+			types = append(types, tp)
+		}
+	}
+	for _, tp := range types {
+		if len(da.Nodes(tp)) != len(db.Nodes(tp)) {
+			return false, fmt.Sprintf("%s: %d nodes != %d nodes", tp, len(da.Nodes(tp)), len(db.Nodes(tp))), nil
+		}
+	}
+	mt := newMatching(da, db)
+	// IDs taken from ID fields are part of the nodes' content.
+	for _, na := range da.AllNodes() {
+		if !isAutoID(na.ID()) && db.Resolve(na.Ref()) != nil {
+			mt.match(na.Ref(), na.Ref())
+		}
+	}
+	mt.matchIsomorphic()
+	// The graphs are not equal if some nodes remain unmatched. Match them
+	// the same way as Diff does to be able to describe the difference.
+	for mt.matchSimilar() {
+		for mt.matchStructurally() {
+		}
+	}
+	for _, na := range da.AllNodes() {
+		rb, ok := mt.ab[na.Ref()]
+		if !ok {
+			return false, fmt.Sprintf("%s: no matching node", la.label(na.Ref())), nil
+		}
+		nb := db.Resolve(rb)
+		for _, name := range unionFields(na, nb) {
+			va, _ := na.Value(name)
+			vb, _ := nb.Value(name)
+			changes := mt.diffValue(nil, na.Ref(), rb, pathKey(name), va, vb)
+			if len(changes) > 0 {
+				c := changes[0]
+				return false, fmt.Sprintf("%s.%s = %s, %s.%s = %s",
+					la.label(c.A), c.Path, la.format(c.Before),
+					lb.label(c.B), c.Path, lb.format(c.After)), nil
+			}
+		}
+	}
+	return true, "", nil
+}
+
+// matchIsomorphic matches indistinguishable nodes. Classes of nodes with
+// the same color are matched at once if their members are interchangeable,
+// i.e. they neither refer to nor are referred to by unmatched nodes, or if
+// they have a single member on both sides. Otherwise, only a single pair is
+// matched and the colors are refined again before matching the rest.
+func (mt *matching) matchIsomorphic() {
+	for {
+		ua, ub, colors := mt.refine()
+		count := make(map[string]int)
+		classes := make(map[string][]*Node)
+		shared := make(map[string]bool)
+		for _, n := range ua {
+			count[colors[n]]++
+			if !mt.isolated(n, true) {
+				shared[colors[n]] = true
+			}
+		}
+		for _, n := range ub {
+			classes[colors[n]] = append(classes[colors[n]], n)
+			if !mt.isolated(n, false) {
+				shared[colors[n]] = true
+			}
+		}
+		found := false
+		next := make(map[string]int)
+		for _, na := range ua {
+			col := colors[na]
+			c := classes[col]
+			if next[col] == len(c) || (count[col] != len(c) || len(c) > 1) && shared[col] {
+				continue
+			}
+			mt.match(na.Ref(), c[next[col]].Ref())
+			next[col]++
+			found = true
+		}
+		if !found {
+			for _, na := range ua {
+				c := classes[colors[na]]
+				if len(c) > 0 {
+					mt.match(na.Ref(), c[0].Ref())
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			return
+		}
+	}
+}
+
+// isolated returns true if the node neither refers to nor is referred to
+// by unmatched nodes.
+func (mt *matching) isolated(n *Node, sideA bool) bool {
+	doc, matched := mt.db, mt.ba
+	if sideA {
+		doc, matched = mt.da, mt.ab
+	}
+	isolated := true
+	for name, v := range mt.values(n) {
+		walkRefs(v, name, func(path string, ref Ref) {
+			if _, ok := matched[ref]; !ok && doc.Resolve(ref) != nil {
+				isolated = false
+			}
+		})
+	}
+	for _, e := range n.Backrefs() {
+		if _, ok := matched[e.From.Ref()]; !ok {
+			isolated = false
+		}
+	}
+	return isolated
+}
+
+// nodeLabels maps nodes to their positions in the master collections.
+// The labels are prefixed to tell the two compared graphs apart.
+type nodeLabels struct {
+	prefix string
+	pos    map[Ref]string
+}
+
+func (l nodeLabels) label(ref Ref) string {
+	if s, ok := l.pos[ref]; ok {
+		return l.prefix + s
+	}
+	return l.prefix + ref.String()
+}
+
+func (l nodeLabels) format(v interface{}) string {
+	if ref, ok := v.(Ref); ok {
+		return l.label(ref)
+	}
+	return formatValue(v)
+}
+
+// equalDocument converts the master structure into a document with
+// automatic IDs and returns the positions of the nodes in the master.
+func equalDocument(m interface{}, prefix string) (*Document, nodeLabels, error) {
+	labels := nodeLabels{prefix: prefix, pos: make(map[Ref]string)}
+	doc, err := MarshalDocument(m, MarshalOpts{})
+	if err != nil {
+		return nil, labels, err
+	}
+	w, err := newWalker(m, MarshalOpts{})
+	if err != nil {
+		return nil, labels, err
+	}
+	ms := reflect.ValueOf(m).Elem()
+	for i := 0; i < ms.NumField(); i++ {
+		if getFieldTags(ms.Type().Field(i)).ignore {
+			continue
+		}
+		fld := ms.Field(i)
+		for j := 0; j < fld.Len(); j++ {
+			ref, ok := w.ref(fld.Index(j))
+			if _, seen := labels.pos[ref]; ok && !seen {
+				labels.pos[ref] = fmt.Sprintf("%s[%d]", ms.Type().Field(i).Name, j)
+			}
+		}
+	}
+	return doc, labels, nil
+}
@@ -0,0 +1,90 @@
+package grison
+
+import (
+	"testing"
+)
+
+func checkEqual(t *testing.T, a, b interface{}, expected string) {
+	eq, diff, err := Equal(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if eq != (expected == "") || diff != expected {
+		t.Errorf("unexpected result %v %q, expected %q", eq, diff, expected)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	checkEqual(t, newGraphMaster(), newGraphMaster(), "")
+	// Order of the nodes in the master doesn't matter.
+	b := newGraphMaster()
+	b.Nodes[0], b.Nodes[2] = b.Nodes[2], b.Nodes[0]
+	checkEqual(t, newGraphMaster(), b, "")
+	b = newGraphMaster()
+	b.Nodes[1].Map["first"] = b.Nodes[2]
+	checkEqual(t, newGraphMaster(), b, "a.Nodes[1].Map.first = a.Nodes[0], b.Nodes[1].Map.first = b.Nodes[2]")
+	b = newGraphMaster()
+	b.Nodes[0].Tags[1] = "z"
+	checkEqual(t, newGraphMaster(), b, `a.Nodes[0].Tags[1] = "y", b.Nodes[0].Tags[1] = "z"`)
+	b = newGraphMaster()
+	b.Nodes = append(b.Nodes, &graphNode{})
+	checkEqual(t, newGraphMaster(), b, "Nodes: 3 nodes != 4 nodes")
+}
+
+func TestEqualSymmetric(t *testing.T) {
+	// Two rings of three nodes each vs. a ring of six nodes. All nodes
+	// look the same locally.
+	ring := func(nodes []*graphNode, from, to int) {
+		for i := from; i < to; i++ {
+			next := i + 1
+			if next == to {
+				next = from
+			}
+			nodes[i].Next = nodes[next]
+		}
+	}
+	newRings := func(sizes ...int) *graphMaster {
+		m := &graphMaster{}
+		from := 0
+		for _, size := range sizes {
+			for i := 0; i < size; i++ {
+				m.Nodes = append(m.Nodes, &graphNode{})
+			}
+			ring(m.Nodes, from, from+size)
+			from += size
+		}
+		return m
+	}
+	checkEqual(t, newRings(3, 3), newRings(3, 3), "")
+	a := newRings(3, 3)
+	b := newRings(3, 3)
+	b.Nodes[0], b.Nodes[4] = b.Nodes[4], b.Nodes[0]
+	checkEqual(t, a, b, "")
+	eq, _, err := Equal(newRings(3, 3), newRings(6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if eq {
+		t.Errorf("rings reported equal")
+	}
+}
+
+func TestEqualManyIdentical(t *testing.T) {
+	newMaster := func(n int) *graphMaster {
+		m := &graphMaster{}
+		for i := 0; i < n; i++ {
+			m.Nodes = append(m.Nodes, &graphNode{Age: 1})
+		}
+		return m
+	}
+	checkEqual(t, newMaster(2000), newMaster(2000), "")
+	b := newMaster(2000)
+	b.Nodes[1000].Age = 2
+	eq, _, err := Equal(newMaster(2000), b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if eq {
+		t.Errorf("different graphs reported equal")
+	}
+}
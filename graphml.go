@@ -0,0 +1,392 @@
+/*
+	Copyright (c) 2020 Martin Sustrik
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy
+	of this software and associated documentation files (the "Software"),
+	to deal in the Software without restriction, including without limitation
+	the rights to use, copy, modify, merge, publish, distribute, sublicense,
+	and/or sell copies of the Software, and to permit persons to whom
+	the Software is furnished to do so, subject to the following conditions:
+	The above copyright notice and this permission notice shall be included
+	in all copies or substantial portions of the Software.
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+	FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+	IN THE SOFTWARE.
+*/
+
+package grison
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const graphMLNamespace = "http://graphml.graphdrawing.org/xmlns"
+
+type graphMLDoc struct {
+	XMLName xml.Name       `xml:"graphml"`
+	Xmlns   string         `xml:"xmlns,attr,omitempty"`
+	Keys    []graphMLKey   `xml:"key"`
+	Graph   graphMLGraphEl `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID       string `xml:"id,attr"`
+	For      string `xml:"for,attr"`
+	AttrName string `xml:"attr.name,attr"`
+	AttrType string `xml:"attr.type,attr"`
+}
+
+type graphMLGraphEl struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+const (
+	graphMLTypeKey = "type"
+	graphMLPathKey = "path"
+)
+
+// MarshalGraphML converts the graph reachable from the master structure into
+// GraphML. Each node becomes a <node> element with its scalar fields stored
+// in typed <data> elements, each reference becomes an <edge> element labelled
+// by the path of the field. Slices and maps of references additionally store
+// their length or keys, respectively. Fields that are neither scalars nor
+// references are stored as JSON strings. Prefix and Indent options are honored.
+func MarshalGraphML(m interface{}, opts MarshalOpts) ([]byte, error) {
+	w, err := newWalker(m, opts)
+	if err != nil {
+		return nil, err
+	}
+	doc := graphMLDoc{
+		Xmlns: graphMLNamespace,
+		Keys: []graphMLKey{
+			{ID: graphMLTypeKey, For: "node", AttrName: "type", AttrType: "string"},
+			{ID: graphMLPathKey, For: "edge", AttrName: "path", AttrType: "string"},
+		},
+		Graph: graphMLGraphEl{ID: "grison", EdgeDefault: "directed"},
+	}
+	keys := make(map[string]bool)
+	for _, wn := range w.nodes {
+		node := graphMLNode{
+			ID:   wn.ref.String(),
+			Data: []graphMLData{{Key: graphMLTypeKey, Value: wn.ref.Type}},
+		}
+		addEdge := func(path string, ref Ref) {
+			doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+				Source: node.ID,
+				Target: ref.String(),
+				Data:   []graphMLData{{Key: graphMLPathKey, Value: path}},
+			})
+		}
+		for _, f := range w.fields(wn) {
+			fv := wn.val.Field(f.index)
+			key := wn.ref.Type + "." + f.name
+			attrType := "string"
+			var value string
+			hasValue := true
+			switch f.kind {
+			case scalarField:
+				attrType = graphMLType(f.tp)
+				value, err = scalarText(fv)
+				if err != nil {
+					return nil, err
+				}
+			case refField:
+				hasValue = false
+				if ref, ok := w.ref(fv); ok {
+					addEdge(f.name, ref)
+				}
+			case refListField:
+				// Store the length so that nil elements and
+				// nil slices survive the round trip.
+				attrType = "int"
+				hasValue = fv.Kind() == reflect.Array || !fv.IsNil()
+				value = strconv.Itoa(fv.Len())
+				for i := 0; i < fv.Len(); i++ {
+					if ref, ok := w.ref(fv.Index(i)); ok {
+						addEdge(fmt.Sprintf("%s[%d]", f.name, i), ref)
+					}
+				}
+			case refMapField:
+				// Store the keys so that nil values survive the round trip.
+				hasValue = !fv.IsNil()
+				keys := []string{}
+				for _, k := range sortedMapKeys(fv) {
+					key := fmt.Sprintf("%v", k.Interface())
+					keys = append(keys, key)
+					if ref, ok := w.ref(fv.MapIndex(k)); ok {
+						addEdge(f.name+"."+key, ref)
+					}
+				}
+				b, err := json.Marshal(keys)
+				if err != nil {
+					return nil, err
+				}
+				value = string(b)
+			default:
+				b, err := w.marshalValue(fv)
+				if err != nil {
+					return nil, err
+				}
+				value = string(b)
+				// References nested in other values are shown as
+				// edges, but they are restored from the JSON.
+				v, err := decodeValue(b)
+				if err != nil {
+					return nil, err
+				}
+				walkRefs(v, f.name, addEdge)
+			}
+			if !hasValue {
+				continue
+			}
+			if !keys[key] {
+				keys[key] = true
+				doc.Keys = append(doc.Keys, graphMLKey{ID: key, For: "node", AttrName: f.name, AttrType: attrType})
+			}
+			node.Data = append(node.Data, graphMLData{Key: key, Value: value})
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, node)
+	}
+	var b []byte
+	if opts.Prefix == "" && opts.Indent == "" {
+		b, err = xml.Marshal(doc)
+	} else {
+		b, err = xml.MarshalIndent(doc, opts.Prefix, opts.Indent)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), b...), nil
+}
+
+// UnmarshalGraphML fills in the master structure from GraphML produced by MarshalGraphML.
+func UnmarshalGraphML(b []byte, m interface{}) error {
+	sch, err := newSchema(m)
+	if err != nil {
+		return err
+	}
+	var gdoc graphMLDoc
+	err = xml.Unmarshal(b, &gdoc)
+	if err != nil {
+		return err
+	}
+	keys := make(map[string]graphMLKey)
+	for _, k := range gdoc.Keys {
+		keys[k.ID] = k
+	}
+	doc := sch.newDocument()
+	fields := sch.fields
+	// Values of the fields, keyed by node and field name.
+	values := make(map[*Node]map[string]interface{})
+	for _, gn := range gdoc.Graph.Nodes {
+		ref, err := ParseRef(gn.ID)
+		if err != nil {
+			return err
+		}
+		if _, ok := sch.types[ref.Type]; !ok {
+			return fmt.Errorf("unknown node type %s", ref.Type)
+		}
+		n, err := doc.AddNode(ref.Type, ref.ID)
+		if err != nil {
+			return err
+		}
+		values[n] = make(map[string]interface{})
+		for _, d := range gn.Data {
+			if d.Key == graphMLTypeKey {
+				continue
+			}
+			k, ok := keys[d.Key]
+			if !ok {
+				return fmt.Errorf("unknown key %s", d.Key)
+			}
+			f, ok := findField(fields[ref.Type], k.AttrName)
+			if !ok {
+				return fmt.Errorf("unknown field %s in %s", k.AttrName, ref.Type)
+			}
+			var v interface{}
+			switch f.kind {
+			case scalarField:
+				v, err = parseScalar(d.Value, f.tp)
+			case refListField:
+				var l int
+				l, err = strconv.Atoi(d.Value)
+				v = make([]interface{}, l)
+			case refMapField:
+				var mkeys []string
+				err = json.Unmarshal([]byte(d.Value), &mkeys)
+				mv := make(map[string]interface{})
+				for _, k := range mkeys {
+					mv[k] = nil
+				}
+				v = mv
+			default:
+				v, err = decodeValue(json.RawMessage(d.Value))
+			}
+			if err != nil {
+				return fmt.Errorf("field %s of %s: %v", f.name, ref, err)
+			}
+			values[n][f.name] = v
+		}
+	}
+	for _, ge := range gdoc.Graph.Edges {
+		from, err := ParseRef(ge.Source)
+		if err != nil {
+			return err
+		}
+		to, err := ParseRef(ge.Target)
+		if err != nil {
+			return err
+		}
+		n := doc.Resolve(from)
+		if n == nil {
+			return fmt.Errorf("edge from unknown node %s", from)
+		}
+		var path string
+		for _, d := range ge.Data {
+			if d.Key == graphMLPathKey {
+				path = d.Value
+			}
+		}
+		f, rest, ok := splitFieldPath(fields[from.Type], path)
+		if !ok {
+			return fmt.Errorf("invalid edge path %s in %s", path, from)
+		}
+		switch f.kind {
+		case refField:
+			values[n][f.name] = to
+		case refListField:
+			l, _ := values[n][f.name].([]interface{})
+			i, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rest, "["), "]"))
+			if err != nil || i < 0 || i >= len(l) {
+				return fmt.Errorf("invalid edge path %s in %s", path, from)
+			}
+			l[i] = to
+		case refMapField:
+			mv, ok := values[n][f.name].(map[string]interface{})
+			if !ok || !strings.HasPrefix(rest, ".") {
+				return fmt.Errorf("invalid edge path %s in %s", path, from)
+			}
+			mv[rest[1:]] = to
+		}
+	}
+	for n, vals := range values {
+		for name, v := range vals {
+			err = n.SetValue(name, v)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return UnmarshalDocument(doc, m, UnmarshalOpts{})
+}
+
+func graphMLType(tp reflect.Type) string {
+	switch tp.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return "int"
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return "long"
+	case reflect.Float32:
+		return "float"
+	case reflect.Float64:
+		return "double"
+	}
+	return "string"
+}
+
+// scalarText returns textual representation of a scalar value.
+func scalarText(v reflect.Value) (string, error) {
+	if v.Kind() == reflect.String {
+		return v.String(), nil
+	}
+	b, err := json.Marshal(v.Interface())
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// parseScalar is the inverse of scalarText. It returns a value suitable for Node.SetValue.
+func parseScalar(s string, tp reflect.Type) (interface{}, error) {
+	switch tp.Kind() {
+	case reflect.String:
+		return s, nil
+	case reflect.Bool:
+		return strconv.ParseBool(s)
+	default:
+		_, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(s), nil
+	}
+}
+
+func findField(fields []walkedField, name string) (walkedField, bool) {
+	for _, f := range fields {
+		if f.name == name {
+			return f, true
+		}
+	}
+	return walkedField{}, false
+}
+
+// splitFieldPath splits a path like "Children[1]" into the field and the rest of the path.
+func splitFieldPath(fields []walkedField, path string) (walkedField, string, bool) {
+	var found walkedField
+	ok := false
+	for _, f := range fields {
+		if !strings.HasPrefix(path, f.name) {
+			continue
+		}
+		rest := path[len(f.name):]
+		if rest != "" && rest[0] != '[' && rest[0] != '.' {
+			continue
+		}
+		if !ok || len(f.name) > len(found.name) {
+			found = f
+			ok = true
+		}
+	}
+	return found, path[len(found.name):], ok
+}
+
+// sortedMapKeys returns the keys of the map ordered by their textual representation.
+func sortedMapKeys(v reflect.Value) []reflect.Value {
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprintf("%v", keys[i].Interface()) < fmt.Sprintf("%v", keys[j].Interface())
+	})
+	return keys
+}
@@ -0,0 +1,107 @@
+package grison
+
+import (
+	"strings"
+	"testing"
+)
+
+type graphNode struct {
+	Name    string
+	Age     int
+	Weight  float64
+	Alive   bool
+	Tags    []string
+	Next    *graphNode
+	Prev    interface{}
+	List    []*graphNode
+	Empty   []*graphNode
+	Map     map[string]*graphNode
+	Nested  struct{ N *graphNode }
+	Skipped int `grison:",omitempty"`
+}
+
+type graphOther struct{}
+
+type graphMaster struct {
+	Nodes []*graphNode
+	Other []*graphOther `grison:",omitempty"`
+}
+
+func newGraphMaster() *graphMaster {
+	m := &graphMaster{
+		Nodes: []*graphNode{
+			{Name: "a <&> b", Age: 42, Weight: 1.5, Alive: true, Tags: []string{"x", "y"}},
+			{Name: "c", Age: -1},
+			{},
+		},
+	}
+	m.Nodes[0].Next = m.Nodes[1]
+	m.Nodes[1].Prev = m.Nodes[0]
+	m.Nodes[0].List = []*graphNode{m.Nodes[2], nil, m.Nodes[0]}
+	m.Nodes[1].Empty = []*graphNode{}
+	m.Nodes[1].Map = map[string]*graphNode{"first": m.Nodes[0], "none": nil}
+	m.Nodes[2].Nested.N = m.Nodes[1]
+	return m
+}
+
+func TestGraphMLRoundTrip(t *testing.T) {
+	m := newGraphMaster()
+	b, err := MarshalGraphML(m, MarshalOpts{Indent: "  "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m2 graphMaster
+	err = UnmarshalGraphML(b, &m2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j1, err := Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j2, err := Marshal(&m2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(j1) != string(j2) {
+		t.Errorf("unexpected round trip result.\nexpect=%s\nactual=%s", j1, j2)
+	}
+}
+
+func TestGraphMLOutput(t *testing.T) {
+	b, err := MarshalGraphML(newGraphMaster(), MarshalOpts{Indent: "  "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		`<key id="Nodes.Age" for="node" attr.name="Age" attr.type="long"></key>`,
+		`<key id="Nodes.Alive" for="node" attr.name="Alive" attr.type="boolean"></key>`,
+		`<node id="Nodes:#1">`,
+		`<data key="Nodes.Name">a &lt;&amp;&gt; b</data>`,
+		`<data key="Nodes.Tags">[&#34;x&#34;,&#34;y&#34;]</data>`,
+		`<edge source="Nodes:#1" target="Nodes:#3">
+      <data key="path">List[0]</data>`,
+		`<edge source="Nodes:#3" target="Nodes:#2">
+      <data key="path">Nested.N</data>`,
+	} {
+		if !strings.Contains(string(b), s) {
+			t.Errorf("output doesn't contain %s\n%s", s, b)
+		}
+	}
+	if strings.Contains(string(b), "Skipped") {
+		t.Errorf("empty field was not omitted")
+	}
+}
+
+func TestGraphMLInvalid(t *testing.T) {
+	var m graphMaster
+	err := UnmarshalGraphML([]byte(`<graphml><graph><node id="Foo:#1"/></graph></graphml>`), &m)
+	if err == nil {
+		t.Errorf("unknown node type was accepted")
+	}
+	err = UnmarshalGraphML([]byte(`<graphml><graph><node id="Nodes:#1"/>`+
+		`<edge source="Nodes:#1" target="Nodes:#1"><data key="path">List[7]</data></edge></graph></graphml>`), &m)
+	if err == nil {
+		t.Errorf("invalid edge was accepted")
+	}
+}
@@ -0,0 +1,120 @@
+/*
+	Copyright (c) 2020 Martin Sustrik
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy
+	of this software and associated documentation files (the "Software"),
+	to deal in the Software without restriction, including without limitation
+	the rights to use, copy, modify, merge, publish, distribute, sublicense,
+	and/or sell copies of the Software, and to permit persons to whom
+	the Software is furnished to do so, subject to the following conditions:
+	The above copyright notice and this permission notice shall be included
+	in all copies or substantial portions of the Software.
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+	FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+	IN THE SOFTWARE.
+*/
+
+package grison
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// IDAllocator assigns IDs to the nodes when marshalling.
+type IDAllocator interface {
+	// AllocateID returns the ID of the node. The collection is the name of
+	// the master field the node belongs to, seq is the 1-based number of the
+	// node within the collection, in the order the nodes are reached, and
+	// node is the pointer to the node. IDs must be unique within each
+	// collection.
+	AllocateID(collection string, seq int, node interface{}) (string, error)
+}
+
+// IDAllocatorFunc allows to use an ordinary function as IDAllocator.
+type IDAllocatorFunc func(collection string, seq int, node interface{}) (string, error)
+
+func (f IDAllocatorFunc) AllocateID(collection string, seq int, node interface{}) (string, error) {
+	return f(collection, seq, node)
+}
+
+// CounterIDs numbers the nodes in each collection separately,
+// e.g. Parents:1, Parents:2, Children:1.
+func CounterIDs() IDAllocator {
+	return IDAllocatorFunc(func(collection string, seq int, node interface{}) (string, error) {
+		return strconv.Itoa(seq), nil
+	})
+}
+
+// PrefixedIDs numbers the nodes in each collection separately and prefixes
+// the numbers with the name of the node type, e.g. Parents:Parent-1.
+func PrefixedIDs() IDAllocator {
+	return IDAllocatorFunc(func(collection string, seq int, node interface{}) (string, error) {
+		return fmt.Sprintf("%s-%d", reflect.TypeOf(node).Elem().Name(), seq), nil
+	})
+}
+
+// UUIDv4IDs assigns random UUIDs (version 4) to the nodes. The random bytes
+// are read from r, e.g. crypto/rand.Reader or, to get reproducible IDs,
+// math/rand.New(math/rand.NewSource(seed)).
+func UUIDv4IDs(r io.Reader) IDAllocator {
+	return IDAllocatorFunc(func(collection string, seq int, node interface{}) (string, error) {
+		var u [16]byte
+		_, err := io.ReadFull(r, u[:])
+		if err != nil {
+			return "", err
+		}
+		return formatUUID(u, 4), nil
+	})
+}
+
+// UUIDv7IDs assigns time-ordered UUIDs (version 7) to the nodes. The
+// timestamp is obtained from now, e.g. time.Now, and the random bytes are
+// read from r. Using fixed time and seeded random source yields reproducible
+// IDs.
+func UUIDv7IDs(r io.Reader, now func() time.Time) IDAllocator {
+	return IDAllocatorFunc(func(collection string, seq int, node interface{}) (string, error) {
+		var u [16]byte
+		_, err := io.ReadFull(r, u[6:])
+		if err != nil {
+			return "", err
+		}
+		var ts [8]byte
+		binary.BigEndian.PutUint64(ts[:], uint64(now().UnixNano()/int64(time.Millisecond)))
+		copy(u[:6], ts[2:])
+		return formatUUID(u, 7), nil
+	})
+}
+
+// formatUUID sets the version and variant bits and formats the UUID.
+func formatUUID(u [16]byte, version byte) string {
+	u[6] = u[6]&0x0f | version<<4
+	u[8] = u[8]&0x3f | 0x80
+	s := hex.EncodeToString(u[:])
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+// idFieldValue formats the value of an ID field.
+func idFieldValue(v reflect.Value) (string, error) {
+	switch v.Kind() {
+	case reflect.String:
+		if v.String() == "" {
+			return "", fmt.Errorf("empty ID")
+		}
+		return v.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	}
+	return "", fmt.Errorf("ID field must be a string or an integer, it is %v", v.Type())
+}
@@ -0,0 +1,183 @@
+package grison
+
+import (
+	"math/rand"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func checkIDs(t *testing.T, alloc IDAllocator, expected ...string) {
+	m := newGraphMaster()
+	b, err := MarshalWithOpts(m, MarshalOpts{IDAllocator: alloc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := ParseDocument(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes := doc.Nodes("Nodes")
+	if len(nodes) != len(expected) {
+		t.Fatalf("unexpected nodes %s", b)
+	}
+	for i, n := range nodes {
+		if !regexp.MustCompile("^" + expected[i] + "$").MatchString(n.ID()) {
+			t.Errorf("unexpected ID %s, expected %s", n.ID(), expected[i])
+		}
+	}
+	var m2 graphMaster
+	err = Unmarshal(b, &m2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eq, diff, err := Equal(m, &m2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !eq {
+		t.Errorf("graph changed: %s", diff)
+	}
+}
+
+func TestCounterIDs(t *testing.T) {
+	checkIDs(t, CounterIDs(), "1", "2", "3")
+}
+
+func TestPrefixedIDs(t *testing.T) {
+	checkIDs(t, PrefixedIDs(), "graphNode-1", "graphNode-2", "graphNode-3")
+}
+
+func TestUUIDIDs(t *testing.T) {
+	v4 := "[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}"
+	checkIDs(t, UUIDv4IDs(rand.New(rand.NewSource(1))), v4, v4, v4)
+	now := func() time.Time { return time.Unix(1600000000, 0) }
+	v7 := "0174876e-8000-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}"
+	checkIDs(t, UUIDv7IDs(rand.New(rand.NewSource(1)), now), v7, v7, v7)
+	// Seeded source gives the same IDs every time.
+	b1, err := MarshalWithOpts(newGraphMaster(), MarshalOpts{IDAllocator: UUIDv4IDs(rand.New(rand.NewSource(1)))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b2, err := MarshalWithOpts(newGraphMaster(), MarshalOpts{IDAllocator: UUIDv4IDs(rand.New(rand.NewSource(1)))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b1) != string(b2) {
+		t.Errorf("IDs are not reproducible")
+	}
+}
+
+type fieldIDNode struct {
+	Key  string `grison:",id"`
+	Next *fieldIDNode
+}
+
+type fieldIDMaster struct {
+	Nodes []*fieldIDNode
+}
+
+func TestIDField(t *testing.T) {
+	m := &fieldIDMaster{Nodes: []*fieldIDNode{{Key: "a"}, {Key: "b"}}}
+	m.Nodes[0].Next = m.Nodes[1]
+	// The ID field is used without any options.
+	expected := `{"Nodes":{"a":{"Next":{"$ref":"Nodes:b"}},"b":{"Next":null}}}`
+	MarshalTest(t, m, expected)
+	// The ID field takes precedence over the allocator.
+	b, err := MarshalWithOpts(m, MarshalOpts{IDAllocator: PrefixedIDs()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != expected {
+		t.Errorf("unexpected result %s", b)
+	}
+	m.Nodes[1].Key = "a"
+	_, err = Marshal(m)
+	if err == nil || err.Error() != `duplicate ID "a" in Nodes` {
+		t.Errorf("unexpected error %v", err)
+	}
+	_, err = MarshalWithOpts(m, MarshalOpts{ContentIDs: true})
+	if err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+type intIDNode struct {
+	Num  int `grison:"n,id"`
+	Name string
+}
+
+type intIDMaster struct {
+	Nodes []*intIDNode
+	Other []*graphNode
+}
+
+func TestIntIDField(t *testing.T) {
+	m := &intIDMaster{
+		Nodes: []*intIDNode{{Num: 10, Name: "a"}, {Num: 2, Name: "b"}},
+		Other: []*graphNode{{Name: "c"}},
+	}
+	b, err := Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"Nodes":{"10":{"Name":"a"},"2":{"Name":"b"}},"Other":{"#1":{"Age":0,"Alive":false,` +
+		`"Empty":null,"List":null,"Map":null,"Name":"c","Nested":{"N":null},"Next":null,"Prev":null,"Tags":null,"Weight":0}}}`
+	if string(b) != expected {
+		t.Errorf("unexpected result %s", b)
+	}
+	var m2 intIDMaster
+	err = Unmarshal(b, &m2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eq, diff, err := Equal(m, &m2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !eq {
+		t.Errorf("graph changed: %s", diff)
+	}
+	if m2.Nodes[0].Num != 10 || m2.Nodes[1].Num != 2 {
+		t.Errorf("IDs not populated %+v %+v", m2.Nodes[0], m2.Nodes[1])
+	}
+	err = Unmarshal([]byte(`{"Nodes":{"2":{},"02":{}}}`), &m2)
+	if err == nil || err.Error() != `duplicate ID "2" in Nodes` {
+		t.Errorf("unexpected error %v", err)
+	}
+	err = Unmarshal([]byte(`{"Nodes":{"x":{}}}`), &m2)
+	if err == nil {
+		t.Errorf("expected error")
+	}
+	// Other formats honor the ID field as well.
+	b, err = MarshalYAML(m, MarshalOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m3 intIDMaster
+	err = UnmarshalYAML(b, &m3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if eq, diff, _ := Equal(m, &m3); !eq {
+		t.Errorf("graph changed: %s", diff)
+	}
+}
+
+type badIDNode struct {
+	ID float64 `grison:",id"`
+}
+
+func TestBadIDField(t *testing.T) {
+	_, err := Marshal(&struct{ Nodes []*badIDNode }{})
+	if err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestIDOptionsConflict(t *testing.T) {
+	_, err := MarshalWithOpts(newGraphMaster(), MarshalOpts{IDAllocator: CounterIDs(), ContentIDs: true})
+	if err == nil {
+		t.Errorf("expected error")
+	}
+}
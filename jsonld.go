@@ -0,0 +1,349 @@
+/*
+	Copyright (c) 2020 Martin Sustrik
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy
+	of this software and associated documentation files (the "Software"),
+	to deal in the Software without restriction, including without limitation
+	the rights to use, copy, modify, merge, publish, distribute, sublicense,
+	and/or sell copies of the Software, and to permit persons to whom
+	the Software is furnished to do so, subject to the following conditions:
+	The above copyright notice and this permission notice shall be included
+	in all copies or substantial portions of the Software.
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+	FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+	IN THE SOFTWARE.
+*/
+
+package grison
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+// jsonldTypeIRIs returns IRIs of the node types, keyed by collection name.
+// They are specified by jsonld struct tags on the fields of the master structure.
+func jsonldTypeIRIs(m interface{}) map[string]string {
+	typeIRIs := make(map[string]string)
+	mt := reflect.TypeOf(m).Elem()
+	for i := 0; i < mt.NumField(); i++ {
+		ft := getFieldTags(mt.Field(i))
+		if ft.ignore {
+			continue
+		}
+		if iri := mt.Field(i).Tag.Get("jsonld"); iri != "" {
+			typeIRIs[ft.name] = iri
+		}
+	}
+	return typeIRIs
+}
+
+// jsonldContext returns the JSON-LD context mapping field names to IRIs of
+// the properties. They are specified by jsonld struct tags on node fields.
+// If vocab is set, untagged slices and maps get term definitions relative to
+// it as well, so that their containers are not lost.
+func jsonldContext(sch *schema, vocab string) (map[string]interface{}, error) {
+	ctx := make(map[string]interface{})
+	for coll, tp := range sch.types {
+		for _, f := range sch.fields[coll] {
+			iri := tp.Field(f.index).Tag.Get("jsonld")
+			container := jsonldContainer(f)
+			if iri == "" {
+				if vocab == "" || container == "" {
+					continue
+				}
+				iri = f.name
+			}
+			var def interface{} = iri
+			if container != "" {
+				def = map[string]interface{}{"@id": iri, "@container": container}
+			}
+			if prev, ok := ctx[f.name]; ok && !reflect.DeepEqual(prev, def) {
+				return nil, fmt.Errorf("conflicting JSON-LD definitions of %s", f.name)
+			}
+			ctx[f.name] = def
+		}
+	}
+	return ctx, nil
+}
+
+// jsonldContainer returns the JSON-LD container of the field: @list for
+// values encoded as JSON arrays, @index for maps, empty string otherwise.
+func jsonldContainer(f walkedField) string {
+	switch f.kind {
+	case refListField:
+		return "@list"
+	case refMapField:
+		return "@index"
+	case scalarField:
+		return ""
+	}
+	if hasCustomMarshaler(f.tp) {
+		return ""
+	}
+	switch f.tp.Kind() {
+	case reflect.Slice:
+		// Byte slices are encoded as base64 strings.
+		if f.tp.Elem().Kind() == reflect.Uint8 {
+			return ""
+		}
+		return "@list"
+	case reflect.Array:
+		return "@list"
+	case reflect.Map:
+		return "@index"
+	}
+	return ""
+}
+
+// marshalJSONLD produces JSON-LD representation of the graph. Nodes are
+// listed in @graph, each having @id derived from its reference and @type
+// being either the IRI of the node type or, if not specified, the name of
+// the collection. References are represented as {"@id": ...} objects.
+// If BaseIRI option is set, it's used as @vocab so that the fields without
+// jsonld tags resolve to IRIs as well. Nulls in arrays are represented as
+// JSON literals, otherwise processors would drop them.
+func marshalJSONLD(m interface{}, opts MarshalOpts) ([]byte, error) {
+	sch, err := newSchema(m)
+	if err != nil {
+		return nil, err
+	}
+	ctx, err := jsonldContext(sch, opts.BaseIRI)
+	if err != nil {
+		return nil, err
+	}
+	typeIRIs := jsonldTypeIRIs(m)
+	if opts.BaseIRI != "" {
+		ctx["@base"] = opts.BaseIRI
+		// Fields and types without jsonld tags are relative to the base IRI.
+		ctx["@vocab"] = opts.BaseIRI
+	}
+	doc, err := MarshalDocument(m, opts)
+	if err != nil {
+		return nil, err
+	}
+	graph := []interface{}{}
+	for _, n := range doc.AllNodes() {
+		obj := map[string]interface{}{"@id": nodeIRI(n.Ref())}
+		if iri, ok := typeIRIs[n.Type()]; ok {
+			obj["@type"] = iri
+		} else {
+			obj["@type"] = n.Type()
+		}
+		for _, name := range n.FieldNames() {
+			v, err := n.Value(name)
+			if err != nil {
+				return nil, err
+			}
+			obj[name] = valueToJSONLD(v)
+		}
+		graph = append(graph, obj)
+	}
+	ld := map[string]interface{}{"@context": ctx, "@graph": graph}
+	if opts.Prefix == "" && opts.Indent == "" {
+		return json.Marshal(ld)
+	}
+	return json.MarshalIndent(ld, opts.Prefix, opts.Indent)
+}
+
+func valueToJSONLD(v interface{}) interface{} {
+	switch v := v.(type) {
+	case Ref:
+		return map[string]interface{}{"@id": nodeIRI(v)}
+	case []interface{}:
+		for i, item := range v {
+			// Plain nulls would be dropped from the arrays by JSON-LD
+			// processors, shifting the indices.
+			if item == nil {
+				v[i] = map[string]interface{}{"@value": nil, "@type": "@json"}
+				continue
+			}
+			v[i] = valueToJSONLD(item)
+		}
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = valueToJSONLD(item)
+		}
+	}
+	return v
+}
+
+// unmarshalJSONLD fills in the master structure from JSON-LD produced by
+// marshalJSONLD. Properties may be specified either by field names or by
+// their IRIs, including the ones relative to @vocab. Node types may be
+// specified either by collection names or by their IRIs. Lists and values
+// in the expanded form are accepted.
+func unmarshalJSONLD(b []byte, m interface{}, opts UnmarshalOpts) error {
+	sch, err := newSchema(m)
+	if err != nil {
+		return err
+	}
+	ctx, err := jsonldContext(sch, "")
+	if err != nil {
+		return err
+	}
+	typeIRIs := jsonldTypeIRIs(m)
+	fieldNames := make(map[string]string)
+	for name, def := range ctx {
+		if iri, ok := def.(string); ok {
+			fieldNames[iri] = name
+		} else {
+			fieldNames[def.(map[string]interface{})["@id"].(string)] = name
+		}
+	}
+	colls := make(map[string]string)
+	for coll, iri := range typeIRIs {
+		colls[iri] = coll
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var top interface{}
+	err = dec.Decode(&top)
+	if err != nil {
+		return err
+	}
+	var base, vocab string
+	var graph []interface{}
+	switch top := top.(type) {
+	case []interface{}:
+		graph = top
+	case map[string]interface{}:
+		if c, ok := top["@context"].(map[string]interface{}); ok {
+			base, _ = c["@base"].(string)
+			vocab, _ = c["@vocab"].(string)
+		}
+		if g, ok := top["@graph"]; ok {
+			graph, ok = g.([]interface{})
+			if !ok {
+				return fmt.Errorf("@graph is not an array")
+			}
+		} else {
+			graph = []interface{}{top}
+		}
+	default:
+		return fmt.Errorf("JSON-LD document must be an object or an array")
+	}
+	doc := sch.newDocument()
+	for _, item := range graph {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("JSON-LD node is not an object")
+		}
+		id, _ := obj["@id"].(string)
+		ref, err := parseNodeIRI(id, base)
+		if err != nil {
+			return err
+		}
+		tp := obj["@type"]
+		if l, ok := tp.([]interface{}); ok && len(l) == 1 {
+			tp = l[0]
+		}
+		tps, _ := tp.(string)
+		if coll, ok := colls[tps]; ok {
+			tps = coll
+		} else if vocab != "" && strings.HasPrefix(tps, vocab) {
+			tps = strings.TrimPrefix(tps, vocab)
+		}
+		if tps != ref.Type {
+			return fmt.Errorf("node %s has type %v", ref, obj["@type"])
+		}
+		if _, ok := sch.types[ref.Type]; !ok {
+			return fmt.Errorf("unknown node type %s", ref.Type)
+		}
+		n, err := doc.AddNode(ref.Type, ref.ID)
+		if err != nil {
+			return err
+		}
+		for k, v := range obj {
+			if strings.HasPrefix(k, "@") {
+				continue
+			}
+			if name, ok := fieldNames[k]; ok {
+				k = name
+			} else if vocab != "" && strings.HasPrefix(k, vocab) {
+				k = strings.TrimPrefix(k, vocab)
+			}
+			v, err = valueFromJSONLD(v, base)
+			if err != nil {
+				return fmt.Errorf("field %s of %s: %v", k, ref, err)
+			}
+			err = n.SetValue(k, v)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return UnmarshalDocument(doc, m, opts)
+}
+
+func valueFromJSONLD(v interface{}, base string) (interface{}, error) {
+	switch v := v.(type) {
+	case []interface{}:
+		for i, item := range v {
+			item, err := valueFromJSONLD(item, base)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = item
+		}
+	case map[string]interface{}:
+		if len(v) == 1 {
+			if id, ok := v["@id"].(string); ok {
+				return parseNodeIRI(id, base)
+			}
+			if l, ok := v["@list"]; ok {
+				return valueFromJSONLD(l, base)
+			}
+			if val, ok := v["@value"]; ok {
+				return val, nil
+			}
+		}
+		if len(v) == 2 && (v["@type"] == "@json" || v["@type"] == rdfJSON) {
+			if val, ok := v["@value"]; ok {
+				return val, nil
+			}
+		}
+		for k, item := range v {
+			item, err := valueFromJSONLD(item, base)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = item
+		}
+	}
+	return v, nil
+}
+
+// nodeIRI returns relative IRI of the node, e.g. "Parents/%231".
+func nodeIRI(ref Ref) string {
+	return url.PathEscape(ref.Type) + "/" + url.PathEscape(ref.ID)
+}
+
+// parseNodeIRI is the inverse of nodeIRI. IRIs resolved against the base
+// IRI are accepted as well.
+func parseNodeIRI(iri string, base string) (Ref, error) {
+	if base != "" {
+		iri = strings.TrimPrefix(iri, base)
+	}
+	parts := strings.SplitN(iri, "/", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return Ref{}, fmt.Errorf("malformed node IRI %q", iri)
+	}
+	tp, err := url.PathUnescape(parts[0])
+	if err != nil {
+		return Ref{}, err
+	}
+	id, err := url.PathUnescape(parts[1])
+	if err != nil {
+		return Ref{}, err
+	}
+	return Ref{Type: tp, ID: id}, nil
+}
@@ -0,0 +1,136 @@
+package grison
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type ldPerson struct {
+	Name    string      `jsonld:"http://schema.org/name"`
+	Spouse  *ldPerson   `jsonld:"http://schema.org/spouse"`
+	Friends []*ldPerson `jsonld:"http://schema.org/knows"`
+	Age     int
+}
+
+type ldMaster struct {
+	People []*ldPerson `jsonld:"http://schema.org/Person"`
+}
+
+func newLDMaster() *ldMaster {
+	m := &ldMaster{People: []*ldPerson{{Name: "Alice", Age: 30}, {Name: "Bob"}}}
+	m.People[0].Spouse = m.People[1]
+	m.People[1].Spouse = m.People[0]
+	m.People[1].Friends = []*ldPerson{m.People[0], nil}
+	return m
+}
+
+func TestJSONLD(t *testing.T) {
+	m := newLDMaster()
+	b, err := MarshalWithOpts(m, MarshalOpts{Format: FormatJSONLD, BaseIRI: "http://example.org/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"@context":{"@base":"http://example.org/","@vocab":"http://example.org/","Friends":{"@container":"@list","@id":"http://schema.org/knows"},` +
+		`"Name":"http://schema.org/name","Spouse":"http://schema.org/spouse"},"@graph":[` +
+		`{"@id":"People/%231","@type":"http://schema.org/Person","Age":30,"Friends":null,"Name":"Alice","Spouse":{"@id":"People/%232"}},` +
+		`{"@id":"People/%232","@type":"http://schema.org/Person","Age":0,"Friends":[{"@id":"People/%231"},{"@type":"@json","@value":null}],"Name":"Bob","Spouse":{"@id":"People/%231"}}]}`
+	if string(b) != expected {
+		t.Errorf("unexpected JSON-LD.\nexpect=%s\nactual=%s", expected, b)
+	}
+	var m2 ldMaster
+	err = UnmarshalWithOpts(b, &m2, UnmarshalOpts{Format: FormatJSONLD})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, &m2) {
+		t.Errorf("unexpected unmarshal result")
+	}
+}
+
+func TestJSONLDExpanded(t *testing.T) {
+	// Absolute IRIs, full property IRIs and expanded values.
+	b := []byte(`[
+		{"@id": "http://example.org/People/%231", "@type": ["http://schema.org/Person"],
+		 "http://schema.org/name": {"@value": "Alice"},
+		 "http://schema.org/knows": {"@list": [{"@id": "http://example.org/People/%231"}]}}
+	]`)
+	var m ldMaster
+	err := UnmarshalWithOpts(b, &m, UnmarshalOpts{Format: FormatJSONLD})
+	if err == nil {
+		t.Errorf("node IRIs not relative to the base accepted")
+	}
+	b = []byte(`{"@context": {"@base": "http://example.org/"}, "@graph": [
+		{"@id": "http://example.org/People/%231", "@type": ["http://schema.org/Person"],
+		 "http://schema.org/name": {"@value": "Alice"},
+		 "http://schema.org/knows": {"@list": [{"@id": "http://example.org/People/%231"}]}}
+	]}`)
+	err = UnmarshalWithOpts(b, &m, UnmarshalOpts{Format: FormatJSONLD})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.People) != 1 || m.People[0].Name != "Alice" || len(m.People[0].Friends) != 1 || m.People[0].Friends[0] != m.People[0] {
+		t.Errorf("unexpected unmarshal result %+v", m.People)
+	}
+}
+
+func TestJSONLDVocab(t *testing.T) {
+	// Untagged fields and types given by IRIs relative to @vocab.
+	b := []byte(`{"@context": {"@base": "http://example.org/", "@vocab": "http://example.org/"}, "@graph": [
+		{"@id": "Nodes/%231", "@type": "http://example.org/Nodes",
+		 "http://example.org/Name": "a",
+		 "http://example.org/List": {"@list": [{"@type": "@json", "@value": null}, {"@id": "Nodes/%231"}]}}
+	]}`)
+	var m graphMaster
+	err := UnmarshalWithOpts(b, &m, UnmarshalOpts{Format: FormatJSONLD})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Nodes) != 1 || m.Nodes[0].Name != "a" || len(m.Nodes[0].List) != 2 ||
+		m.Nodes[0].List[0] != nil || m.Nodes[0].List[1] != m.Nodes[0] {
+		t.Errorf("unexpected unmarshal result %+v", m.Nodes)
+	}
+}
+
+func TestJSONLDVocabContainers(t *testing.T) {
+	// Untagged slices and maps keep their containers when @vocab is used.
+	m := newGraphMaster()
+	b, err := MarshalWithOpts(m, MarshalOpts{Format: FormatJSONLD, BaseIRI: "http://example.org/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ld struct {
+		Context map[string]interface{} `json:"@context"`
+	}
+	err = json.Unmarshal(b, &ld)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"@base":  "http://example.org/",
+		"@vocab": "http://example.org/",
+		"Tags":   map[string]interface{}{"@id": "Tags", "@container": "@list"},
+		"List":   map[string]interface{}{"@id": "List", "@container": "@list"},
+		"Empty":  map[string]interface{}{"@id": "Empty", "@container": "@list"},
+		"Map":    map[string]interface{}{"@id": "Map", "@container": "@index"},
+	}
+	if !reflect.DeepEqual(ld.Context, expected) {
+		t.Errorf("unexpected context %v", ld.Context)
+	}
+	var m2 graphMaster
+	err = UnmarshalWithOpts(b, &m2, UnmarshalOpts{Format: FormatJSONLD})
+	if err != nil {
+		t.Fatal(err)
+	}
+	j1, err := Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j2, err := Marshal(&m2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(j1) != string(j2) {
+		t.Errorf("unexpected round trip result.\nexpect=%s\nactual=%s", j1, j2)
+	}
+}
@@ -0,0 +1,176 @@
+/*
+	Copyright (c) 2020 Martin Sustrik
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy
+	of this software and associated documentation files (the "Software"),
+	to deal in the Software without restriction, including without limitation
+	the rights to use, copy, modify, merge, publish, distribute, sublicense,
+	and/or sell copies of the Software, and to permit persons to whom
+	the Software is furnished to do so, subject to the following conditions:
+	The above copyright notice and this permission notice shall be included
+	in all copies or substantial portions of the Software.
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+	FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+	IN THE SOFTWARE.
+*/
+
+package grison
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Merge performs a three-way merge of graphs. Each of the arguments is
+// either a document or a master structure. Changes made in ours and in
+// theirs relative to base are combined at the granularity of nodes and
+// fields. Nodes added on both sides get distinct IDs. The same change made
+// on both sides is applied once. Changes in theirs that clash with changes
+// in ours, e.g. setting a field to different values or modifying a node
+// that was deleted on the other side, are reported as conflicts and the
+// version from ours is kept. References to nodes deleted on the other side
+// are reported as conflicts as well.
+func Merge(base, ours, theirs interface{}) (*Document, []Conflict, error) {
+	db, err := toDocument(base)
+	if err != nil {
+		return nil, nil, err
+	}
+	pours, err := MakePatch(db, ours)
+	if err != nil {
+		return nil, nil, err
+	}
+	ptheirs, err := MakePatch(db, theirs)
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := copyDocument(db)
+	if err != nil {
+		return nil, nil, err
+	}
+	conflicts := res.applyPatch(pours)
+	if len(conflicts) > 0 {
+		return nil, nil, &ConflictError{Conflicts: conflicts}
+	}
+	ptheirs, err = renameAdded(res, ptheirs)
+	if err != nil {
+		return nil, nil, err
+	}
+	applied := pours
+	for _, op := range ptheirs {
+		if res.isApplied(op) {
+			continue
+		}
+		err = res.applyOp(op)
+		if err != nil {
+			conflicts = append(conflicts, Conflict{Op: op, Msg: err.Error()})
+			continue
+		}
+		applied = append(applied, op)
+	}
+	for _, op := range applied {
+		if op.Value == nil {
+			continue
+		}
+		v, err := decodeValue(op.Value)
+		if err != nil {
+			return nil, nil, err
+		}
+		walkRefs(v, "", func(path string, ref Ref) {
+			if res.Resolve(ref) == nil {
+				conflicts = append(conflicts, Conflict{Op: op, Msg: fmt.Sprintf("reference to deleted node %s", ref)})
+			}
+		})
+	}
+	return res, conflicts, nil
+}
+
+// renameAdded assigns new IDs to the nodes added by the patch if they clash
+// with automatic IDs already present in the document.
+func renameAdded(d *Document, p Patch) (Patch, error) {
+	renames := make(map[Ref]Ref)
+	next := lastAutoID(d)
+	for _, op := range p {
+		if op.Op != PatchAdd {
+			continue
+		}
+		ref, err := ParseRef(op.Node)
+		if err != nil {
+			return nil, err
+		}
+		if isAutoID(ref.ID) && d.Resolve(ref) != nil {
+			next++
+			renames[ref] = Ref{Type: ref.Type, ID: fmt.Sprintf("#%d", next)}
+		}
+	}
+	if len(renames) == 0 {
+		return p, nil
+	}
+	rename := func(ref Ref) Ref {
+		if r, ok := renames[ref]; ok {
+			return r
+		}
+		return ref
+	}
+	res := make(Patch, len(p))
+	for i, op := range p {
+		ref, err := ParseRef(op.Node)
+		if err != nil {
+			return nil, err
+		}
+		op.Node = rename(ref).String()
+		if op.Value != nil {
+			v, err := decodeValue(op.Value)
+			if err != nil {
+				return nil, err
+			}
+			op.Value, err = encodeValue(mapRefs(v, rename))
+			if err != nil {
+				return nil, err
+			}
+		}
+		res[i] = op
+	}
+	return res, nil
+}
+
+// isApplied returns true if the document already reflects the operation.
+func (d *Document) isApplied(op PatchOp) bool {
+	ref, err := ParseRef(op.Node)
+	if err != nil {
+		return false
+	}
+	n := d.Resolve(ref)
+	if op.Op == PatchDelete {
+		return n == nil
+	}
+	if op.Op == PatchRemove {
+		return n != nil && !pathExists(n, op.Path)
+	}
+	if n == nil || op.Value == nil {
+		return false
+	}
+	value, err := decodeValue(op.Value)
+	if err != nil {
+		return false
+	}
+	switch op.Op {
+	case PatchAdd:
+		return reflect.DeepEqual(value, nodeValues(n))
+	case PatchSet, PatchSetRef:
+		field, segs, err := parsePath(op.Path)
+		if err != nil {
+			return false
+		}
+		root, err := n.Value(field)
+		if err != nil {
+			return false
+		}
+		current, err := getPath(root, segs)
+		return err == nil && reflect.DeepEqual(value, current)
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package grison
+
+import (
+	"testing"
+)
+
+func checkMerge(t *testing.T, base, ours, theirs, expected string, conflicts ...string) {
+	doc, cs, err := Merge(mustParse(t, base), mustParse(t, ours), mustParse(t, theirs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := doc.Marshal(MarshalOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != expected {
+		t.Errorf("unexpected result.\nexpect=%s\nactual=%s", expected, b)
+	}
+	if len(cs) != len(conflicts) {
+		t.Errorf("unexpected conflicts %v", cs)
+		return
+	}
+	for i, c := range cs {
+		if c.Error() != conflicts[i] {
+			t.Errorf("unexpected conflict %q, expected %q", c.Error(), conflicts[i])
+		}
+	}
+}
+
+func TestMergeFields(t *testing.T) {
+	checkMerge(t,
+		`{"P":{"#1":{"A":1,"B":1,"C":1,"M":{"x":1,"y":1}}}}`,
+		`{"P":{"#1":{"A":2,"B":1,"C":2,"M":{"x":2,"y":1}}}}`,
+		`{"P":{"#1":{"A":1,"B":2,"C":2,"M":{"x":1,"y":2}}}}`,
+		`{"P":{"#1":{"A":2,"B":2,"C":2,"M":{"x":2,"y":2}}}}`,
+	)
+}
+
+func TestMergeConflicts(t *testing.T) {
+	checkMerge(t,
+		`{"P":{"#1":{"A":1},"#2":{"A":1},"#3":{"A":1},"#4":{}}}`,
+		`{"P":{"#1":{"A":2},"#2":{"A":2},"#4":{}}}`,
+		`{"P":{"#1":{"A":3},"#3":{"A":3,"R":{"$ref":"P:#4"}},"#4":{}}}`,
+		`{"P":{"#1":{"A":2},"#2":{"A":2},"#4":{}}}`,
+		`set P:#1 A: expected 1, found 2`,
+		`set P:#3 A: node doesn't exist`,
+		`setref P:#3 R: node doesn't exist`,
+		`delete P:#2: node was modified`,
+	)
+}
+
+func TestMergeAdded(t *testing.T) {
+	// Both sides add a node with the same automatic ID and append it
+	// to the same slice.
+	checkMerge(t,
+		`{"P":{"#1":{"L":[]}}}`,
+		`{"P":{"#1":{"L":[{"$ref":"P:#2"}]},"#2":{"Name":"ours"}}}`,
+		`{"P":{"#1":{"L":[{"$ref":"P:#2"}]},"#2":{"Name":"theirs"}}}`,
+		`{"P":{"#1":{"L":[{"$ref":"P:#2"},{"$ref":"P:#3"}]},"#2":{"Name":"ours"},"#3":{"Name":"theirs"}}}`,
+	)
+}
+
+func TestMergeDangling(t *testing.T) {
+	checkMerge(t,
+		`{"P":{"a":{},"b":{}}}`,
+		`{"P":{"a":{"R":{"$ref":"P:b"}},"b":{}}}`,
+		`{"P":{"a":{}}}`,
+		`{"P":{"a":{"R":{"$ref":"P:b"}}}}`,
+		`setref P:a R: reference to deleted node P:b`,
+	)
+}
+
+func TestMergeRemovedKeys(t *testing.T) {
+	checkMerge(t,
+		`{"P":{"#1":{"M":{"a.b":1,"c":1,"d":1}}}}`,
+		`{"P":{"#1":{"M":{"c":1,"d":1}}}}`,
+		`{"P":{"#1":{"M":{"a.b":1,"d":2}}}}`,
+		`{"P":{"#1":{"M":{"d":2}}}}`,
+	)
+}
@@ -0,0 +1,392 @@
+/*
+	Copyright (c) 2020 Martin Sustrik
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy
+	of this software and associated documentation files (the "Software"),
+	to deal in the Software without restriction, including without limitation
+	the rights to use, copy, modify, merge, publish, distribute, sublicense,
+	and/or sell copies of the Software, and to permit persons to whom
+	the Software is furnished to do so, subject to the following conditions:
+	The above copyright notice and this permission notice shall be included
+	in all copies or substantial portions of the Software.
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+	FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+	IN THE SOFTWARE.
+*/
+
+package grison
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+// MsgPackRefExt is the MessagePack extension type used for references.
+// The payload of the extension is the reference string, e.g. "Parents:#1".
+const MsgPackRefExt = 1
+
+// MarshalMsgPack converts the graph reachable from the master structure into
+// MessagePack. The layout is the same as that of the JSON format, i.e. a map
+// of collections, each being a map of nodes. References are represented by
+// the MsgPackRefExt extension type.
+func MarshalMsgPack(m interface{}, opts MarshalOpts) ([]byte, error) {
+	doc, err := MarshalDocument(m, opts)
+	if err != nil {
+		return nil, err
+	}
+	return doc.MarshalMsgPack()
+}
+
+// UnmarshalMsgPack fills in the master structure from MessagePack produced by MarshalMsgPack.
+func UnmarshalMsgPack(b []byte, m interface{}) error {
+	doc, err := ParseMsgPackDocument(b)
+	if err != nil {
+		return err
+	}
+	return UnmarshalDocument(doc, m, UnmarshalOpts{})
+}
+
+// MarshalMsgPack converts the document into MessagePack. See MarshalMsgPack
+// function for details.
+func (d *Document) MarshalMsgPack() ([]byte, error) {
+	var enc msgpackEncoder
+	tps := d.Types()
+	enc.writeLen(0x80, 0xde, uint64(len(tps)))
+	for _, tp := range tps {
+		enc.writeString(tp)
+		nodes := d.Nodes(tp)
+		enc.writeLen(0x80, 0xde, uint64(len(nodes)))
+		for _, n := range nodes {
+			enc.writeString(n.ID())
+			names := n.FieldNames()
+			enc.writeLen(0x80, 0xde, uint64(len(names)))
+			for _, name := range names {
+				v, err := n.Value(name)
+				if err != nil {
+					return nil, err
+				}
+				enc.writeString(name)
+				err = enc.writeValue(v)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return enc.buf.Bytes(), nil
+}
+
+// ParseMsgPackDocument parses MessagePack produced by MarshalMsgPack into a document.
+func ParseMsgPackDocument(b []byte) (*Document, error) {
+	dec := &msgpackDecoder{b: b}
+	v, err := dec.readValue()
+	if err != nil {
+		return nil, err
+	}
+	if dec.pos != len(b) {
+		return nil, fmt.Errorf("unexpected data after the end of MessagePack document")
+	}
+	return documentFromShared(v)
+}
+
+type msgpackEncoder struct {
+	buf bytes.Buffer
+}
+
+// writeLen writes the header of a string, array or map. Fix is the
+// first byte of the fixed-size variant, var16 that of the 16-bit one.
+func (enc *msgpackEncoder) writeLen(fix byte, var16 byte, l uint64) {
+	switch {
+	case fix == 0xa0 && l < 32 || fix != 0xa0 && l < 16:
+		enc.buf.WriteByte(fix | byte(l))
+	case fix == 0xa0 && l <= math.MaxUint8:
+		enc.buf.Write([]byte{0xd9, byte(l)})
+	case l <= math.MaxUint16:
+		enc.buf.WriteByte(var16)
+		binary.Write(&enc.buf, binary.BigEndian, uint16(l))
+	default:
+		enc.buf.WriteByte(var16 + 1)
+		binary.Write(&enc.buf, binary.BigEndian, uint32(l))
+	}
+}
+
+func (enc *msgpackEncoder) writeString(s string) {
+	enc.writeLen(0xa0, 0xda, uint64(len(s)))
+	enc.buf.WriteString(s)
+}
+
+func (enc *msgpackEncoder) writeValue(v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		enc.buf.WriteByte(0xc0)
+	case bool:
+		if v {
+			enc.buf.WriteByte(0xc3)
+		} else {
+			enc.buf.WriteByte(0xc2)
+		}
+	case string:
+		enc.writeString(v)
+	case json.Number:
+		enc.writeNumber(v)
+	case []interface{}:
+		enc.writeLen(0x90, 0xdc, uint64(len(v)))
+		for _, item := range v {
+			err := enc.writeValue(item)
+			if err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		enc.writeLen(0x80, 0xde, uint64(len(keys)))
+		for _, k := range keys {
+			enc.writeString(k)
+			err := enc.writeValue(v[k])
+			if err != nil {
+				return err
+			}
+		}
+	case Ref:
+		s := v.String()
+		switch l := len(s); {
+		case l == 1 || l == 2 || l == 4 || l == 8 || l == 16:
+			enc.buf.WriteByte(map[int]byte{1: 0xd4, 2: 0xd5, 4: 0xd6, 8: 0xd7, 16: 0xd8}[l])
+		case l <= math.MaxUint8:
+			enc.buf.Write([]byte{0xc7, byte(l)})
+		case l <= math.MaxUint16:
+			enc.buf.WriteByte(0xc8)
+			binary.Write(&enc.buf, binary.BigEndian, uint16(l))
+		default:
+			enc.buf.WriteByte(0xc9)
+			binary.Write(&enc.buf, binary.BigEndian, uint32(l))
+		}
+		enc.buf.WriteByte(MsgPackRefExt)
+		enc.buf.WriteString(s)
+	default:
+		return fmt.Errorf("unexpected value %v", v)
+	}
+	return nil
+}
+
+func (enc *msgpackEncoder) writeNumber(n json.Number) {
+	num := parseNumber(n)
+	switch {
+	case num.kind == uintNumber && num.u < 128:
+		enc.buf.WriteByte(byte(num.u))
+	case num.kind == uintNumber && num.u <= math.MaxUint8:
+		enc.buf.Write([]byte{0xcc, byte(num.u)})
+	case num.kind == uintNumber && num.u <= math.MaxUint16:
+		enc.buf.WriteByte(0xcd)
+		binary.Write(&enc.buf, binary.BigEndian, uint16(num.u))
+	case num.kind == uintNumber && num.u <= math.MaxUint32:
+		enc.buf.WriteByte(0xce)
+		binary.Write(&enc.buf, binary.BigEndian, uint32(num.u))
+	case num.kind == uintNumber:
+		enc.buf.WriteByte(0xcf)
+		binary.Write(&enc.buf, binary.BigEndian, num.u)
+	case num.kind == intNumber && num.i >= -32:
+		enc.buf.WriteByte(byte(int8(num.i)))
+	case num.kind == intNumber && num.i >= math.MinInt8:
+		enc.buf.Write([]byte{0xd0, byte(int8(num.i))})
+	case num.kind == intNumber && num.i >= math.MinInt16:
+		enc.buf.WriteByte(0xd1)
+		binary.Write(&enc.buf, binary.BigEndian, int16(num.i))
+	case num.kind == intNumber && num.i >= math.MinInt32:
+		enc.buf.WriteByte(0xd2)
+		binary.Write(&enc.buf, binary.BigEndian, int32(num.i))
+	case num.kind == intNumber:
+		enc.buf.WriteByte(0xd3)
+		binary.Write(&enc.buf, binary.BigEndian, num.i)
+	case num.kind == float32Number:
+		enc.buf.WriteByte(0xca)
+		binary.Write(&enc.buf, binary.BigEndian, math.Float32bits(float32(num.f)))
+	default:
+		enc.buf.WriteByte(0xcb)
+		binary.Write(&enc.buf, binary.BigEndian, math.Float64bits(num.f))
+	}
+}
+
+type msgpackDecoder struct {
+	b   []byte
+	pos int
+}
+
+// read returns next n bytes of the input.
+func (dec *msgpackDecoder) read(n uint64) ([]byte, error) {
+	if n > uint64(len(dec.b)-dec.pos) {
+		return nil, fmt.Errorf("unexpected end of MessagePack data")
+	}
+	b := dec.b[dec.pos : dec.pos+int(n)]
+	dec.pos += int(n)
+	return b, nil
+}
+
+// readUint reads a big-endian unsigned integer of the specified size.
+func (dec *msgpackDecoder) readUint(size uint64) (uint64, error) {
+	b, err := dec.read(size)
+	if err != nil {
+		return 0, err
+	}
+	var u uint64
+	for _, c := range b {
+		u = u<<8 | uint64(c)
+	}
+	return u, nil
+}
+
+func (dec *msgpackDecoder) readValue() (interface{}, error) {
+	b, err := dec.read(1)
+	if err != nil {
+		return nil, err
+	}
+	c := b[0]
+	switch {
+	case c <= 0x7f:
+		return json.Number(strconv.Itoa(int(c))), nil
+	case c >= 0xe0:
+		return json.Number(strconv.Itoa(int(int8(c)))), nil
+	case c >= 0x80 && c <= 0x8f:
+		return dec.readMap(uint64(c & 0x0f))
+	case c >= 0x90 && c <= 0x9f:
+		return dec.readArray(uint64(c & 0x0f))
+	case c >= 0xa0 && c <= 0xbf:
+		return dec.readString(uint64(c & 0x1f))
+	}
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6, 0xd9, 0xda, 0xdb:
+		// Binary data is not produced by the encoder, treat it as a string.
+		size := map[byte]uint64{0xc4: 1, 0xc5: 2, 0xc6: 4, 0xd9: 1, 0xda: 2, 0xdb: 4}[c]
+		l, err := dec.readUint(size)
+		if err != nil {
+			return nil, err
+		}
+		return dec.readString(l)
+	case 0xc7, 0xc8, 0xc9:
+		l, err := dec.readUint(map[byte]uint64{0xc7: 1, 0xc8: 2, 0xc9: 4}[c])
+		if err != nil {
+			return nil, err
+		}
+		return dec.readExt(l)
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return dec.readExt(map[byte]uint64{0xd4: 1, 0xd5: 2, 0xd6: 4, 0xd7: 8, 0xd8: 16}[c])
+	case 0xca:
+		u, err := dec.readUint(4)
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(floatText(float64(math.Float32frombits(uint32(u))), 32)), nil
+	case 0xcb:
+		u, err := dec.readUint(8)
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(floatText(math.Float64frombits(u), 64)), nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		u, err := dec.readUint(map[byte]uint64{0xcc: 1, 0xcd: 2, 0xce: 4, 0xcf: 8}[c])
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(strconv.FormatUint(u, 10)), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := map[byte]uint64{0xd0: 1, 0xd1: 2, 0xd2: 4, 0xd3: 8}[c]
+		u, err := dec.readUint(size)
+		if err != nil {
+			return nil, err
+		}
+		// Sign-extend the value.
+		shift := 64 - 8*size
+		return json.Number(strconv.FormatInt(int64(u<<shift)>>shift, 10)), nil
+	case 0xdc, 0xdd:
+		l, err := dec.readUint(map[byte]uint64{0xdc: 2, 0xdd: 4}[c])
+		if err != nil {
+			return nil, err
+		}
+		return dec.readArray(l)
+	case 0xde, 0xdf:
+		l, err := dec.readUint(map[byte]uint64{0xde: 2, 0xdf: 4}[c])
+		if err != nil {
+			return nil, err
+		}
+		return dec.readMap(l)
+	}
+	return nil, fmt.Errorf("invalid MessagePack format byte 0x%02x", c)
+}
+
+func (dec *msgpackDecoder) readString(l uint64) (interface{}, error) {
+	b, err := dec.read(l)
+	if err != nil {
+		return nil, err
+	}
+	if !utf8.Valid(b) {
+		return nil, fmt.Errorf("invalid UTF-8 in MessagePack string")
+	}
+	return string(b), nil
+}
+
+func (dec *msgpackDecoder) readArray(l uint64) (interface{}, error) {
+	s := []interface{}{}
+	for i := uint64(0); i < l; i++ {
+		item, err := dec.readValue()
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, item)
+	}
+	return s, nil
+}
+
+func (dec *msgpackDecoder) readMap(l uint64) (interface{}, error) {
+	m := make(map[string]interface{})
+	for i := uint64(0); i < l; i++ {
+		k, err := dec.readValue()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("MessagePack map keys must be strings")
+		}
+		v, err := dec.readValue()
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	return m, nil
+}
+
+func (dec *msgpackDecoder) readExt(l uint64) (interface{}, error) {
+	tp, err := dec.read(1)
+	if err != nil {
+		return nil, err
+	}
+	data, err := dec.read(l)
+	if err != nil {
+		return nil, err
+	}
+	if int8(tp[0]) != MsgPackRefExt {
+		return nil, fmt.Errorf("unsupported MessagePack extension type %d", int8(tp[0]))
+	}
+	return ParseRef(string(data))
+}
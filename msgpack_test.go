@@ -0,0 +1,109 @@
+package grison
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+func TestMsgPackMinimal(t *testing.T) {
+	type Node struct {
+		A int
+		N *Node
+	}
+	type Master struct {
+		Node []*Node
+	}
+	m := &Master{Node: []*Node{{A: 2}}}
+	m.Node[0].N = m.Node[0]
+	b, err := MarshalMsgPack(m, MarshalOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// {"Node": {"#1": {"A": 2, "N": ext(1, "Node:#1")}}}
+	expected := "81a44e6f646581a2233182a14102a14ec707014e6f64653a2331"
+	if hex.EncodeToString(b) != expected {
+		t.Errorf("unexpected MessagePack.\nexpect=%s\nactual=%s", expected, hex.EncodeToString(b))
+	}
+}
+
+func TestMsgPackJSONRoundTrip(t *testing.T) {
+	docs := []string{
+		exampleDoc,
+		`{"Node":{"#1":{"A":-42,"B":42,"C":1.1,"D":true,"E":"foo","F":"AQID","G":[4,5,6],"H":null}}}`,
+		`{"Node":{"#1":{"A":0.1,"B":1e+21,"C":-0,"D":18446744073709551615,"E":-9223372036854775808,"F":3.141592653589793}}}`,
+		`{"Node":{"#1":{"A":{"x":[[],{}],"y":{"$ref":"Node:#1"}}}}}`,
+	}
+	for _, s := range docs {
+		doc, err := ParseDocument([]byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := doc.MarshalMsgPack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc2, err := ParseMsgPackDocument(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		j, err := doc2.Marshal(MarshalOpts{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(j) != s {
+			t.Errorf("unexpected round trip result.\nexpect=%s\nactual=%s", s, string(j))
+		}
+	}
+}
+
+func TestMsgPackDecode(t *testing.T) {
+	// str8, bin8, int16 and float32.
+	b, _ := hex.DecodeString("81a14e81a2233184a141d903616263a142c40178a143d1ff00a144ca3fc00000")
+	doc, err := ParseMsgPackDocument(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := doc.Marshal(MarshalOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"N":{"#1":{"A":"abc","B":"x","C":-256,"D":1.5}}}`
+	if string(j) != expected {
+		t.Errorf("unexpected document.\nexpect=%s\nactual=%s", expected, string(j))
+	}
+	// References use fixext4 when the reference string is four bytes long.
+	b, _ = hex.DecodeString("81a14e81a2233181a141d6014e3a2331")
+	_, err = ParseMsgPackDocument(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ = hex.DecodeString("81a14e81a2233181a141d6024e3a2331")
+	_, err = ParseMsgPackDocument(b)
+	if err == nil {
+		t.Errorf("unknown extension type accepted")
+	}
+}
+
+func TestFormatOption(t *testing.T) {
+	formats := []Format{FormatJSON, FormatYAML, FormatCBOR, FormatMsgPack}
+	for _, f := range formats {
+		m := newGraphMaster()
+		b, err := MarshalWithOpts(m, MarshalOpts{Format: f})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m2 graphMaster
+		err = UnmarshalWithOpts(b, &m2, UnmarshalOpts{Format: f})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(m, &m2) {
+			t.Errorf("unexpected unmarshal result for format %d", f)
+		}
+	}
+	_, err := MarshalWithOpts(newGraphMaster(), MarshalOpts{Format: Format(42)})
+	if err == nil {
+		t.Errorf("unknown format accepted")
+	}
+}
@@ -0,0 +1,159 @@
+/*
+	Copyright (c) 2020 Martin Sustrik
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy
+	of this software and associated documentation files (the "Software"),
+	to deal in the Software without restriction, including without limitation
+	the rights to use, copy, modify, merge, publish, distribute, sublicense,
+	and/or sell copies of the Software, and to permit persons to whom
+	the Software is furnished to do so, subject to the following conditions:
+	The above copyright notice and this permission notice shall be included
+	in all copies or substantial portions of the Software.
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+	FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+	IN THE SOFTWARE.
+*/
+
+package grison
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+const (
+	xsdNamespace = "http://www.w3.org/2001/XMLSchema#"
+	rdfType      = "http://www.w3.org/1999/02/22-rdf-syntax-ns#type"
+	rdfJSON      = "http://www.w3.org/1999/02/22-rdf-syntax-ns#JSON"
+)
+
+// MarshalNTriples converts the graph reachable from the master structure into
+// RDF N-Triples. Each node is identified by an IRI consisting of BaseIRI option
+// followed by "Type/ID", e.g. "http://example.org/Parents/%231". The node
+// type is stated using rdf:type. Scalar fields become literals with XSD
+// datatypes derived from Go kinds, references become triples with the IRI of
+// the referenced node as the object. There's a triple for each non-nil
+// element of slices and maps of references. Other fields are stored as
+// rdf:JSON literals. IRIs of the types and the properties are taken from
+// jsonld struct tags (see FormatJSONLD). If not specified, they are derived
+// from the base IRI, e.g. "http://example.org/Parents" for the type and
+// "http://example.org/Parents#Name" for the property.
+func MarshalNTriples(m interface{}, opts MarshalOpts) ([]byte, error) {
+	if opts.BaseIRI == "" {
+		return nil, fmt.Errorf("N-Triples require BaseIRI option")
+	}
+	w, err := newWalker(m, opts)
+	if err != nil {
+		return nil, err
+	}
+	typeIRIs := jsonldTypeIRIs(m)
+	var buf bytes.Buffer
+	for _, wn := range w.nodes {
+		subj := opts.BaseIRI + nodeIRI(wn.ref)
+		tpIRI, ok := typeIRIs[wn.ref.Type]
+		if !ok {
+			tpIRI = opts.BaseIRI + url.PathEscape(wn.ref.Type)
+		}
+		fmt.Fprintf(&buf, "<%s> <%s> <%s> .\n", subj, rdfType, tpIRI)
+		for _, f := range w.fields(wn) {
+			pred := opts.BaseIRI + url.PathEscape(wn.ref.Type) + "#" + url.PathEscape(f.name)
+			if iri := wn.val.Type().Field(f.index).Tag.Get("jsonld"); iri != "" {
+				pred = iri
+			}
+			addRef := func(ref Ref) {
+				fmt.Fprintf(&buf, "<%s> <%s> <%s> .\n", subj, pred, opts.BaseIRI+nodeIRI(ref))
+			}
+			fv := wn.val.Field(f.index)
+			switch f.kind {
+			case scalarField:
+				lit, err := ntriplesLiteral(fv)
+				if err != nil {
+					return nil, err
+				}
+				fmt.Fprintf(&buf, "<%s> <%s> %s .\n", subj, pred, lit)
+			case refField:
+				if ref, ok := w.ref(fv); ok {
+					addRef(ref)
+				}
+			case refListField:
+				for i := 0; i < fv.Len(); i++ {
+					if ref, ok := w.ref(fv.Index(i)); ok {
+						addRef(ref)
+					}
+				}
+			case refMapField:
+				for _, k := range sortedMapKeys(fv) {
+					if ref, ok := w.ref(fv.MapIndex(k)); ok {
+						addRef(ref)
+					}
+				}
+			default:
+				b, err := w.marshalValue(fv)
+				if err != nil {
+					return nil, err
+				}
+				fmt.Fprintf(&buf, "<%s> <%s> %s^^<%s> .\n", subj, pred, ntriplesQuote(string(b)), rdfJSON)
+				// References nested in the value are stated as well.
+				v, err := decodeValue(b)
+				if err != nil {
+					return nil, err
+				}
+				walkRefs(v, f.name, func(path string, ref Ref) {
+					addRef(ref)
+				})
+			}
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// ntriplesLiteral returns the literal for a scalar value. Strings are simple
+// literals, which are of xsd:string type.
+func ntriplesLiteral(v reflect.Value) (string, error) {
+	s, err := scalarText(v)
+	if err != nil {
+		return "", err
+	}
+	var tp string
+	switch v.Kind() {
+	case reflect.String:
+		return ntriplesQuote(s), nil
+	case reflect.Bool:
+		tp = "boolean"
+	case reflect.Int8:
+		tp = "byte"
+	case reflect.Int16:
+		tp = "short"
+	case reflect.Int32:
+		tp = "int"
+	case reflect.Int, reflect.Int64:
+		tp = "long"
+	case reflect.Uint8:
+		tp = "unsignedByte"
+	case reflect.Uint16:
+		tp = "unsignedShort"
+	case reflect.Uint32:
+		tp = "unsignedInt"
+	case reflect.Uint, reflect.Uint64:
+		tp = "unsignedLong"
+	case reflect.Float32:
+		tp = "float"
+	default:
+		tp = "double"
+	}
+	return fmt.Sprintf("%s^^<%s%s>", ntriplesQuote(s), xsdNamespace, tp), nil
+}
+
+var ntriplesReplacer = strings.NewReplacer(
+	`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`, "\b", `\b`, "\f", `\f`)
+
+func ntriplesQuote(s string) string {
+	return `"` + ntriplesReplacer.Replace(s) + `"`
+}
@@ -0,0 +1,52 @@
+package grison
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNTriples(t *testing.T) {
+	b, err := MarshalNTriples(newLDMaster(), MarshalOpts{BaseIRI: "http://example.org/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `<http://example.org/People/%231> <http://www.w3.org/1999/02/22-rdf-syntax-ns#type> <http://schema.org/Person> .
+<http://example.org/People/%231> <http://schema.org/name> "Alice" .
+<http://example.org/People/%231> <http://schema.org/spouse> <http://example.org/People/%232> .
+<http://example.org/People/%231> <http://example.org/People#Age> "30"^^<http://www.w3.org/2001/XMLSchema#long> .
+<http://example.org/People/%232> <http://www.w3.org/1999/02/22-rdf-syntax-ns#type> <http://schema.org/Person> .
+<http://example.org/People/%232> <http://schema.org/name> "Bob" .
+<http://example.org/People/%232> <http://schema.org/spouse> <http://example.org/People/%231> .
+<http://example.org/People/%232> <http://schema.org/knows> <http://example.org/People/%231> .
+<http://example.org/People/%232> <http://example.org/People#Age> "0"^^<http://www.w3.org/2001/XMLSchema#long> .
+`
+	if string(b) != expected {
+		t.Errorf("unexpected N-Triples.\nexpect=%s\nactual=%s", expected, b)
+	}
+	_, err = MarshalNTriples(newLDMaster(), MarshalOpts{})
+	if err == nil {
+		t.Errorf("missing base IRI accepted")
+	}
+}
+
+func TestNTriplesLiterals(t *testing.T) {
+	b, err := MarshalNTriples(newGraphMaster(), MarshalOpts{BaseIRI: "http://example.org/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		`<http://example.org/Nodes/%231> <http://example.org/Nodes#Name> "a <&> b" .`,
+		`<http://example.org/Nodes/%231> <http://example.org/Nodes#Weight> "1.5"^^<http://www.w3.org/2001/XMLSchema#double> .`,
+		`<http://example.org/Nodes/%231> <http://example.org/Nodes#Alive> "true"^^<http://www.w3.org/2001/XMLSchema#boolean> .`,
+		`<http://example.org/Nodes/%231> <http://example.org/Nodes#Tags> "[\"x\",\"y\"]"^^<http://www.w3.org/1999/02/22-rdf-syntax-ns#JSON> .`,
+		`<http://example.org/Nodes/%232> <http://example.org/Nodes#Map> <http://example.org/Nodes/%231> .`,
+		`<http://example.org/Nodes/%233> <http://example.org/Nodes#Nested> <http://example.org/Nodes/%232> .`,
+	} {
+		if !strings.Contains(string(b), s) {
+			t.Errorf("missing %s in:\n%s", s, b)
+		}
+	}
+	if ntriplesQuote("a\"\\\n") != `"a\"\\\n"` {
+		t.Errorf("unexpected quoting %s", ntriplesQuote("a\"\\\n"))
+	}
+}
@@ -0,0 +1,81 @@
+/*
+	Copyright (c) 2020 Martin Sustrik
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy
+	of this software and associated documentation files (the "Software"),
+	to deal in the Software without restriction, including without limitation
+	the rights to use, copy, modify, merge, publish, distribute, sublicense,
+	and/or sell copies of the Software, and to permit persons to whom
+	the Software is furnished to do so, subject to the following conditions:
+	The above copyright notice and this permission notice shall be included
+	in all copies or substantial portions of the Software.
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+	FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+	IN THE SOFTWARE.
+*/
+
+package grison
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// Binary formats distinguish between integers and floats of various sizes.
+// To convert back to the same JSON text, numbers are encoded in the smallest
+// form that is formatted back to the original text.
+
+type numberKind int
+
+const (
+	uintNumber numberKind = iota
+	intNumber
+	float32Number
+	float64Number
+)
+
+type number struct {
+	kind numberKind
+	u    uint64
+	i    int64
+	f    float64
+}
+
+func parseNumber(n json.Number) number {
+	s := n.String()
+	if !strings.ContainsAny(s, ".eE") {
+		if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return number{kind: uintNumber, u: u}
+		}
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil && s != "-0" {
+			return number{kind: intNumber, i: i}
+		}
+	}
+	f32, err := strconv.ParseFloat(s, 32)
+	if err == nil && floatText(f32, 32) == s {
+		return number{kind: float32Number, f: f32}
+	}
+	f, _ := strconv.ParseFloat(s, 64)
+	return number{kind: float64Number, f: f}
+}
+
+// floatText formats the float the same way encoding/json does.
+func floatText(f float64, bits int) string {
+	var b []byte
+	var err error
+	if bits == 32 {
+		b, err = json.Marshal(float32(f))
+	} else {
+		b, err = json.Marshal(f)
+	}
+	if err != nil {
+		// NaN and infinities can't appear in JSON, keep them as they are.
+		return strconv.FormatFloat(f, 'g', -1, bits)
+	}
+	return string(b)
+}
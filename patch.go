@@ -0,0 +1,601 @@
+/*
+	Copyright (c) 2020 Martin Sustrik
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy
+	of this software and associated documentation files (the "Software"),
+	to deal in the Software without restriction, including without limitation
+	the rights to use, copy, modify, merge, publish, distribute, sublicense,
+	and/or sell copies of the Software, and to permit persons to whom
+	the Software is furnished to do so, subject to the following conditions:
+	The above copyright notice and this permission notice shall be included
+	in all copies or substantial portions of the Software.
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+	FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+	IN THE SOFTWARE.
+*/
+
+package grison
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Patch operations.
+const (
+	// Add a node. Value contains the fields of the node.
+	PatchAdd = "add"
+	// Delete a node. Old, if present, contains the expected fields of the node.
+	PatchDelete = "delete"
+	// Set the value at the path.
+	PatchSet = "set"
+	// Set the reference at the path. Value is a reference or null.
+	PatchSetRef = "setref"
+	// Remove the field or the map entry at the path.
+	PatchRemove = "remove"
+	// Append the reference in Value to the slice at the path.
+	PatchAppend = "append"
+)
+
+// PatchOp is a single operation of a patch.
+type PatchOp struct {
+	Op string `json:"op"`
+	// The node in "Type:ID" format.
+	Node string `json:"node"`
+	// Path of the value within the node, e.g. "Children[1]" or "Map.key".
+	// A backslash escapes '.', '[', ']' and backslash in field names and map keys.
+	Path string `json:"path,omitempty"`
+	// The value expected to be found in the base. If it doesn't match, the
+	// operation is in conflict. If omitted, the value is not checked.
+	Old json.RawMessage `json:"old,omitempty"`
+	// The new value, in grison JSON format.
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Patch is a list of operations transforming one graph into another.
+// It's serialized as a JSON array of operations.
+type Patch []PatchOp
+
+// Conflict is a patch operation that can't be applied to the base.
+type Conflict struct {
+	Op  PatchOp
+	Msg string
+}
+
+func (c Conflict) Error() string {
+	if c.Op.Path == "" {
+		return fmt.Sprintf("%s %s: %s", c.Op.Op, c.Op.Node, c.Msg)
+	}
+	return fmt.Sprintf("%s %s %s: %s", c.Op.Op, c.Op.Node, c.Op.Path, c.Msg)
+}
+
+// ConflictError is returned when some operations of a patch are in conflict.
+type ConflictError struct {
+	Conflicts []Conflict
+}
+
+func (e *ConflictError) Error() string {
+	if len(e.Conflicts) == 1 {
+		return e.Conflicts[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more conflicts)", e.Conflicts[0].Error(), len(e.Conflicts)-1)
+}
+
+// MakePatch returns a patch transforming graph a into graph b. Each of the
+// arguments is either a document or a master structure. Nodes are matched
+// the same way as in Diff. The patch refers to the nodes by their IDs in a.
+// Added nodes keep their IDs from b unless they clash with IDs in a, in which
+// case new automatic IDs are assigned. References appended to the end of
+// a slice are expressed as append operations so that patches appending to
+// the same slice don't conflict.
+func MakePatch(a, b interface{}) (Patch, error) {
+	da, err := toDocument(a)
+	if err != nil {
+		return nil, err
+	}
+	db, err := toDocument(b)
+	if err != nil {
+		return nil, err
+	}
+	changes, mt := diffDocuments(da, db)
+	// IDs of the added nodes.
+	added := make(map[Ref]Ref)
+	next := lastAutoID(da)
+	for _, c := range changes {
+		if c.Kind != NodeAdded {
+			continue
+		}
+		ref := c.B
+		if da.Resolve(ref) != nil {
+			next++
+			ref.ID = fmt.Sprintf("#%d", next)
+		}
+		added[c.B] = ref
+	}
+	translate := func(ref Ref) Ref {
+		if m, ok := mt.ba[ref]; ok {
+			return m
+		}
+		if m, ok := added[ref]; ok {
+			return m
+		}
+		return ref
+	}
+	var adds, sets, deletes Patch
+	for _, c := range changes {
+		switch c.Kind {
+		case NodeAdded:
+			op, err := newPatchOp(PatchAdd, added[c.B], "", nil, mapRefs(c.After, translate))
+			if err != nil {
+				return nil, err
+			}
+			adds = append(adds, op)
+		case NodeRemoved:
+			op, err := newPatchOp(PatchDelete, c.A, "", c.Before, nil)
+			if err != nil {
+				return nil, err
+			}
+			deletes = append(deletes, op)
+		default:
+			after := mapRefs(c.After, translate)
+			if c.After == nil && !pathExists(db.Resolve(c.B), c.Path) {
+				op, err := newPatchOp(PatchRemove, c.A, c.Path, c.Before, nil)
+				if err != nil {
+					return nil, err
+				}
+				sets = append(sets, op)
+				continue
+			}
+			if refs, ok := appendedRefs(mt, c.Before, c.After); ok {
+				for _, ref := range refs {
+					op, err := newPatchOp(PatchAppend, c.A, c.Path, nil, translate(ref))
+					if err != nil {
+						return nil, err
+					}
+					sets = append(sets, op)
+				}
+				continue
+			}
+			kind := PatchSet
+			if c.Kind == RefChanged {
+				kind = PatchSetRef
+			}
+			op, err := newPatchOp(kind, c.A, c.Path, c.Before, after)
+			if err != nil {
+				return nil, err
+			}
+			sets = append(sets, op)
+		}
+	}
+	p := append(adds, sets...)
+	return append(p, deletes...), nil
+}
+
+func newPatchOp(kind string, ref Ref, path string, old interface{}, value interface{}) (PatchOp, error) {
+	op := PatchOp{Op: kind, Node: ref.String(), Path: path}
+	var err error
+	if kind != PatchAdd && kind != PatchAppend {
+		op.Old, err = encodeValue(old)
+		if err != nil {
+			return PatchOp{}, err
+		}
+	}
+	if kind != PatchDelete && kind != PatchRemove {
+		op.Value, err = encodeValue(value)
+		if err != nil {
+			return PatchOp{}, err
+		}
+	}
+	return op, nil
+}
+
+// lastAutoID returns the highest number used in the automatic IDs of the document.
+func lastAutoID(d *Document) int {
+	last := 0
+	for _, n := range d.AllNodes() {
+		if isAutoID(n.ID()) {
+			i, _ := strconv.Atoi(n.ID()[1:])
+			if i > last {
+				last = i
+			}
+		}
+	}
+	return last
+}
+
+// appendedRefs returns the references appended to slice a to get slice b.
+func appendedRefs(mt *matching, a, b interface{}) ([]Ref, bool) {
+	la, ok := a.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	lb, ok := b.([]interface{})
+	if !ok || len(lb) <= len(la) {
+		return nil, false
+	}
+	for i := range la {
+		if !mt.equal(la[i], lb[i]) {
+			return nil, false
+		}
+	}
+	var refs []Ref
+	for _, item := range lb[len(la):] {
+		ref, ok := item.(Ref)
+		if !ok {
+			return nil, false
+		}
+		refs = append(refs, ref)
+	}
+	return refs, true
+}
+
+// mapRefs returns a copy of the value with references replaced by the result of the function.
+func mapRefs(v interface{}, f func(Ref) Ref) interface{} {
+	switch v := v.(type) {
+	case Ref:
+		return f(v)
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, item := range v {
+			l[i] = mapRefs(item, f)
+		}
+		return l
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			m[k] = mapRefs(item, f)
+		}
+		return m
+	}
+	return v
+}
+
+// ApplyPatch applies the patch to the target, which is either a document
+// or a master structure. If any of the operations is in conflict with the
+// target, the target is left unchanged and ConflictError is returned. When
+// applied to a master structure, the nodes are identified the same way as
+// in Diff. The nodes that are kept are updated in place, so that pointers
+// to them remain valid, but the whole master structure is rebuilt, i.e. the
+// cost is proportional to the size of the graph rather than of the patch.
+func ApplyPatch(target interface{}, p Patch) error {
+	doc, err := toDocument(target)
+	if err != nil {
+		return err
+	}
+	work, err := copyDocument(doc)
+	if err != nil {
+		return err
+	}
+	conflicts := work.applyPatch(p)
+	if len(conflicts) > 0 {
+		return &ConflictError{Conflicts: conflicts}
+	}
+	if _, ok := target.(*Document); !ok {
+		return unmarshalInPlace(work, target)
+	}
+	doc.nodes = work.nodes
+	doc.backrefs = nil
+	for _, n := range doc.AllNodes() {
+		n.doc = doc
+	}
+	return nil
+}
+
+// unmarshalInPlace fills in the master structure from the document. Nodes
+// present in both are filled in rather than replaced by new ones.
+func unmarshalInPlace(doc *Document, m interface{}) error {
+	_, _, _, err := scrapeMasterStruct(m, true)
+	w, err := newWalker(m, MarshalOpts{GetIDs: err == nil})
+	if err != nil {
+		return err
+	}
+	dec, err := newDecoder(m)
+	if err != nil {
+		return err
+	}
+	dec.reuse = make(map[string]reflect.Value)
+	for _, wn := range w.nodes {
+		dec.reuse[wn.ref.String()] = wn.val.Addr()
+	}
+	b, err := doc.Marshal(MarshalOpts{})
+	if err != nil {
+		return err
+	}
+	return dec.unmarshalJSON(b)
+}
+
+func copyDocument(d *Document) (*Document, error) {
+	b, err := d.Marshal(MarshalOpts{})
+	if err != nil {
+		return nil, err
+	}
+	return ParseDocument(b)
+}
+
+// applyPatch applies the operations that are not in conflict and returns
+// the conflicts.
+func (d *Document) applyPatch(p Patch) []Conflict {
+	var conflicts []Conflict
+	for _, op := range p {
+		err := d.applyOp(op)
+		if err != nil {
+			conflicts = append(conflicts, Conflict{Op: op, Msg: err.Error()})
+		}
+	}
+	return conflicts
+}
+
+func (d *Document) applyOp(op PatchOp) error {
+	ref, err := ParseRef(op.Node)
+	if err != nil {
+		return err
+	}
+	var value interface{}
+	if op.Op != PatchDelete && op.Op != PatchRemove {
+		if op.Value == nil {
+			return fmt.Errorf("missing value")
+		}
+		value, err = decodeValue(op.Value)
+		if err != nil {
+			return err
+		}
+	}
+	n := d.Resolve(ref)
+	switch op.Op {
+	case PatchAdd:
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("value is not an object")
+		}
+		if n != nil {
+			return fmt.Errorf("node already exists")
+		}
+		n, err = d.AddNode(ref.Type, ref.ID)
+		if err != nil {
+			return err
+		}
+		for name, v := range fields {
+			err = n.SetValue(name, v)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case PatchSetRef, PatchAppend:
+		if _, ok := value.(Ref); !ok && (value != nil || op.Op == PatchAppend) {
+			return fmt.Errorf("value is not a reference")
+		}
+	case PatchDelete, PatchSet, PatchRemove:
+	default:
+		return fmt.Errorf("unknown operation")
+	}
+	if n == nil {
+		return fmt.Errorf("node doesn't exist")
+	}
+	if op.Op == PatchDelete {
+		if op.Old != nil {
+			old, err := decodeValue(op.Old)
+			if err != nil {
+				return err
+			}
+			if !reflect.DeepEqual(old, nodeValues(n)) {
+				return fmt.Errorf("node was modified")
+			}
+		}
+		d.RemoveNode(ref.Type, ref.ID)
+		return nil
+	}
+	field, segs, err := parsePath(op.Path)
+	if err != nil {
+		return err
+	}
+	root, err := n.Value(field)
+	if err != nil {
+		return err
+	}
+	current, err := getPath(root, segs)
+	if err != nil {
+		return err
+	}
+	if op.Old != nil {
+		old, err := decodeValue(op.Old)
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(old, current) {
+			return fmt.Errorf("expected %s, found %s", formatValue(old), formatValue(current))
+		}
+	}
+	if op.Op == PatchRemove {
+		if len(segs) == 0 {
+			n.DeleteField(field)
+			return nil
+		}
+		last := segs[len(segs)-1]
+		parent, err := getPath(root, segs[:len(segs)-1])
+		if err != nil {
+			return err
+		}
+		m, ok := parent.(map[string]interface{})
+		if !ok || last.isIndex {
+			return fmt.Errorf("only fields and map entries can be removed")
+		}
+		delete(m, last.key)
+		return n.SetValue(field, root)
+	}
+	if op.Op == PatchAppend {
+		l, ok := current.([]interface{})
+		if !ok && current != nil {
+			return fmt.Errorf("%s is not a slice", formatValue(current))
+		}
+		value = append(l, value)
+	}
+	root, err = setPath(root, segs, value)
+	if err != nil {
+		return err
+	}
+	return n.SetValue(field, root)
+}
+
+// pathSegment is a map key or a slice index in a path.
+type pathSegment struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// pathKey escapes the field name or the map key for use in a path.
+func pathKey(k string) string {
+	if !strings.ContainsAny(k, ".[]\\") {
+		return k
+	}
+	var sb strings.Builder
+	for i := 0; i < len(k); i++ {
+		if strings.IndexByte(".[]\\", k[i]) >= 0 {
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(k[i])
+	}
+	return sb.String()
+}
+
+// parsePath splits a path like "Map.key[1]" into the field name and the
+// segments within the value of the field.
+func parsePath(path string) (string, []pathSegment, error) {
+	malformed := fmt.Errorf("malformed path %q", path)
+	var segs []pathSegment
+	var key strings.Builder
+	// Whether a field name or a map key is being parsed.
+	inKey := true
+	for i := 0; i < len(path); i++ {
+		switch c := path[i]; c {
+		case '\\':
+			if i+1 >= len(path) || !inKey {
+				return "", nil, malformed
+			}
+			i++
+			key.WriteByte(path[i])
+		case '.':
+			if inKey {
+				segs = append(segs, pathSegment{key: key.String()})
+				key.Reset()
+			}
+			inKey = true
+		case '[':
+			if inKey {
+				segs = append(segs, pathSegment{key: key.String()})
+				key.Reset()
+			}
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return "", nil, malformed
+			}
+			idx, err := strconv.Atoi(path[i+1 : i+end])
+			if err != nil || idx < 0 {
+				return "", nil, malformed
+			}
+			segs = append(segs, pathSegment{index: idx, isIndex: true})
+			inKey = false
+			i += end
+		default:
+			if !inKey || c == ']' {
+				return "", nil, malformed
+			}
+			key.WriteByte(c)
+		}
+	}
+	if inKey {
+		segs = append(segs, pathSegment{key: key.String()})
+	}
+	if segs[0].key == "" {
+		return "", nil, malformed
+	}
+	return segs[0].key, segs[1:], nil
+}
+
+// pathExists returns true if the node has a value at the path.
+func pathExists(n *Node, path string) bool {
+	field, segs, err := parsePath(path)
+	if err != nil || n.Field(field) == nil {
+		return false
+	}
+	v, err := n.Value(field)
+	if err != nil {
+		return false
+	}
+	for _, seg := range segs {
+		switch c := v.(type) {
+		case map[string]interface{}:
+			item, ok := c[seg.key]
+			if !ok || seg.isIndex {
+				return false
+			}
+			v = item
+		case []interface{}:
+			if !seg.isIndex || seg.index >= len(c) {
+				return false
+			}
+			v = c[seg.index]
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// getPath returns the value at the path. Missing map keys yield nil.
+func getPath(v interface{}, segs []pathSegment) (interface{}, error) {
+	for _, seg := range segs {
+		switch c := v.(type) {
+		case map[string]interface{}:
+			if seg.isIndex {
+				return nil, fmt.Errorf("index into an object")
+			}
+			v = c[seg.key]
+		case []interface{}:
+			if !seg.isIndex || seg.index >= len(c) {
+				return nil, fmt.Errorf("no element %d", seg.index)
+			}
+			v = c[seg.index]
+		default:
+			return nil, fmt.Errorf("path leads into %s", formatValue(v))
+		}
+	}
+	return v, nil
+}
+
+// setPath sets the value at the path and returns the modified root value.
+func setPath(root interface{}, segs []pathSegment, v interface{}) (interface{}, error) {
+	if len(segs) == 0 {
+		return v, nil
+	}
+	seg := segs[0]
+	switch c := root.(type) {
+	case map[string]interface{}:
+		if !seg.isIndex {
+			item, err := setPath(c[seg.key], segs[1:], v)
+			if err != nil {
+				return nil, err
+			}
+			c[seg.key] = item
+			return c, nil
+		}
+	case []interface{}:
+		if seg.isIndex && seg.index < len(c) {
+			item, err := setPath(c[seg.index], segs[1:], v)
+			if err != nil {
+				return nil, err
+			}
+			c[seg.index] = item
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("path leads into %s", formatValue(root))
+}
@@ -0,0 +1,222 @@
+package grison
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func mustParse(t *testing.T, s string) *Document {
+	doc, err := ParseDocument([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func checkPatchRoundTrip(t *testing.T, a, b string) Patch {
+	p, err := MakePatch(mustParse(t, a), mustParse(t, b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The patch survives serialization.
+	data, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p2 Patch
+	err = json.Unmarshal(data, &p2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := mustParse(t, a)
+	err = ApplyPatch(doc, p2)
+	if err != nil {
+		t.Fatalf("%v\npatch=%s", err, data)
+	}
+	changes, err := Diff(doc, mustParse(t, b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("patched document differs: %v\npatch=%s", changes, data)
+	}
+	return p
+}
+
+func TestPatchRoundTrip(t *testing.T) {
+	checkPatchRoundTrip(t, exampleDoc, exampleDoc)
+	checkPatchRoundTrip(t,
+		`{"P":{"alice":{"Name":"Alice","Spouse":{"$ref":"P:bob"}},"bob":{"Name":"Bob"},"carol":{}}}`,
+		`{"P":{"alice":{"Name":"Alicia","Spouse":{"$ref":"P:dan"}},"bob":{"Name":"Bob"},"dan":{}}}`,
+	)
+	checkPatchRoundTrip(t,
+		`{"N":{"#1":{"X":1,"L":[{"$ref":"N:#3"}],"M":{"a":[1,2]}},"#2":{"X":1,"L":[{"$ref":"N:#4"}]},"#3":{"Y":"a"},"#4":{"Y":"b"}}}`,
+		`{"N":{"#1":{"Y":"b"},"#2":{"X":1,"L":[{"$ref":"N:#1"}]},"#3":{"Y":"a"},"#4":{"X":1,"L":[{"$ref":"N:#3"},{"$ref":"N:#5"}],"M":{"a":[1,3]}},"#5":{"L":[{"$ref":"N:#5"}]}}}`,
+	)
+}
+
+func TestPatchIDs(t *testing.T) {
+	// The added node clashes with an existing ID and gets a new one.
+	p := checkPatchRoundTrip(t,
+		`{"N":{"#1":{"Name":"a","Next":{"$ref":"N:#2"}},"#2":{"Name":"b"}}}`,
+		`{"N":{"#1":{"Name":"new","Next":{"$ref":"N:#2"}},"#2":{"Name":"a","Next":{"$ref":"N:#3"}},"#3":{"Name":"b","Next":{"$ref":"N:#1"}}}}`,
+	)
+	if len(p) != 2 ||
+		p[0].Op != PatchAdd || p[0].Node != "N:#3" || string(p[0].Value) != `{"Name":"new","Next":{"$ref":"N:#1"}}` ||
+		p[1].Op != PatchSetRef || p[1].Node != "N:#2" || p[1].Path != "Next" || string(p[1].Old) != "null" ||
+		string(p[1].Value) != `{"$ref":"N:#3"}` {
+		t.Errorf("unexpected patch %+v", p)
+	}
+}
+
+func TestPatchAppend(t *testing.T) {
+	base := `{"N":{"#1":{"L":[{"$ref":"N:#2"}]},"#2":{}}}`
+	p := checkPatchRoundTrip(t, base, `{"N":{"#1":{"L":[{"$ref":"N:#2"},{"$ref":"N:#3"}]},"#2":{},"#3":{}}}`)
+	if len(p) != 2 || p[1].Op != PatchAppend || p[1].Path != "L" || p[1].Old != nil {
+		t.Errorf("unexpected patch %+v", p)
+	}
+	// Appends commute with other appends to the same slice.
+	doc := mustParse(t, `{"N":{"#1":{"L":[{"$ref":"N:#2"},{"$ref":"N:#2"}]},"#2":{}}}`)
+	err := ApplyPatch(doc, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, _ := doc.Node("N", "#1").Value("L")
+	if formatValue(v) != `[{"$ref":"N:#2"},{"$ref":"N:#2"},{"$ref":"N:#3"}]` {
+		t.Errorf("unexpected value %s", formatValue(v))
+	}
+}
+
+func TestPatchConflicts(t *testing.T) {
+	doc := mustParse(t, `{"N":{"#1":{"Name":"a","M":{"k":1}},"#2":{}}}`)
+	p := Patch{
+		{Op: PatchSet, Node: "N:#1", Path: "Name", Old: json.RawMessage(`"a"`), Value: json.RawMessage(`"b"`)},
+		{Op: PatchSet, Node: "N:#1", Path: "M.k", Old: json.RawMessage(`2`), Value: json.RawMessage(`3`)},
+		{Op: PatchAdd, Node: "N:#2", Value: json.RawMessage(`{}`)},
+		{Op: PatchDelete, Node: "N:#3"},
+		{Op: PatchSetRef, Node: "N:#1", Path: "Next", Value: json.RawMessage(`1`)},
+		{Op: PatchAppend, Node: "N:#1", Path: "Name", Value: json.RawMessage(`{"$ref":"N:#2"}`)},
+	}
+	err := ApplyPatch(doc, p)
+	ce, ok := err.(*ConflictError)
+	if !ok {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected := []string{
+		`set N:#1 M.k: expected 2, found 1`,
+		`add N:#2: node already exists`,
+		`delete N:#3: node doesn't exist`,
+		`setref N:#1 Next: value is not a reference`,
+		`append N:#1 Name: "b" is not a slice`,
+	}
+	if len(ce.Conflicts) != len(expected) {
+		t.Fatalf("unexpected conflicts %v", ce.Conflicts)
+	}
+	for i, c := range ce.Conflicts {
+		if c.Error() != expected[i] {
+			t.Errorf("unexpected conflict %q, expected %q", c.Error(), expected[i])
+		}
+	}
+	// The document is left unchanged.
+	if v, _ := doc.Node("N", "#1").Value("Name"); v != "a" {
+		t.Errorf("document was modified")
+	}
+}
+
+func TestPatchMaster(t *testing.T) {
+	a := newGraphMaster()
+	b := newGraphMaster()
+	b.Nodes[1].Name = "d"
+	b.Nodes[1].Map["second"] = b.Nodes[2]
+	b.Nodes = append(b.Nodes, &graphNode{Name: "e", Next: b.Nodes[0]})
+	b.Nodes[0].List = append(b.Nodes[0].List, b.Nodes[3])
+	p, err := MakePatch(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes := append([]*graphNode{}, a.Nodes...)
+	err = ApplyPatch(a, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The existing nodes are updated in place.
+	for i, n := range nodes {
+		if a.Nodes[i] != n {
+			t.Errorf("node %d was replaced", i)
+		}
+	}
+	if len(a.Nodes) != 4 || a.Nodes[1].Name != "d" || a.Nodes[1].Map["second"] != a.Nodes[2] ||
+		len(a.Nodes[0].List) != 4 || a.Nodes[0].List[3] != a.Nodes[3] || a.Nodes[3].Next != a.Nodes[0] {
+		t.Errorf("unexpected result %+v", a.Nodes)
+	}
+	// The patch no longer applies to the original base.
+	err = ApplyPatch(a, p)
+	if _, ok := err.(*ConflictError); !ok {
+		t.Errorf("expected conflict, got %v", err)
+	}
+}
+
+type patchMapNode struct {
+	M map[string]int
+	R map[string]*patchMapNode
+}
+
+type patchMapMaster struct {
+	Nodes []*patchMapNode
+}
+
+func TestPatchMapKeys(t *testing.T) {
+	newMaster := func() *patchMapMaster {
+		m := &patchMapMaster{Nodes: []*patchMapNode{
+			{M: map[string]int{"a": 1, "b": 2, "x.y": 3, `[0]\`: 4}},
+			{},
+		}}
+		m.Nodes[0].R = map[string]*patchMapNode{"r.s": m.Nodes[1]}
+		return m
+	}
+	a := newMaster()
+	b := newMaster()
+	delete(b.Nodes[0].M, "b")
+	b.Nodes[0].M["x.y"] = 5
+	b.Nodes[0].M[`[0]\`] = 6
+	b.Nodes[0].M["c.d"] = 7
+	delete(b.Nodes[0].R, "r.s")
+	p, err := MakePatch(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ApplyPatch(a, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eq, diff, err := Equal(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !eq {
+		t.Errorf("patched graph differs: %s\n%+v", diff, p)
+	}
+	// The removal conflicts if the entry was changed.
+	c := newMaster()
+	c.Nodes[0].M["b"] = 10
+	err = ApplyPatch(c, p)
+	if _, ok := err.(*ConflictError); !ok {
+		t.Errorf("expected conflict, got %v", err)
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	field, segs, err := parsePath(`M.x\.y[2].\[\\`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if field != "M" || len(segs) != 3 || segs[0].key != "x.y" || !segs[1].isIndex || segs[1].index != 2 || segs[2].key != `[\` {
+		t.Errorf("unexpected result %q %+v", field, segs)
+	}
+	for _, path := range []string{"", "[1]", "L[x]", "L[1]x", `M\`} {
+		_, _, err = parsePath(path)
+		if err == nil {
+			t.Errorf("expected error for %q", path)
+		}
+	}
+}
@@ -0,0 +1,800 @@
+/*
+	Copyright (c) 2020 Martin Sustrik
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy
+	of this software and associated documentation files (the "Software"),
+	to deal in the Software without restriction, including without limitation
+	the rights to use, copy, modify, merge, publish, distribute, sublicense,
+	and/or sell copies of the Software, and to permit persons to whom
+	the Software is furnished to do so, subject to the following conditions:
+	The above copyright notice and this permission notice shall be included
+	in all copies or substantial portions of the Software.
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+	FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+	IN THE SOFTWARE.
+*/
+
+package grison
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// Protocol Buffers wire types.
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+)
+
+// protoShape is the way a field is represented in Protocol Buffers.
+type protoShape int
+
+const (
+	// Bool, number or string.
+	protoScalarShape protoShape = iota
+	// Byte slice.
+	protoBytesShape
+	// Ref message.
+	protoRefShape
+	// repeated Ref.
+	protoRefListShape
+	// map<K, Ref>.
+	protoRefMapShape
+	// repeated scalar.
+	protoScalarListShape
+	// map<K, scalar>.
+	protoScalarMapShape
+	// Anything else. Such fields are stored as JSON strings.
+	protoJSONShape
+)
+
+type protoField struct {
+	walkedField
+	num   int
+	shape protoShape
+}
+
+// protoFields returns the fields of the node message. Field number 1 is
+// reserved for the node ID, the fields of the node type follow in order,
+// unless the number is set explicitly by `proto` struct tag.
+func protoFields(tp reflect.Type, nodeTypes map[reflect.Type]string) ([]protoField, error) {
+	var pfs []protoField
+	nums := map[int]string{1: "id"}
+	for i, f := range nodeFields(tp, nodeTypes) {
+		if f.name == "id" {
+			return nil, fmt.Errorf("field %v.%s collides with the node ID", tp, f.name)
+		}
+		pf := protoField{walkedField: f, num: i + 2, shape: protoJSONShape}
+		if tag := tp.Field(f.index).Tag.Get("proto"); tag != "" {
+			num, err := strconv.Atoi(tag)
+			if err != nil || num < 1 || num > 1<<29-1 || num >= 19000 && num <= 19999 {
+				return nil, fmt.Errorf("invalid field number %q in %v.%s", tag, tp, f.name)
+			}
+			pf.num = num
+		}
+		if other, ok := nums[pf.num]; ok {
+			return nil, fmt.Errorf("fields %v.%s and %v.%s have the same number %d",
+				tp, other, tp, f.name, pf.num)
+		}
+		nums[pf.num] = f.name
+		switch f.kind {
+		case scalarField:
+			pf.shape = protoScalarShape
+		case refField:
+			pf.shape = protoRefShape
+		case refListField:
+			pf.shape = protoRefListShape
+		case refMapField:
+			if protoMapKey(f.tp.Key()) {
+				pf.shape = protoRefMapShape
+			}
+		default:
+			switch {
+			case hasCustomMarshaler(f.tp):
+			case f.tp.Kind() == reflect.Slice && f.tp.Elem().Kind() == reflect.Uint8:
+				pf.shape = protoBytesShape
+			case (f.tp.Kind() == reflect.Slice || f.tp.Kind() == reflect.Array) &&
+				protoScalarType(f.tp.Elem()) != "":
+				pf.shape = protoScalarListShape
+			case f.tp.Kind() == reflect.Map && protoMapKey(f.tp.Key()) &&
+				protoScalarType(f.tp.Elem()) != "":
+				pf.shape = protoScalarMapShape
+			}
+		}
+		pfs = append(pfs, pf)
+	}
+	return pfs, nil
+}
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// protoScalarType returns the Protocol Buffers type for a scalar Go type or
+// an empty string if the type is not a scalar.
+func protoScalarType(tp reflect.Type) string {
+	if hasCustomMarshaler(tp) || reflect.PtrTo(tp).Implements(textMarshalerType) {
+		return ""
+	}
+	switch tp.Kind() {
+	case reflect.Bool:
+		return "bool"
+	case reflect.String:
+		return "string"
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return "int32"
+	case reflect.Int, reflect.Int64:
+		return "int64"
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return "uint32"
+	case reflect.Uint, reflect.Uint64:
+		return "uint64"
+	case reflect.Float32:
+		return "float"
+	case reflect.Float64:
+		return "double"
+	}
+	return ""
+}
+
+// protoMapKey returns true if the type can be used as a key of a map.
+func protoMapKey(tp reflect.Type) bool {
+	switch protoScalarType(tp) {
+	case "string", "int32", "int64", "uint32", "uint64":
+		return true
+	}
+	return false
+}
+
+// protoCollection is a field of the top-level message.
+type protoCollection struct {
+	name   string
+	num    int
+	tp     reflect.Type
+	fields []protoField
+}
+
+// protoCollections returns the collections of the master structure in the
+// order of the fields of the master structure.
+func protoCollections(m interface{}, sch *schema) ([]protoCollection, error) {
+	var colls []protoCollection
+	mt := reflect.TypeOf(m).Elem()
+	for i := 0; i < mt.NumField(); i++ {
+		ft := getFieldTags(mt.Field(i))
+		if ft.ignore {
+			continue
+		}
+		tp := sch.types[ft.name]
+		fields, err := protoFields(tp, sch.nodeTypes)
+		if err != nil {
+			return nil, err
+		}
+		colls = append(colls, protoCollection{
+			name:   ft.name,
+			num:    len(colls) + 1,
+			tp:     tp,
+			fields: fields,
+		})
+	}
+	return colls, nil
+}
+
+// ProtoSchema returns a .proto file describing the messages produced by
+// MarshalProto. There's a message for each node type, with the node ID
+// as the first field, followed by the fields of the node type, numbered by
+// their position in the struct. To keep the numbers stable when fields are
+// added or removed, set them explicitly by `proto` struct tag, e.g.
+// `proto:"5"`. A node field named "id" collides with the node ID and is
+// rejected. References are Ref messages containing the collection name and
+// the ID of the node. Nil references are empty Ref messages. The top-level
+// message, named after the master structure, contains a repeated field for
+// each collection. Fields that can't be expressed in Protocol Buffers, such
+// as nested structures, are JSON strings.
+func ProtoSchema(m interface{}, pkg string) ([]byte, error) {
+	sch, err := newSchema(m)
+	if err != nil {
+		return nil, err
+	}
+	colls, err := protoCollections(m, sch)
+	if err != nil {
+		return nil, err
+	}
+	topName := reflect.TypeOf(m).Elem().Name()
+	if topName == "" {
+		topName = "Graph"
+	}
+	names := map[string]bool{"Ref": true, topName: true}
+	msgNames := make(map[reflect.Type]string)
+	for _, c := range colls {
+		name := c.tp.Name()
+		if name == "" {
+			name = c.name
+		}
+		if names[name] {
+			return nil, fmt.Errorf("duplicate message name %s", name)
+		}
+		names[name] = true
+		msgNames[c.tp] = name
+	}
+	var buf bytes.Buffer
+	buf.WriteString("syntax = \"proto3\";\n\n")
+	if pkg != "" {
+		fmt.Fprintf(&buf, "package %s;\n\n", pkg)
+	}
+	buf.WriteString("message Ref {\n  string collection = 1;\n  string id = 2;\n}\n")
+	for _, c := range colls {
+		fmt.Fprintf(&buf, "\nmessage %s {\n  string id = 1;\n", msgNames[c.tp])
+		for _, f := range c.fields {
+			var tp string
+			switch f.shape {
+			case protoScalarShape:
+				tp = protoScalarType(f.tp)
+			case protoBytesShape:
+				tp = "bytes"
+			case protoRefShape:
+				tp = "Ref"
+			case protoRefListShape:
+				tp = "repeated Ref"
+			case protoRefMapShape:
+				tp = fmt.Sprintf("map<%s, Ref>", protoScalarType(f.tp.Key()))
+			case protoScalarListShape:
+				tp = "repeated " + protoScalarType(f.tp.Elem())
+			case protoScalarMapShape:
+				tp = fmt.Sprintf("map<%s, %s>", protoScalarType(f.tp.Key()), protoScalarType(f.tp.Elem()))
+			default:
+				buf.WriteString("  // JSON\n")
+				tp = "string"
+			}
+			fmt.Fprintf(&buf, "  %s %s = %d;\n", tp, f.name, f.num)
+		}
+		buf.WriteString("}\n")
+	}
+	fmt.Fprintf(&buf, "\nmessage %s {\n", topName)
+	for _, c := range colls {
+		fmt.Fprintf(&buf, "  repeated %s %s = %d;\n", msgNames[c.tp], c.name, c.num)
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes(), nil
+}
+
+// MarshalProto converts the graph reachable from the master structure into
+// Protocol Buffers binary format, as described by ProtoSchema. Note that
+// the format doesn't distinguish between nil and empty slices and maps.
+// Both are unmarshaled as nil.
+func MarshalProto(m interface{}, opts MarshalOpts) ([]byte, error) {
+	sch, err := newSchema(m)
+	if err != nil {
+		return nil, err
+	}
+	w, err := newWalker(m, opts)
+	if err != nil {
+		return nil, err
+	}
+	colls, err := protoCollections(m, sch)
+	if err != nil {
+		return nil, err
+	}
+	var b []byte
+	for _, c := range colls {
+		for _, wn := range w.nodes {
+			if wn.ref.Type != c.name {
+				continue
+			}
+			msg, err := w.marshalProtoNode(wn, c.fields)
+			if err != nil {
+				return nil, err
+			}
+			b = appendProtoBytes(b, c.num, msg)
+		}
+	}
+	return b, nil
+}
+
+func (w *walker) marshalProtoNode(wn walkedNode, fields []protoField) ([]byte, error) {
+	b := appendProtoBytes(nil, 1, []byte(wn.ref.ID))
+	for _, f := range fields {
+		fv := wn.val.Field(f.index)
+		switch f.shape {
+		case protoScalarShape:
+			if !fv.IsZero() {
+				b = appendProtoScalar(b, f.num, fv)
+			}
+		case protoBytesShape:
+			if fv.Len() > 0 {
+				b = appendProtoBytes(b, f.num, fv.Bytes())
+			}
+		case protoRefShape:
+			if _, ok := w.ref(fv); ok {
+				b = appendProtoBytes(b, f.num, w.protoRef(fv))
+			}
+		case protoRefListShape:
+			for i := 0; i < fv.Len(); i++ {
+				b = appendProtoBytes(b, f.num, w.protoRef(fv.Index(i)))
+			}
+		case protoRefMapShape, protoScalarMapShape:
+			for _, k := range sortedMapKeys(fv) {
+				entry := appendProtoScalar(nil, 1, k)
+				v := fv.MapIndex(k)
+				if f.shape == protoRefMapShape {
+					if _, ok := w.ref(v); ok {
+						entry = appendProtoBytes(entry, 2, w.protoRef(v))
+					}
+				} else {
+					entry = appendProtoScalar(entry, 2, v)
+				}
+				b = appendProtoBytes(b, f.num, entry)
+			}
+		case protoScalarListShape:
+			if fv.Len() == 0 {
+				continue
+			}
+			if f.tp.Elem().Kind() == reflect.String {
+				for i := 0; i < fv.Len(); i++ {
+					b = appendProtoBytes(b, f.num, []byte(fv.Index(i).String()))
+				}
+				continue
+			}
+			// Numbers and bools are packed.
+			var packed []byte
+			for i := 0; i < fv.Len(); i++ {
+				packed = appendProtoValue(packed, fv.Index(i))
+			}
+			b = appendProtoBytes(b, f.num, packed)
+		default:
+			js, err := w.marshalValue(fv)
+			if err != nil {
+				return nil, err
+			}
+			if string(js) != "null" {
+				b = appendProtoBytes(b, f.num, js)
+			}
+		}
+	}
+	return b, nil
+}
+
+// protoRef returns Ref message for the reference stored in the value.
+func (w *walker) protoRef(v reflect.Value) []byte {
+	ref, ok := w.ref(v)
+	if !ok {
+		return []byte{}
+	}
+	b := appendProtoBytes(nil, 1, []byte(ref.Type))
+	return appendProtoBytes(b, 2, []byte(ref.ID))
+}
+
+func appendProtoVarint(b []byte, u uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], u)
+	return append(b, buf[:n]...)
+}
+
+func appendProtoTag(b []byte, num int, wt int) []byte {
+	return appendProtoVarint(b, uint64(num)<<3|uint64(wt))
+}
+
+func appendProtoBytes(b []byte, num int, data []byte) []byte {
+	b = appendProtoTag(b, num, protoBytes)
+	b = appendProtoVarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func protoWireType(k reflect.Kind) int {
+	switch k {
+	case reflect.String:
+		return protoBytes
+	case reflect.Float32:
+		return protoFixed32
+	case reflect.Float64:
+		return protoFixed64
+	}
+	return protoVarint
+}
+
+// appendProtoScalar appends a field containing a scalar value.
+func appendProtoScalar(b []byte, num int, v reflect.Value) []byte {
+	if v.Kind() == reflect.String {
+		return appendProtoBytes(b, num, []byte(v.String()))
+	}
+	b = appendProtoTag(b, num, protoWireType(v.Kind()))
+	return appendProtoValue(b, v)
+}
+
+// appendProtoValue appends a number or a bool without the tag.
+func appendProtoValue(b []byte, v reflect.Value) []byte {
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return append(b, 1)
+		}
+		return append(b, 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendProtoVarint(b, uint64(v.Int()))
+	case reflect.Float32:
+		var buf [4]byte
+		binary.LittleEndian.PutUint32(buf[:], math.Float32bits(float32(v.Float())))
+		return append(b, buf[:]...)
+	case reflect.Float64:
+		var buf [8]byte
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v.Float()))
+		return append(b, buf[:]...)
+	}
+	return appendProtoVarint(b, v.Uint())
+}
+
+// UnmarshalProto fills in the master structure from Protocol Buffers binary
+// format produced by MarshalProto. Unknown fields are ignored.
+func UnmarshalProto(b []byte, m interface{}) error {
+	sch, err := newSchema(m)
+	if err != nil {
+		return err
+	}
+	cs, err := protoCollections(m, sch)
+	if err != nil {
+		return err
+	}
+	colls := make(map[int]protoCollection)
+	for _, c := range cs {
+		colls[c.num] = c
+	}
+	doc := sch.newDocument()
+	r := &protoReader{b: b}
+	for !r.done() {
+		num, wt, err := r.readTag()
+		if err != nil {
+			return err
+		}
+		c, ok := colls[num]
+		if !ok || wt != protoBytes {
+			err = r.skip(wt)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		msg, err := r.readBytes()
+		if err != nil {
+			return err
+		}
+		err = unmarshalProtoNode(msg, c, doc)
+		if err != nil {
+			return err
+		}
+	}
+	return UnmarshalDocument(doc, m, UnmarshalOpts{})
+}
+
+func unmarshalProtoNode(b []byte, c protoCollection, doc *Document) error {
+	fields := make(map[int]protoField)
+	for _, f := range c.fields {
+		fields[f.num] = f
+	}
+	var id string
+	values := make(map[string]interface{})
+	r := &protoReader{b: b}
+	for !r.done() {
+		num, wt, err := r.readTag()
+		if err != nil {
+			return err
+		}
+		if num == 1 && wt == protoBytes {
+			data, err := r.readBytes()
+			if err != nil {
+				return err
+			}
+			id = string(data)
+			continue
+		}
+		f, ok := fields[num]
+		if !ok {
+			err = r.skip(wt)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		err = r.readField(f, wt, values)
+		if err != nil {
+			return fmt.Errorf("field %s of %s: %v", f.name, c.name, err)
+		}
+	}
+	n, err := doc.AddNode(c.name, id)
+	if err != nil {
+		return err
+	}
+	for name, v := range values {
+		err = n.SetValue(name, v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type protoReader struct {
+	b   []byte
+	pos int
+}
+
+func (r *protoReader) done() bool {
+	return r.pos >= len(r.b)
+}
+
+func (r *protoReader) readVarint() (uint64, error) {
+	u, n := binary.Uvarint(r.b[r.pos:])
+	if n <= 0 {
+		return 0, fmt.Errorf("malformed varint")
+	}
+	r.pos += n
+	return u, nil
+}
+
+func (r *protoReader) readTag() (int, int, error) {
+	u, err := r.readVarint()
+	if err != nil {
+		return 0, 0, err
+	}
+	if u>>3 == 0 || u>>3 > math.MaxInt32 {
+		return 0, 0, fmt.Errorf("invalid field number %d", u>>3)
+	}
+	return int(u >> 3), int(u & 7), nil
+}
+
+func (r *protoReader) readFixed(size int) (uint64, error) {
+	if len(r.b)-r.pos < size {
+		return 0, fmt.Errorf("unexpected end of data")
+	}
+	var u uint64
+	if size == 4 {
+		u = uint64(binary.LittleEndian.Uint32(r.b[r.pos:]))
+	} else {
+		u = binary.LittleEndian.Uint64(r.b[r.pos:])
+	}
+	r.pos += size
+	return u, nil
+}
+
+func (r *protoReader) readBytes() ([]byte, error) {
+	l, err := r.readVarint()
+	if err != nil {
+		return nil, err
+	}
+	if l > uint64(len(r.b)-r.pos) {
+		return nil, fmt.Errorf("unexpected end of data")
+	}
+	data := r.b[r.pos : r.pos+int(l)]
+	r.pos += int(l)
+	return data, nil
+}
+
+func (r *protoReader) skip(wt int) error {
+	var err error
+	switch wt {
+	case protoVarint:
+		_, err = r.readVarint()
+	case protoFixed64:
+		_, err = r.readFixed(8)
+	case protoBytes:
+		_, err = r.readBytes()
+	case protoFixed32:
+		_, err = r.readFixed(4)
+	default:
+		err = fmt.Errorf("unsupported wire type %d", wt)
+	}
+	return err
+}
+
+// readField reads the field and stores its value in values. Values of
+// repeated fields and maps are accumulated.
+func (r *protoReader) readField(f protoField, wt int, values map[string]interface{}) error {
+	switch f.shape {
+	case protoScalarShape:
+		v, err := r.readScalar(f.tp.Kind(), wt)
+		if err != nil {
+			return err
+		}
+		values[f.name] = v
+	case protoBytesShape:
+		if wt != protoBytes {
+			return fmt.Errorf("unexpected wire type %d", wt)
+		}
+		data, err := r.readBytes()
+		if err != nil {
+			return err
+		}
+		values[f.name] = base64.StdEncoding.EncodeToString(data)
+	case protoRefShape:
+		ref, err := r.readRef(wt)
+		if err != nil {
+			return err
+		}
+		values[f.name] = ref
+	case protoRefListShape:
+		ref, err := r.readRef(wt)
+		if err != nil {
+			return err
+		}
+		l, _ := values[f.name].([]interface{})
+		values[f.name] = append(l, ref)
+	case protoScalarListShape:
+		l, _ := values[f.name].([]interface{})
+		kind := f.tp.Elem().Kind()
+		if wt == protoBytes && kind != reflect.String {
+			packed, err := r.readBytes()
+			if err != nil {
+				return err
+			}
+			pr := &protoReader{b: packed}
+			for !pr.done() {
+				v, err := pr.readScalar(kind, protoWireType(kind))
+				if err != nil {
+					return err
+				}
+				l = append(l, v)
+			}
+		} else {
+			v, err := r.readScalar(kind, wt)
+			if err != nil {
+				return err
+			}
+			l = append(l, v)
+		}
+		values[f.name] = l
+	case protoRefMapShape, protoScalarMapShape:
+		if wt != protoBytes {
+			return fmt.Errorf("unexpected wire type %d", wt)
+		}
+		entry, err := r.readBytes()
+		if err != nil {
+			return err
+		}
+		var key, val interface{}
+		er := &protoReader{b: entry}
+		for !er.done() {
+			num, ewt, err := er.readTag()
+			if err != nil {
+				return err
+			}
+			switch {
+			case num == 1:
+				key, err = er.readScalar(f.tp.Key().Kind(), ewt)
+			case num == 2 && f.shape == protoRefMapShape:
+				val, err = er.readRef(ewt)
+			case num == 2:
+				val, err = er.readScalar(f.tp.Elem().Kind(), ewt)
+			default:
+				err = er.skip(ewt)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if key == nil {
+			key = protoZeroValue(f.tp.Key().Kind())
+		}
+		if val == nil && f.shape == protoScalarMapShape {
+			val = protoZeroValue(f.tp.Elem().Kind())
+		}
+		mv, ok := values[f.name].(map[string]interface{})
+		if !ok {
+			mv = make(map[string]interface{})
+			values[f.name] = mv
+		}
+		mv[fmt.Sprintf("%v", key)] = val
+	default:
+		if wt != protoBytes {
+			return fmt.Errorf("unexpected wire type %d", wt)
+		}
+		data, err := r.readBytes()
+		if err != nil {
+			return err
+		}
+		v, err := decodeValue(data)
+		if err != nil {
+			return err
+		}
+		values[f.name] = v
+	}
+	return nil
+}
+
+// readRef reads Ref message. Empty message stands for nil reference.
+func (r *protoReader) readRef(wt int) (interface{}, error) {
+	if wt != protoBytes {
+		return nil, fmt.Errorf("unexpected wire type %d", wt)
+	}
+	msg, err := r.readBytes()
+	if err != nil {
+		return nil, err
+	}
+	var ref Ref
+	mr := &protoReader{b: msg}
+	for !mr.done() {
+		num, mwt, err := mr.readTag()
+		if err != nil {
+			return nil, err
+		}
+		if (num != 1 && num != 2) || mwt != protoBytes {
+			err = mr.skip(mwt)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		data, err := mr.readBytes()
+		if err != nil {
+			return nil, err
+		}
+		if num == 1 {
+			ref.Type = string(data)
+		} else {
+			ref.ID = string(data)
+		}
+	}
+	if ref.Type == "" {
+		return nil, nil
+	}
+	return ref, nil
+}
+
+// readScalar reads a scalar value of the specified kind and returns it in
+// the form suitable for Node.SetValue.
+func (r *protoReader) readScalar(kind reflect.Kind, wt int) (interface{}, error) {
+	if wt != protoWireType(kind) {
+		return nil, fmt.Errorf("unexpected wire type %d", wt)
+	}
+	switch kind {
+	case reflect.String:
+		data, err := r.readBytes()
+		if err != nil {
+			return nil, err
+		}
+		return string(data), nil
+	case reflect.Float32:
+		u, err := r.readFixed(4)
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(floatText(float64(math.Float32frombits(uint32(u))), 32)), nil
+	case reflect.Float64:
+		u, err := r.readFixed(8)
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(floatText(math.Float64frombits(u), 64)), nil
+	}
+	u, err := r.readVarint()
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case reflect.Bool:
+		return u != 0, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return json.Number(strconv.FormatInt(int64(u), 10)), nil
+	}
+	return json.Number(strconv.FormatUint(u, 10)), nil
+}
+
+// protoZeroValue returns the value of a scalar field missing in a message.
+func protoZeroValue(kind reflect.Kind) interface{} {
+	switch kind {
+	case reflect.String:
+		return ""
+	case reflect.Bool:
+		return false
+	}
+	return json.Number("0")
+}
@@ -0,0 +1,235 @@
+package grison
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+type protoNode struct {
+	Name   string
+	Age    int32
+	Score  float32
+	Ok     bool
+	Data   []byte
+	Tags   []string
+	Nums   []int
+	Counts map[string]uint
+	Next   *protoNode
+	Any    interface{}
+	List   []*protoNode
+	ByID   map[string]*protoNode
+	Nested struct{ N *protoNode }
+}
+
+type protoMaster struct {
+	Nodes []*protoNode
+}
+
+func newProtoMaster() *protoMaster {
+	m := &protoMaster{Nodes: []*protoNode{
+		{Name: "a", Age: -5, Score: 0.1, Ok: true, Data: []byte{1, 2}, Tags: []string{"x", ""},
+			Nums: []int{-1, 0, 300}, Counts: map[string]uint{"c": 3, "z": 0}},
+		{},
+	}}
+	m.Nodes[0].Next = m.Nodes[1]
+	m.Nodes[1].Any = m.Nodes[0]
+	m.Nodes[1].List = []*protoNode{nil, m.Nodes[1]}
+	m.Nodes[1].ByID = map[string]*protoNode{"1": m.Nodes[0], "2": nil}
+	m.Nodes[0].Nested.N = m.Nodes[0]
+	return m
+}
+
+func TestProtoSchema(t *testing.T) {
+	b, err := ProtoSchema(&protoMaster{}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `syntax = "proto3";
+
+package test;
+
+message Ref {
+  string collection = 1;
+  string id = 2;
+}
+
+message protoNode {
+  string id = 1;
+  string Name = 2;
+  int32 Age = 3;
+  float Score = 4;
+  bool Ok = 5;
+  bytes Data = 6;
+  repeated string Tags = 7;
+  repeated int64 Nums = 8;
+  map<string, uint64> Counts = 9;
+  Ref Next = 10;
+  Ref Any = 11;
+  repeated Ref List = 12;
+  map<string, Ref> ByID = 13;
+  // JSON
+  string Nested = 14;
+}
+
+message protoMaster {
+  repeated protoNode Nodes = 1;
+}
+`
+	if string(b) != expected {
+		t.Errorf("unexpected schema.\nexpect=%s\nactual=%s", expected, b)
+	}
+}
+
+func TestProtoMinimal(t *testing.T) {
+	type Node struct {
+		A int
+		N *Node
+	}
+	type Master struct {
+		Node []*Node
+	}
+	m := &Master{Node: []*Node{{A: 2}}}
+	m.Node[0].N = m.Node[0]
+	b, err := MarshalProto(m, MarshalOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Node {id: "#1", A: 2, N: Ref {collection: "Node", id: "#1"}}
+	expected := "0a12" + "0a022331" + "1002" + "1a0a0a044e6f646512022331"
+	if hex.EncodeToString(b) != expected {
+		t.Errorf("unexpected encoding.\nexpect=%s\nactual=%s", expected, hex.EncodeToString(b))
+	}
+}
+
+func TestProtoRoundTrip(t *testing.T) {
+	m := newProtoMaster()
+	b, err := MarshalWithOpts(m, MarshalOpts{Format: FormatProto})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m2 protoMaster
+	err = UnmarshalWithOpts(b, &m2, UnmarshalOpts{Format: FormatProto})
+	if err != nil {
+		t.Fatal(err)
+	}
+	j1, err := Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j2, err := Marshal(&m2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(j1) != string(j2) {
+		t.Errorf("unexpected round trip result.\nexpect=%s\nactual=%s", j1, j2)
+	}
+}
+
+func TestProtoDecode(t *testing.T) {
+	type Node struct {
+		A []int
+		B int32
+	}
+	type Master struct {
+		Node []*Node
+	}
+	// Unpacked repeated field, negative int32 as 10-byte varint
+	// and an unknown field.
+	b, _ := hex.DecodeString("0a17" + "0a0131" + "1001" + "1002" + "18feffffffffffffffff01" + "2203616263")
+	var m Master
+	err := UnmarshalProto(b, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Node) != 1 || len(m.Node[0].A) != 2 || m.Node[0].A[1] != 2 || m.Node[0].B != -2 {
+		t.Errorf("unexpected result %+v", m.Node[0])
+	}
+	err = UnmarshalProto([]byte{0x0a, 0x05, 0x0a}, &m)
+	if err == nil {
+		t.Errorf("truncated data accepted")
+	}
+}
+
+func TestProtoFieldNumbers(t *testing.T) {
+	type Node struct {
+		A int `proto:"7"`
+		B string
+		N *Node `proto:"5"`
+	}
+	type Master struct {
+		Node []*Node
+	}
+	b, err := ProtoSchema(&Master{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `syntax = "proto3";
+
+message Ref {
+  string collection = 1;
+  string id = 2;
+}
+
+message Node {
+  string id = 1;
+  int64 A = 7;
+  string B = 3;
+  Ref N = 5;
+}
+
+message Master {
+  repeated Node Node = 1;
+}
+`
+	if string(b) != expected {
+		t.Errorf("unexpected schema.\nexpect=%s\nactual=%s", expected, b)
+	}
+	m := &Master{Node: []*Node{{A: 2, B: "x"}}}
+	m.Node[0].N = m.Node[0]
+	b, err = MarshalProto(m, MarshalOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m2 Master
+	err = UnmarshalProto(b, &m2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m2.Node[0].A != 2 || m2.Node[0].B != "x" || m2.Node[0].N != m2.Node[0] {
+		t.Errorf("unexpected round trip result %+v", m2.Node[0])
+	}
+}
+
+func TestProtoBadFields(t *testing.T) {
+	type Dup struct {
+		A int
+		B int `proto:"2"`
+	}
+	type DupMaster struct {
+		Dup []*Dup
+	}
+	type Reserved struct {
+		A int `proto:"1"`
+	}
+	type ReservedMaster struct {
+		Reserved []*Reserved
+	}
+	type Bad struct {
+		A int `proto:"x"`
+	}
+	type BadMaster struct {
+		Bad []*Bad
+	}
+	type ID struct {
+		ID int `grison:"id"`
+	}
+	type IDMaster struct {
+		ID []*ID
+	}
+	for _, m := range []interface{}{&DupMaster{}, &ReservedMaster{}, &BadMaster{}, &IDMaster{}} {
+		_, err := ProtoSchema(m, "")
+		if err == nil {
+			t.Errorf("expected error for %T", m)
+		}
+	}
+}
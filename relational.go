@@ -0,0 +1,246 @@
+/*
+	Copyright (c) 2020 Martin Sustrik
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy
+	of this software and associated documentation files (the "Software"),
+	to deal in the Software without restriction, including without limitation
+	the rights to use, copy, modify, merge, publish, distribute, sublicense,
+	and/or sell copies of the Software, and to permit persons to whom
+	the Software is furnished to do so, subject to the following conditions:
+	The above copyright notice and this permission notice shall be included
+	in all copies or substantial portions of the Software.
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+	FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+	IN THE SOFTWARE.
+*/
+
+package grison
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// relKind is the type of a column in the relational representation of a graph.
+type relKind int
+
+const (
+	relText relKind = iota
+	relInt
+	// Unsigned integer that may not fit into a signed 64-bit one.
+	relUint
+	relFloat
+	relBool
+	// JSON representation of a value that is neither a scalar nor a reference.
+	relJSON
+	// ID of a node in the table specified by refTable.
+	relRef
+	// Reference in "Type:ID" format. Used for interface fields that may
+	// refer to nodes of any type.
+	relAnyRef
+)
+
+type relColumn struct {
+	name string
+	kind relKind
+	// Table referred to by relRef column.
+	refTable string
+	notNull  bool
+	// The field stored in a column of a node table.
+	field *walkedField
+}
+
+// relTable is a table in the relational representation of a graph. There's
+// a node table for each collection with a row per node. Scalar fields and
+// single references are stored as columns of the node table. Slices and maps
+// of references are stored in join tables with a row per element. The node
+// table stores the number of elements of such fields, NULL for nil.
+type relTable struct {
+	name    string
+	columns []relColumn
+	// Number of leading columns that form the primary key.
+	key int
+	// Collection the table belongs to.
+	coll string
+	// The field stored in a join table. Nil for node tables.
+	field *walkedField
+	// Values are nil (NULL), bool, json.Number or string.
+	rows [][]interface{}
+}
+
+const (
+	relIDColumn    = "id"
+	relIndexColumn = "index"
+	relKeyColumn   = "key"
+	relRefColumn   = "ref"
+)
+
+// relTables returns the tables for the master structure, without any rows.
+// The tables are ordered by collection, each node table being followed by
+// its join tables.
+func relTables(sch *schema) []*relTable {
+	var colls []string
+	for nm := range sch.types {
+		colls = append(colls, nm)
+	}
+	sort.Strings(colls)
+	var tables []*relTable
+	for _, coll := range colls {
+		nt := &relTable{
+			name:    coll,
+			columns: []relColumn{{name: relIDColumn, kind: relText, notNull: true}},
+			key:     1,
+			coll:    coll,
+		}
+		tables = append(tables, nt)
+		for i, f := range sch.fields[coll] {
+			col := relColumn{name: f.name, field: &sch.fields[coll][i]}
+			switch f.kind {
+			case scalarField:
+				col.kind = relScalarKind(f.tp)
+				col.notNull = true
+			case refField:
+				col.kind, col.refTable = sch.relRefKind(f.tp)
+			case refListField, refMapField:
+				col.kind = relInt
+				jt := &relTable{
+					name: coll + "_" + f.name,
+					columns: []relColumn{
+						{name: relIDColumn, kind: relText, refTable: coll, notNull: true},
+						{name: relIndexColumn, kind: relInt, notNull: true},
+						{name: relRefColumn},
+					},
+					key:   2,
+					coll:  coll,
+					field: &sch.fields[coll][i],
+				}
+				if f.kind == refMapField {
+					jt.columns[1] = relColumn{name: relKeyColumn, kind: relText, notNull: true}
+				}
+				jt.columns[2].kind, jt.columns[2].refTable = sch.relRefKind(f.tp.Elem())
+				tables = append(tables, jt)
+			default:
+				col.kind = relJSON
+				col.notNull = true
+			}
+			nt.columns = append(nt.columns, col)
+		}
+	}
+	return tables
+}
+
+func relScalarKind(tp reflect.Type) relKind {
+	switch tp.Kind() {
+	case reflect.Bool:
+		return relBool
+	case reflect.String:
+		return relText
+	case reflect.Float32, reflect.Float64:
+		return relFloat
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		return relUint
+	}
+	return relInt
+}
+
+// relRefKind returns the column kind for a reference of the specified type.
+func (s *schema) relRefKind(tp reflect.Type) (relKind, string) {
+	if tp.Kind() == reflect.Interface {
+		return relAnyRef, ""
+	}
+	return relRef, s.nodeTypes[tp.Elem()]
+}
+
+// marshalRelational returns the tables filled in with the graph reachable
+// from the master structure.
+func marshalRelational(m interface{}, opts MarshalOpts) ([]*relTable, error) {
+	sch, err := newSchema(m)
+	if err != nil {
+		return nil, err
+	}
+	w, err := newWalker(m, opts)
+	if err != nil {
+		return nil, err
+	}
+	tables := relTables(sch)
+	byName := make(map[string]*relTable)
+	for _, t := range tables {
+		byName[t.name] = t
+	}
+	refValue := func(v reflect.Value, kind relKind) interface{} {
+		ref, ok := w.ref(v)
+		if !ok {
+			return nil
+		}
+		if kind == relAnyRef {
+			return ref.String()
+		}
+		return ref.ID
+	}
+	for _, wn := range w.nodes {
+		nt := byName[wn.ref.Type]
+		row := []interface{}{wn.ref.ID}
+		for i, f := range sch.fields[wn.ref.Type] {
+			fv := wn.val.Field(f.index)
+			col := nt.columns[i+1]
+			switch f.kind {
+			case scalarField:
+				v, err := relScalarValue(fv)
+				if err != nil {
+					return nil, err
+				}
+				row = append(row, v)
+			case refField:
+				row = append(row, refValue(fv, col.kind))
+			case refListField, refMapField:
+				if fv.Kind() != reflect.Array && fv.IsNil() {
+					row = append(row, nil)
+					continue
+				}
+				row = append(row, json.Number(strconv.Itoa(fv.Len())))
+				jt := byName[wn.ref.Type+"_"+f.name]
+				kind := jt.columns[2].kind
+				if f.kind == refListField {
+					for j := 0; j < fv.Len(); j++ {
+						jt.rows = append(jt.rows, []interface{}{
+							wn.ref.ID, json.Number(strconv.Itoa(j)), refValue(fv.Index(j), kind)})
+					}
+				} else {
+					for _, k := range sortedMapKeys(fv) {
+						jt.rows = append(jt.rows, []interface{}{
+							wn.ref.ID, fmt.Sprintf("%v", k.Interface()), refValue(fv.MapIndex(k), kind)})
+					}
+				}
+			default:
+				b, err := w.marshalValue(fv)
+				if err != nil {
+					return nil, err
+				}
+				row = append(row, string(b))
+			}
+		}
+		nt.rows = append(nt.rows, row)
+	}
+	return tables, nil
+}
+
+func relScalarValue(v reflect.Value) (interface{}, error) {
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool(), nil
+	case reflect.String:
+		return v.String(), nil
+	}
+	s, err := scalarText(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Number(s), nil
+}
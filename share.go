@@ -0,0 +1,394 @@
+/*
+	Copyright (c) 2020 Martin Sustrik
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy
+	of this software and associated documentation files (the "Software"),
+	to deal in the Software without restriction, including without limitation
+	the rights to use, copy, modify, merge, publish, distribute, sublicense,
+	and/or sell copies of the Software, and to permit persons to whom
+	the Software is furnished to do so, subject to the following conditions:
+	The above copyright notice and this permission notice shall be included
+	in all copies or substantial portions of the Software.
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+	FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+	IN THE SOFTWARE.
+*/
+
+package grison
+
+import (
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// sharedValue is a value that may be referred to from multiple places, such
+// as a YAML anchor or a CBOR shareable value. All the places refer to the same
+// sharedValue instance, which allows identifying the nodes in the formats
+// that express references this way.
+type sharedValue struct {
+	label string
+	v     interface{}
+}
+
+func unwrapShared(v interface{}) interface{} {
+	for {
+		sv, ok := v.(*sharedValue)
+		if !ok {
+			return v
+		}
+		v = sv.v
+	}
+}
+
+// documentFromShared builds a document from decoded map of collections of
+// nodes, where nodes are shared values. Any reference to a node that is
+// a member of a collection becomes a grison reference. Values that are
+// shared but not members of any collection are copied.
+func documentFromShared(v interface{}) (*Document, error) {
+	colls, ok := unwrapShared(v).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("document must be a map of collections")
+	}
+	doc := NewDocument()
+	// First, find out which shared values are nodes.
+	refs := make(map[*sharedValue]Ref)
+	for tp, coll := range colls {
+		doc.AddType(tp)
+		nodes, ok := unwrapShared(coll).(map[string]interface{})
+		if !ok && coll != nil {
+			return nil, fmt.Errorf("collection %s is not a map", tp)
+		}
+		for id, n := range nodes {
+			if sv, ok := n.(*sharedValue); ok {
+				if prev, ok := refs[sv]; ok {
+					return nil, fmt.Errorf("node %s:%s is the same as %s", tp, id, prev)
+				}
+				refs[sv] = Ref{Type: tp, ID: id}
+			}
+		}
+	}
+	for tp, coll := range colls {
+		nodes, _ := unwrapShared(coll).(map[string]interface{})
+		for id, n := range nodes {
+			fields, ok := unwrapShared(n).(map[string]interface{})
+			if !ok && unwrapShared(n) != nil {
+				return nil, fmt.Errorf("node %s:%s is not a map", tp, id)
+			}
+			node, err := doc.AddNode(tp, id)
+			if err != nil {
+				return nil, err
+			}
+			for name, fv := range fields {
+				v, err := sharedToValue(fv, refs, make(map[*sharedValue]bool))
+				if err != nil {
+					return nil, fmt.Errorf("field %s of %s:%s: %v", name, tp, id, err)
+				}
+				err = node.SetValue(name, v)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return doc, nil
+}
+
+// sharedToValue converts decoded value into a value suitable for Node.SetValue.
+func sharedToValue(v interface{}, refs map[*sharedValue]Ref, visiting map[*sharedValue]bool) (interface{}, error) {
+	if sv, ok := v.(*sharedValue); ok {
+		if ref, ok := refs[sv]; ok {
+			return ref, nil
+		}
+		if visiting[sv] {
+			return nil, fmt.Errorf("value %s is cyclic, but it is not a node", sv.label)
+		}
+		visiting[sv] = true
+		defer delete(visiting, sv)
+		return sharedToValue(sv.v, refs, visiting)
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			item, err := sharedToValue(item, refs, visiting)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = item
+		}
+		return m, nil
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, item := range v {
+			item, err := sharedToValue(item, refs, visiting)
+			if err != nil {
+				return nil, err
+			}
+			s[i] = item
+		}
+		return s, nil
+	}
+	return v, nil
+}
+
+// unmarshalShared fills in the master structure from decoded map of
+// collections of nodes, where nodes are shared values, without building
+// a document first. The result is the same as with documentFromShared
+// followed by UnmarshalDocument.
+func unmarshalShared(v interface{}, m interface{}) error {
+	colls, ok := unwrapShared(v).(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("document must be a map of collections")
+	}
+	dec, err := newDecoder(m)
+	if err != nil {
+		return err
+	}
+	dec.shared = make(map[*sharedValue]Ref)
+	for tp, coll := range colls {
+		nodes, ok := unwrapShared(coll).(map[string]interface{})
+		if !ok && coll != nil {
+			return fmt.Errorf("collection %s is not a map", tp)
+		}
+		var ids []string
+		for id, n := range nodes {
+			ids = append(ids, id)
+			if sv, ok := n.(*sharedValue); ok {
+				if prev, ok := dec.shared[sv]; ok {
+					return fmt.Errorf("node %s:%s is the same as %s", tp, id, prev)
+				}
+				dec.shared[sv] = Ref{Type: tp, ID: id}
+			}
+		}
+		err = dec.addNodes(tp, ids)
+		if err != nil {
+			return err
+		}
+	}
+	for tp, coll := range colls {
+		nodes, _ := unwrapShared(coll).(map[string]interface{})
+		for id, n := range nodes {
+			fields := unwrapShared(n)
+			if _, ok := fields.(map[string]interface{}); !ok && fields != nil {
+				return fmt.Errorf("node %s:%s is not a map", tp, id)
+			}
+			err = dec.unmarshalValue(fields, dec.refmap[fmt.Sprintf("%s:%s", tp, id)])
+			if err != nil {
+				return fmt.Errorf("node %s:%s: %v", tp, id, err)
+			}
+		}
+	}
+	return nil
+}
+
+// unmarshalValue is the counterpart of unmarshalAny for decoded values.
+// References are either shared values that are nodes or Refs.
+func (dec *decoder) unmarshalValue(v interface{}, p reflect.Value) error {
+	if sv, ok := v.(*sharedValue); ok {
+		if _, ok := dec.shared[sv]; !ok {
+			var err error
+			v, err = sharedToValue(sv, dec.shared, make(map[*sharedValue]bool))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if _, ok := p.Interface().(json.Unmarshaler); ok {
+		return dec.unmarshalValueJSON(v, p)
+	}
+	e := p.Elem()
+	if v == nil {
+		// As with JSON, null leaves the value untouched.
+		return nil
+	}
+	switch e.Kind() {
+	case reflect.Ptr:
+		if _, ok := dec.types[e.Type().Elem()]; ok {
+			return dec.unmarshalValueRef(v, p)
+		}
+		np := reflect.New(e.Type().Elem())
+		err := dec.unmarshalValue(v, np)
+		if err != nil {
+			return err
+		}
+		e.Set(np)
+		return nil
+	case reflect.Interface:
+		return dec.unmarshalValueRef(v, p)
+	case reflect.Struct:
+		fields, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("cannot unmarshal %v into %v", v, e.Type())
+		}
+		tp := e.Type()
+		for i := 0; i < e.NumField(); i++ {
+			ft := getFieldTags(tp.Field(i))
+			if ft.ignore {
+				continue
+			}
+			// ID of the node is set from the key.
+			if _, ok := dec.types[tp]; ok && ft.id {
+				continue
+			}
+			fv, ok := fields[ft.name]
+			if !ok {
+				continue
+			}
+			fp := reflect.New(e.Field(i).Type())
+			err := dec.unmarshalValue(fv, fp)
+			if err != nil {
+				return err
+			}
+			e.Field(i).Set(fp.Elem())
+		}
+		return nil
+	case reflect.Map:
+		items, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("cannot unmarshal %v into %v", v, e.Type())
+		}
+		mp := reflect.MakeMap(e.Type())
+		kt := e.Type().Key()
+		for k, item := range items {
+			kv := reflect.ValueOf(k)
+			if kt.Kind() != reflect.String {
+				// Keys of other types are written using %v.
+				kp := reflect.New(kt)
+				err := dec.unmarshalValue(json.Number(k), kp)
+				if err != nil {
+					return err
+				}
+				kv = kp.Elem()
+			}
+			ip := reflect.New(e.Type().Elem())
+			err := dec.unmarshalValue(item, ip)
+			if err != nil {
+				return err
+			}
+			mp.SetMapIndex(kv.Convert(kt), ip.Elem())
+		}
+		e.Set(mp)
+		return nil
+	case reflect.Slice, reflect.Array:
+		if s, ok := v.(string); ok && e.Kind() == reflect.Slice && e.Type().Elem().Kind() == reflect.Uint8 {
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return err
+			}
+			e.Set(reflect.ValueOf(b).Convert(e.Type()))
+			return nil
+		}
+		items, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("cannot unmarshal %v into %v", v, e.Type())
+		}
+		if e.Kind() == reflect.Slice {
+			e.Set(reflect.MakeSlice(e.Type(), len(items), len(items)))
+		}
+		for i, item := range items {
+			if i >= e.Len() {
+				break
+			}
+			err := dec.unmarshalValue(item, e.Index(i).Addr())
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if _, ok := p.Interface().(encoding.TextUnmarshaler); ok {
+		return dec.unmarshalValueJSON(v, p)
+	}
+	var err error
+	switch e.Kind() {
+	case reflect.Bool:
+		b, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("cannot unmarshal %v into %v", v, e.Type())
+		}
+		e.SetBool(b)
+		return nil
+	case reflect.String:
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("cannot unmarshal %v into %v", v, e.Type())
+		}
+		e.SetString(s)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		n, ok := v.(json.Number)
+		if ok {
+			i, err = strconv.ParseInt(string(n), 10, e.Type().Bits())
+		}
+		if !ok || err != nil {
+			return fmt.Errorf("cannot unmarshal %v into %v", v, e.Type())
+		}
+		e.SetInt(i)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var u uint64
+		n, ok := v.(json.Number)
+		if ok {
+			u, err = strconv.ParseUint(string(n), 10, e.Type().Bits())
+		}
+		if !ok || err != nil {
+			return fmt.Errorf("cannot unmarshal %v into %v", v, e.Type())
+		}
+		e.SetUint(u)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		n, ok := v.(json.Number)
+		if ok {
+			f, err = strconv.ParseFloat(string(n), e.Type().Bits())
+		}
+		if !ok || err != nil {
+			return fmt.Errorf("cannot unmarshal %v into %v", v, e.Type())
+		}
+		e.SetFloat(f)
+		return nil
+	}
+	return dec.unmarshalValueJSON(v, p)
+}
+
+// unmarshalValueRef sets the pointer or interface to the referenced node.
+func (dec *decoder) unmarshalValueRef(v interface{}, p reflect.Value) error {
+	var ref Ref
+	switch v := v.(type) {
+	case *sharedValue:
+		ref = dec.shared[v]
+	case Ref:
+		ref = v
+	default:
+		return fmt.Errorf("invalid reference")
+	}
+	obj, ok := dec.refmap[ref.String()]
+	if !ok || !obj.Type().AssignableTo(p.Elem().Type()) {
+		return fmt.Errorf("invalid reference %s", ref)
+	}
+	p.Elem().Set(obj)
+	return nil
+}
+
+// unmarshalValueJSON unmarshals the value using encoding/json, for the types
+// with custom unmarshalers.
+func (dec *decoder) unmarshalValueJSON(v interface{}, p reflect.Value) error {
+	v, err := sharedToValue(v, dec.shared, make(map[*sharedValue]bool))
+	if err != nil {
+		return err
+	}
+	rm, err := encodeValue(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(rm, p.Interface())
+}
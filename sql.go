@@ -0,0 +1,149 @@
+/*
+	Copyright (c) 2020 Martin Sustrik
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy
+	of this software and associated documentation files (the "Software"),
+	to deal in the Software without restriction, including without limitation
+	the rights to use, copy, modify, merge, publish, distribute, sublicense,
+	and/or sell copies of the Software, and to permit persons to whom
+	the Software is furnished to do so, subject to the following conditions:
+	The above copyright notice and this permission notice shall be included
+	in all copies or substantial portions of the Software.
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+	FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+	IN THE SOFTWARE.
+*/
+
+package grison
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// SQLSchema returns SQL statements that create tables for the master
+// structure. The layout of the tables is the same as with MarshalCSV.
+// Node IDs are the primary keys of node tables. Join tables use the ID
+// together with the index or the key as the primary key. References to
+// nodes are foreign keys. Foreign keys are added by separate ALTER TABLE
+// statements so that the tables can refer to each other in cycles.
+func SQLSchema(m interface{}) ([]byte, error) {
+	sch, err := newSchema(m)
+	if err != nil {
+		return nil, err
+	}
+	tables := relTables(sch)
+	var buf bytes.Buffer
+	writeSQLTables(&buf, tables)
+	writeSQLForeignKeys(&buf, tables)
+	return buf.Bytes(), nil
+}
+
+// MarshalSQL returns SQL statements that create tables for the master
+// structure, as SQLSchema does, and insert the graph reachable from the master
+// structure into them. Foreign keys are added after the data are inserted.
+func MarshalSQL(m interface{}, opts MarshalOpts) ([]byte, error) {
+	tables, err := marshalRelational(m, opts)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	writeSQLTables(&buf, tables)
+	for _, t := range tables {
+		if len(t.rows) == 0 {
+			continue
+		}
+		buf.WriteString("\n")
+		var cols []string
+		for _, col := range t.columns {
+			cols = append(cols, sqlQuoteIdent(col.name))
+		}
+		for _, row := range t.rows {
+			var vals []string
+			for _, v := range row {
+				vals = append(vals, sqlLiteral(v))
+			}
+			fmt.Fprintf(&buf, "INSERT INTO %s (%s) VALUES (%s);\n", sqlQuoteIdent(t.name),
+				strings.Join(cols, ", "), strings.Join(vals, ", "))
+		}
+	}
+	writeSQLForeignKeys(&buf, tables)
+	return buf.Bytes(), nil
+}
+
+func writeSQLTables(buf *bytes.Buffer, tables []*relTable) {
+	for i, t := range tables {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(buf, "CREATE TABLE %s (\n", sqlQuoteIdent(t.name))
+		var keys []string
+		for _, col := range t.columns {
+			fmt.Fprintf(buf, "    %s %s", sqlQuoteIdent(col.name), sqlType(col.kind))
+			if col.notNull {
+				buf.WriteString(" NOT NULL")
+			}
+			buf.WriteString(",\n")
+			if len(keys) < t.key {
+				keys = append(keys, sqlQuoteIdent(col.name))
+			}
+		}
+		fmt.Fprintf(buf, "    PRIMARY KEY (%s)\n);\n", strings.Join(keys, ", "))
+	}
+}
+
+func writeSQLForeignKeys(buf *bytes.Buffer, tables []*relTable) {
+	first := true
+	for _, t := range tables {
+		for _, col := range t.columns {
+			if col.refTable == "" {
+				continue
+			}
+			if first {
+				buf.WriteString("\n")
+				first = false
+			}
+			fmt.Fprintf(buf, "ALTER TABLE %s ADD FOREIGN KEY (%s) REFERENCES %s (%s);\n",
+				sqlQuoteIdent(t.name), sqlQuoteIdent(col.name),
+				sqlQuoteIdent(col.refTable), sqlQuoteIdent(relIDColumn))
+		}
+	}
+}
+
+func sqlType(kind relKind) string {
+	switch kind {
+	case relInt:
+		return "BIGINT"
+	case relUint:
+		return "NUMERIC(20)"
+	case relFloat:
+		return "DOUBLE PRECISION"
+	case relBool:
+		return "BOOLEAN"
+	}
+	return "TEXT"
+}
+
+func sqlQuoteIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+func sqlLiteral(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case bool:
+		if v {
+			return "TRUE"
+		}
+		return "FALSE"
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	}
+	return fmt.Sprintf("%v", v)
+}
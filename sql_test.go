@@ -0,0 +1,105 @@
+package grison
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestSQL(t *testing.T) {
+	type Node struct {
+		Name string
+		Next *Node
+		List []*Node
+	}
+	type Master struct {
+		Nodes []*Node
+	}
+	m := &Master{Nodes: []*Node{{Name: "it's"}, {}}}
+	m.Nodes[0].Next = m.Nodes[1]
+	m.Nodes[1].List = []*Node{m.Nodes[0], nil}
+	b, err := MarshalSQL(m, MarshalOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `CREATE TABLE "Nodes" (
+    "id" TEXT NOT NULL,
+    "Name" TEXT NOT NULL,
+    "Next" TEXT,
+    "List" BIGINT,
+    PRIMARY KEY ("id")
+);
+
+CREATE TABLE "Nodes_List" (
+    "id" TEXT NOT NULL,
+    "index" BIGINT NOT NULL,
+    "ref" TEXT,
+    PRIMARY KEY ("id", "index")
+);
+
+INSERT INTO "Nodes" ("id", "Name", "Next", "List") VALUES ('#1', 'it''s', '#2', NULL);
+INSERT INTO "Nodes" ("id", "Name", "Next", "List") VALUES ('#2', '', NULL, 2);
+
+INSERT INTO "Nodes_List" ("id", "index", "ref") VALUES ('#2', 0, '#1');
+INSERT INTO "Nodes_List" ("id", "index", "ref") VALUES ('#2', 1, NULL);
+
+ALTER TABLE "Nodes" ADD FOREIGN KEY ("Next") REFERENCES "Nodes" ("id");
+ALTER TABLE "Nodes_List" ADD FOREIGN KEY ("id") REFERENCES "Nodes" ("id");
+ALTER TABLE "Nodes_List" ADD FOREIGN KEY ("ref") REFERENCES "Nodes" ("id");
+`
+	if string(b) != expected {
+		t.Errorf("unexpected SQL.\nexpect=%s\nactual=%s", expected, b)
+	}
+	b, err = SQLSchema(&Master{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "INSERT") || !strings.Contains(string(b), "ALTER TABLE") {
+		t.Errorf("unexpected schema:\n%s", b)
+	}
+}
+
+func TestSQLTypes(t *testing.T) {
+	b, err := MarshalSQL(newGraphMaster(), MarshalOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		`"Age" BIGINT NOT NULL,`,
+		`"Weight" DOUBLE PRECISION NOT NULL,`,
+		`"Alive" BOOLEAN NOT NULL,`,
+		`"Tags" TEXT NOT NULL,`,
+		`"Prev" TEXT,`,
+		`CREATE TABLE "Nodes_Map" (`,
+		`PRIMARY KEY ("id", "key")`,
+		`VALUES ('#1', 'a <&> b', 42, 1.5, TRUE, '["x","y"]', '#2', NULL, 3, NULL, NULL, '{"N":null}', 0);`,
+		`VALUES ('#2', 'c', -1, 0, FALSE, 'null', NULL, 'Nodes:#1', NULL, 0, 2, '{"N":null}', 0);`,
+	} {
+		if !strings.Contains(string(b), s) {
+			t.Errorf("missing %s in:\n%s", s, b)
+		}
+	}
+}
+
+func TestSQLUnsigned(t *testing.T) {
+	type Node struct {
+		Small uint32
+		Big   uint64
+	}
+	type Master struct {
+		Nodes []*Node
+	}
+	b, err := MarshalSQL(&Master{Nodes: []*Node{{Small: 1, Big: math.MaxUint64}}}, MarshalOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		`"Small" BIGINT NOT NULL,`,
+		`"Big" NUMERIC(20) NOT NULL`,
+		`VALUES ('#1', 1, 18446744073709551615);`,
+	} {
+		if !strings.Contains(string(b), s) {
+			t.Errorf("missing %s in:\n%s", s, b)
+		}
+	}
+}
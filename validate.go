@@ -0,0 +1,308 @@
+/*
+	Copyright (c) 2020 Martin Sustrik
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy
+	of this software and associated documentation files (the "Software"),
+	to deal in the Software without restriction, including without limitation
+	the rights to use, copy, modify, merge, publish, distribute, sublicense,
+	and/or sell copies of the Software, and to permit persons to whom
+	the Software is furnished to do so, subject to the following conditions:
+	The above copyright notice and this permission notice shall be included
+	in all copies or substantial portions of the Software.
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+	FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+	IN THE SOFTWARE.
+*/
+
+package grison
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// ValidationError describes a problem found in a grison file.
+type ValidationError struct {
+	// Position of the problem in the file. Both line and column are 1-based,
+	// column is measured in bytes.
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Msg)
+}
+
+// Validate checks a grison file without needing the Go types it was produced
+// from. It reports dangling references, malformed references, references to
+// unknown collections and nodes that are not JSON objects. All the problems
+// are reported, ordered by their position in the file. If the file is not
+// a valid JSON, only the syntax error is reported.
+func Validate(b []byte) []ValidationError {
+	v := &validator{
+		b:     b,
+		dec:   json.NewDecoder(bytes.NewReader(b)),
+		nodes: make(map[string]map[string]bool),
+	}
+	err := v.validate()
+	if err != nil {
+		off := v.offset()
+		if serr, ok := err.(*json.SyntaxError); ok && serr.Offset > 0 {
+			// Offset points just past the offending byte.
+			off = int(serr.Offset) - 1
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = fmt.Errorf("unexpected end of input")
+		}
+		v.report(off, "%v", err)
+		return v.errs
+	}
+	for _, r := range v.refs {
+		ref, err := ParseRef(r.ref)
+		if err != nil {
+			v.report(r.offset, "%s: %s: %v", r.from, r.path, err)
+			continue
+		}
+		nodes, ok := v.nodes[ref.Type]
+		if !ok {
+			v.report(r.offset, "%s: %s: reference to unknown collection %s", r.from, r.path, ref)
+			continue
+		}
+		if !nodes[ref.ID] {
+			v.report(r.offset, "%s: %s: dangling reference %s", r.from, r.path, ref)
+		}
+	}
+	sort.SliceStable(v.errs, func(i, j int) bool {
+		if v.errs[i].Line != v.errs[j].Line {
+			return v.errs[i].Line < v.errs[j].Line
+		}
+		return v.errs[i].Column < v.errs[j].Column
+	})
+	return v.errs
+}
+
+type validator struct {
+	b     []byte
+	dec   *json.Decoder
+	nodes map[string]map[string]bool
+	refs  []validatorRef
+	errs  []ValidationError
+}
+
+type validatorRef struct {
+	offset int
+	from   Ref
+	path   string
+	ref    string
+}
+
+// offset returns the position of the next token.
+func (v *validator) offset() int {
+	off := int(v.dec.InputOffset())
+	for off < len(v.b) {
+		switch v.b[off] {
+		case ' ', '\t', '\r', '\n', ',', ':':
+			off++
+			continue
+		}
+		break
+	}
+	return off
+}
+
+func (v *validator) report(off int, format string, a ...interface{}) {
+	line, col := 1, 1
+	for i := 0; i < off && i < len(v.b); i++ {
+		if v.b[i] == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	v.errs = append(v.errs, ValidationError{Line: line, Column: col, Msg: fmt.Sprintf(format, a...)})
+}
+
+func (v *validator) validate() error {
+	off := v.offset()
+	tok, err := v.dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != json.Delim('{') {
+		v.report(off, "grison document must be an object")
+		return v.skip(tok)
+	}
+	for v.dec.More() {
+		tok, err = v.dec.Token()
+		if err != nil {
+			return err
+		}
+		tp := tok.(string)
+		off = v.offset()
+		tok, err = v.dec.Token()
+		if err != nil {
+			return err
+		}
+		if tok != json.Delim('{') {
+			v.report(off, "collection %s is not an object", tp)
+			err = v.skip(tok)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if _, ok := v.nodes[tp]; ok {
+			v.report(off, "duplicate collection %s", tp)
+		} else {
+			v.nodes[tp] = make(map[string]bool)
+		}
+		err = v.validateCollection(tp)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = v.dec.Token()
+	if err != nil {
+		return err
+	}
+	off = v.offset()
+	_, err = v.dec.Token()
+	if err != io.EOF {
+		v.report(off, "unexpected data after the end of the document")
+	}
+	return nil
+}
+
+func (v *validator) validateCollection(tp string) error {
+	for v.dec.More() {
+		keyoff := v.offset()
+		tok, err := v.dec.Token()
+		if err != nil {
+			return err
+		}
+		ref := Ref{Type: tp, ID: tok.(string)}
+		off := v.offset()
+		tok, err = v.dec.Token()
+		if err != nil {
+			return err
+		}
+		if v.nodes[tp][ref.ID] {
+			v.report(keyoff, "duplicate node %s", ref)
+		}
+		v.nodes[tp][ref.ID] = true
+		if tok != json.Delim('{') {
+			v.report(off, "node %s is not an object", ref)
+			err = v.skip(tok)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		for v.dec.More() {
+			tok, err = v.dec.Token()
+			if err != nil {
+				return err
+			}
+			err = v.validateValue(ref, tok.(string))
+			if err != nil {
+				return err
+			}
+		}
+		_, err = v.dec.Token()
+		if err != nil {
+			return err
+		}
+	}
+	_, err := v.dec.Token()
+	return err
+}
+
+// validateValue looks for references in the next value.
+func (v *validator) validateValue(from Ref, path string) error {
+	off := v.offset()
+	tok, err := v.dec.Token()
+	if err != nil {
+		return err
+	}
+	switch tok {
+	case json.Delim('['):
+		for i := 0; v.dec.More(); i++ {
+			err = v.validateValue(from, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return err
+			}
+		}
+		_, err = v.dec.Token()
+		return err
+	case json.Delim('{'):
+		keys := 0
+		hasRef := false
+		var ref interface{}
+		for v.dec.More() {
+			tok, err = v.dec.Token()
+			if err != nil {
+				return err
+			}
+			key := tok.(string)
+			keys++
+			if key == "$ref" {
+				hasRef = true
+				tok, err = v.dec.Token()
+				if err != nil {
+					return err
+				}
+				ref = tok
+				err = v.skip(tok)
+			} else {
+				err = v.validateValue(from, path+"."+key)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		_, err = v.dec.Token()
+		if err != nil {
+			return err
+		}
+		if !hasRef {
+			return nil
+		}
+		s, ok := ref.(string)
+		if keys != 1 || !ok {
+			v.report(off, "%s: %s: malformed reference", from, path)
+			return nil
+		}
+		v.refs = append(v.refs, validatorRef{offset: off, from: from, path: path, ref: s})
+	}
+	return nil
+}
+
+// skip skips the rest of the value that starts with the token.
+func (v *validator) skip(tok json.Token) error {
+	if tok != json.Delim('{') && tok != json.Delim('[') {
+		return nil
+	}
+	depth := 1
+	for depth > 0 {
+		tok, err := v.dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+	return nil
+}
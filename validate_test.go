@@ -0,0 +1,47 @@
+package grison
+
+import (
+	"testing"
+)
+
+func ValidateTest(t *testing.T, b string, expected ...string) {
+	errs := Validate([]byte(b))
+	if len(errs) != len(expected) {
+		t.Errorf("unexpected validation result for %s: %v", b, errs)
+		return
+	}
+	for i, err := range errs {
+		if err.Error() != expected[i] {
+			t.Errorf("unexpected validation error.\nexpect=%s\nactual=%s", expected[i], err.Error())
+		}
+	}
+}
+
+func TestValidateOK(t *testing.T) {
+	ValidateTest(t, exampleDoc)
+	ValidateTest(t, `{}`)
+}
+
+func TestValidateRefs(t *testing.T) {
+	ValidateTest(t, `{"Node":{
+    "#1":{"A":{"$ref":"Node:#9"}},
+    "#2":{"B":[1,{"$ref":"Foo:#1"}],"C":{"x":{"$ref":"nocolon"}}},
+    "#3":{"D":{"$ref":"Node:#1","E":1},"F":{"$ref":3}}
+}}`,
+		`2:15: Node:#1: A: dangling reference Node:#9`,
+		`3:18: Node:#2: B[1]: reference to unknown collection Foo:#1`,
+		`3:46: Node:#2: C.x: malformed reference "nocolon"`,
+		`4:15: Node:#3: D: malformed reference`,
+		`4:44: Node:#3: F: malformed reference`)
+}
+
+func TestValidateStructure(t *testing.T) {
+	ValidateTest(t, `[]`, `1:1: grison document must be an object`)
+	ValidateTest(t, `{"A":3,"B":{"#1":[],"#2":{}},"C":{"#1":{"X":{"$ref":"B:#1"}}}}`,
+		`1:6: collection A is not an object`,
+		`1:18: node B:#1 is not an object`)
+	ValidateTest(t, `{"A":{"#1":{},"#1":{}}}`, `1:15: duplicate node A:#1`)
+	ValidateTest(t, `{"A":{"#1":{}}} x`, `1:17: unexpected data after the end of the document`)
+	ValidateTest(t, "{\"A\":\n{\"#1\":{\"X\":tru}}}", `2:15: invalid character '}' in literal true (expecting 'e')`)
+	ValidateTest(t, `{"A":{`, `1:6: unexpected end of JSON input`)
+}
@@ -0,0 +1,317 @@
+/*
+	Copyright (c) 2020 Martin Sustrik
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy
+	of this software and associated documentation files (the "Software"),
+	to deal in the Software without restriction, including without limitation
+	the rights to use, copy, modify, merge, publish, distribute, sublicense,
+	and/or sell copies of the Software, and to permit persons to whom
+	the Software is furnished to do so, subject to the following conditions:
+	The above copyright notice and this permission notice shall be included
+	in all copies or substantial portions of the Software.
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+	FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+	IN THE SOFTWARE.
+*/
+
+package grison
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// fieldKind classifies node fields for the formats that treat references
+// and scalar values differently from the rest of the data.
+type fieldKind int
+
+const (
+	// Bool, number or string.
+	scalarField fieldKind = iota
+	// Pointer to a node or an interface.
+	refField
+	// Slice or array of references.
+	refListField
+	// Map of references.
+	refMapField
+	// Anything else. Such fields are stored as JSON.
+	otherField
+)
+
+// walkedField describes a single field of a node type.
+type walkedField struct {
+	name      string
+	index     int
+	kind      fieldKind
+	tp        reflect.Type
+	omitEmpty bool
+}
+
+// walkedNode is a node found while walking the master structure.
+type walkedNode struct {
+	ref Ref
+	// The node itself, i.e. the struct, not the pointer.
+	val reflect.Value
+}
+
+// walker lists all the nodes reachable from the master structure. IDs are
+// assigned to the nodes exactly the same way as Marshal would assign them.
+type walker struct {
+	enc   *encoder
+	nodes []walkedNode
+}
+
+func newWalker(m interface{}, opts MarshalOpts) (*walker, error) {
+	enc, err := walkInternal(m, opts)
+	if err != nil {
+		return nil, err
+	}
+	w := &walker{enc: enc}
+	seen := make(map[Ref]bool)
+	for _, p := range enc.nodes {
+		ref := Ref{Type: enc.types[p.Elem().Type()], ID: enc.ids[p.Interface()]}
+		// With ContentIDs, identical nodes share the same ID.
+		if seen[ref] {
+			continue
+		}
+		seen[ref] = true
+		w.nodes = append(w.nodes, walkedNode{ref: ref, val: p.Elem()})
+	}
+	return w, nil
+}
+
+// walkInternal assigns IDs to the nodes the same way as marshalInternal does,
+// but without producing JSON. With ContentIDs option the IDs are derived from
+// the JSON, so marshalInternal is used instead.
+func walkInternal(m interface{}, opts MarshalOpts) (*encoder, error) {
+	if opts.ContentIDs {
+		return marshalInternal(m, opts)
+	}
+	enc, err := newEncoder(m, opts)
+	if err != nil {
+		return nil, err
+	}
+	ms := reflect.ValueOf(m).Elem()
+	for i := 0; i < ms.NumField(); i++ {
+		if getFieldTags(ms.Type().Field(i)).ignore {
+			continue
+		}
+		fld := ms.Field(i)
+		for j := 0; j < fld.Len(); j++ {
+			err = enc.visit(fld.Index(j))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return enc, nil
+}
+
+// visit finds the nodes reachable from the value, in the same order as
+// marshalAny does.
+func (enc *encoder) visit(obj reflect.Value) error {
+	if obj.CanAddr() {
+		if _, ok := obj.Addr().Interface().(json.Marshaler); ok {
+			return nil
+		}
+	}
+	switch obj.Kind() {
+	case reflect.Ptr:
+		if obj.IsNil() {
+			return nil
+		}
+		if !enc.isNodeType(obj.Elem().Type()) {
+			return enc.visit(obj.Elem())
+		}
+	case reflect.Interface:
+		if obj.IsNil() {
+			return nil
+		}
+		tp := obj.Elem().Elem().Type()
+		if !enc.isNodeType(tp) {
+			return fmt.Errorf("object behind an interface is not a node, it is %v", tp)
+		}
+		obj = obj.Elem()
+	case reflect.Struct:
+		return enc.visitStruct(obj)
+	case reflect.Slice, reflect.Array:
+		if obj.Kind() == reflect.Slice && (obj.IsNil() || obj.Type() == reflect.TypeOf([]byte{})) {
+			return nil
+		}
+		for i := 0; i < obj.Len(); i++ {
+			err := enc.visit(obj.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		for _, k := range sortedMapKeys(obj) {
+			err := enc.visit(obj.MapIndex(k))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return nil
+	}
+	// The value is a pointer to a node.
+	_, exists, err := enc.allocate(obj)
+	if err != nil || exists {
+		return err
+	}
+	enc.nodes = append(enc.nodes, obj)
+	return enc.visitStruct(obj.Elem())
+}
+
+func (enc *encoder) visitStruct(obj reflect.Value) error {
+	tp := obj.Type()
+	for i := 0; i < obj.NumField(); i++ {
+		ft := getFieldTags(tp.Field(i))
+		if ft.ignore || ft.omitEmpty && obj.Field(i).IsZero() || ft.id && enc.isNodeType(tp) {
+			continue
+		}
+		err := enc.visit(obj.Field(i))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ref returns the reference stored in a pointer or interface value.
+// If the value is nil, false is returned.
+func (w *walker) ref(v reflect.Value) (Ref, bool) {
+	if v.IsNil() {
+		return Ref{}, false
+	}
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	return Ref{Type: w.enc.types[v.Elem().Type()], ID: w.enc.ids[v.Interface()]}, true
+}
+
+// marshalValue returns JSON representation of the value, as Marshal would produce it.
+func (w *walker) marshalValue(v reflect.Value) ([]byte, error) {
+	return w.enc.marshalAny(v)
+}
+
+// fields returns the fields of the node, omitting the empty ones if so requested.
+func (w *walker) fields(node walkedNode) []walkedField {
+	var flds []walkedField
+	for _, f := range nodeFields(node.val.Type(), w.enc.types) {
+		if f.omitEmpty && node.val.Field(f.index).IsZero() {
+			continue
+		}
+		flds = append(flds, f)
+	}
+	return flds
+}
+
+// nodeFields returns the fields of the node type that are not ignored.
+func nodeFields(tp reflect.Type, nodeTypes map[reflect.Type]string) []walkedField {
+	var flds []walkedField
+	for i := 0; i < tp.NumField(); i++ {
+		ft := getFieldTags(tp.Field(i))
+		// ID fields are represented by the IDs of the nodes.
+		if ft.ignore || ft.id {
+			continue
+		}
+		ftp := tp.Field(i).Type
+		flds = append(flds, walkedField{
+			name:      ft.name,
+			index:     i,
+			kind:      getFieldKind(ftp, nodeTypes),
+			tp:        ftp,
+			omitEmpty: ft.omitEmpty,
+		})
+	}
+	return flds
+}
+
+func getFieldKind(tp reflect.Type, nodeTypes map[reflect.Type]string) fieldKind {
+	if isRefType(tp, nodeTypes) {
+		return refField
+	}
+	if hasCustomMarshaler(tp) {
+		return otherField
+	}
+	switch tp.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return scalarField
+	case reflect.Slice, reflect.Array:
+		if isRefType(tp.Elem(), nodeTypes) {
+			return refListField
+		}
+	case reflect.Map:
+		if isRefType(tp.Elem(), nodeTypes) {
+			return refMapField
+		}
+	}
+	return otherField
+}
+
+func isRefType(tp reflect.Type, nodeTypes map[reflect.Type]string) bool {
+	if tp.Kind() == reflect.Interface {
+		return true
+	}
+	if tp.Kind() != reflect.Ptr {
+		return false
+	}
+	_, ok := nodeTypes[tp.Elem()]
+	return ok
+}
+
+var marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// hasCustomMarshaler mirrors the check in encoder.marshalAny.
+func hasCustomMarshaler(tp reflect.Type) bool {
+	return reflect.PtrTo(tp).Implements(marshalerType)
+}
+
+// schema describes the node types of a master structure. It is used by the
+// formats that need the Go types to decode the data.
+type schema struct {
+	// Collection names, keyed by the node types.
+	nodeTypes map[reflect.Type]string
+	// Node types, keyed by the collection name.
+	types map[string]reflect.Type
+	// Fields of the node types, keyed by the collection name.
+	fields map[string][]walkedField
+	// Collections with omitempty tag.
+	omitEmpty []string
+}
+
+func newSchema(m interface{}) (*schema, error) {
+	tps, nms, oe, err := scrapeMasterStruct(m, false)
+	if err != nil {
+		return nil, err
+	}
+	s := &schema{nodeTypes: tps, types: nms, fields: make(map[string][]walkedField), omitEmpty: oe}
+	for nm, tp := range nms {
+		s.fields[nm] = nodeFields(tp, tps)
+	}
+	return s, nil
+}
+
+// newDocument creates an empty document with all the collections, as
+// Marshal would produce it for a master structure without any nodes.
+func (s *schema) newDocument() *Document {
+	doc := NewDocument()
+	for nm := range s.types {
+		doc.AddType(nm)
+	}
+	for _, nm := range s.omitEmpty {
+		delete(doc.nodes, nm)
+	}
+	return doc
+}
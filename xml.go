@@ -0,0 +1,366 @@
+/*
+	Copyright (c) 2020 Martin Sustrik
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy
+	of this software and associated documentation files (the "Software"),
+	to deal in the Software without restriction, including without limitation
+	the rights to use, copy, modify, merge, publish, distribute, sublicense,
+	and/or sell copies of the Software, and to permit persons to whom
+	the Software is furnished to do so, subject to the following conditions:
+	The above copyright notice and this permission notice shall be included
+	in all copies or substantial portions of the Software.
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+	FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+	IN THE SOFTWARE.
+*/
+
+package grison
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// xmlElem is a generic XML element used both to produce and to parse XML.
+type xmlElem struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Content  string     `xml:",chardata"`
+	Children []xmlElem  `xml:",any"`
+}
+
+func (e *xmlElem) attr(name string) (string, bool) {
+	for _, a := range e.Attrs {
+		if a.Name.Local == name {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+const (
+	xmlRootElem = "grison"
+	xmlNodeElem = "node"
+	xmlItemElem = "item"
+)
+
+// MarshalXML converts the graph reachable from the master structure into XML.
+// Each collection becomes an element containing <node> elements, each with
+// an id attribute. Fields of a node become child elements, named the same way
+// as in JSON. Scalar fields contain the value as text, references carry an
+// idref attribute. Slices and maps of references contain an <item> element
+// for each reference, map items having a key attribute in addition. Other
+// fields are stored as JSON text. Strings containing characters that XML 1.0
+// cannot hold are stored in base64 and marked with an encoding attribute. The
+// attributes are declared in the DTD so that XML tools can follow the
+// references. Prefix and Indent options are honored.
+func MarshalXML(m interface{}, opts MarshalOpts) ([]byte, error) {
+	w, err := newWalker(m, opts)
+	if err != nil {
+		return nil, err
+	}
+	colls := make(map[string]*xmlElem)
+	refElems := make(map[string]bool)
+	encElems := make(map[string]bool)
+	for _, wn := range w.nodes {
+		coll, ok := colls[wn.ref.Type]
+		if !ok {
+			coll = &xmlElem{XMLName: xml.Name{Local: wn.ref.Type}}
+			colls[wn.ref.Type] = coll
+		}
+		node := xmlElem{
+			XMLName: xml.Name{Local: xmlNodeElem},
+			Attrs:   []xml.Attr{{Name: xml.Name{Local: "id"}, Value: xmlID(wn.ref)}},
+		}
+		for _, f := range w.fields(wn) {
+			fv := wn.val.Field(f.index)
+			fe := xmlElem{XMLName: xml.Name{Local: f.name}}
+			switch f.kind {
+			case scalarField:
+				fe.Content, err = scalarText(fv)
+				if err != nil {
+					return nil, err
+				}
+				if !xmlValidText(fe.Content) {
+					fe.Content = base64.StdEncoding.EncodeToString([]byte(fe.Content))
+					fe.Attrs = []xml.Attr{{Name: xml.Name{Local: "encoding"}, Value: "base64"}}
+					encElems[f.name] = true
+				}
+			case refField:
+				ref, ok := w.ref(fv)
+				if !ok {
+					continue
+				}
+				fe.Attrs = xmlIDRef(ref)
+				refElems[f.name] = true
+			case refListField:
+				if fv.Kind() == reflect.Slice && fv.IsNil() {
+					continue
+				}
+				for i := 0; i < fv.Len(); i++ {
+					item := xmlElem{XMLName: xml.Name{Local: xmlItemElem}}
+					if ref, ok := w.ref(fv.Index(i)); ok {
+						item.Attrs = xmlIDRef(ref)
+					}
+					fe.Children = append(fe.Children, item)
+				}
+			case refMapField:
+				if fv.IsNil() {
+					continue
+				}
+				for _, k := range sortedMapKeys(fv) {
+					key := fmt.Sprintf("%v", k.Interface())
+					if !xmlValidText(key) {
+						return nil, fmt.Errorf("map key %q of %s.%s cannot be represented in XML", key, wn.ref, f.name)
+					}
+					item := xmlElem{
+						XMLName: xml.Name{Local: xmlItemElem},
+						Attrs:   []xml.Attr{{Name: xml.Name{Local: "key"}, Value: key}},
+					}
+					if ref, ok := w.ref(fv.MapIndex(k)); ok {
+						item.Attrs = append(item.Attrs, xmlIDRef(ref)...)
+					}
+					fe.Children = append(fe.Children, item)
+				}
+			default:
+				b, err := w.marshalValue(fv)
+				if err != nil {
+					return nil, err
+				}
+				fe.Content = string(b)
+			}
+			node.Children = append(node.Children, fe)
+		}
+		coll.Children = append(coll.Children, node)
+	}
+	root := xmlElem{XMLName: xml.Name{Local: xmlRootElem}}
+	var tps []string
+	for tp := range colls {
+		tps = append(tps, tp)
+	}
+	sort.Strings(tps)
+	for _, tp := range tps {
+		root.Children = append(root.Children, *colls[tp])
+	}
+	var b []byte
+	if opts.Prefix == "" && opts.Indent == "" {
+		b, err = xml.Marshal(root)
+	} else {
+		b, err = xml.MarshalIndent(root, opts.Prefix, opts.Indent)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	fmt.Fprintf(&buf, "<!DOCTYPE %s [\n", xmlRootElem)
+	fmt.Fprintf(&buf, "  <!ATTLIST %s id ID #REQUIRED>\n", xmlNodeElem)
+	fmt.Fprintf(&buf, "  <!ATTLIST %s key CDATA #IMPLIED idref IDREF #IMPLIED>\n", xmlItemElem)
+	var names []string
+	for name := range refElems {
+		if name != xmlItemElem {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&buf, "  <!ATTLIST %s idref IDREF #IMPLIED>\n", name)
+	}
+	names = names[:0]
+	for name := range encElems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&buf, "  <!ATTLIST %s encoding (base64) #IMPLIED>\n", name)
+	}
+	buf.WriteString("]>\n")
+	buf.Write(b)
+	return buf.Bytes(), nil
+}
+
+// UnmarshalXML fills in the master structure from XML produced by MarshalXML.
+func UnmarshalXML(b []byte, m interface{}) error {
+	sch, err := newSchema(m)
+	if err != nil {
+		return err
+	}
+	var root xmlElem
+	err = xml.Unmarshal(b, &root)
+	if err != nil {
+		return err
+	}
+	if root.XMLName.Local != xmlRootElem {
+		return fmt.Errorf("unexpected root element <%s>", root.XMLName.Local)
+	}
+	doc := sch.newDocument()
+	for _, coll := range root.Children {
+		tp := coll.XMLName.Local
+		if _, ok := sch.types[tp]; !ok {
+			return fmt.Errorf("unknown node type %s", tp)
+		}
+		for _, ne := range coll.Children {
+			if ne.XMLName.Local != xmlNodeElem {
+				return fmt.Errorf("unexpected element <%s> in %s", ne.XMLName.Local, tp)
+			}
+			id, _ := ne.attr("id")
+			ref, err := parseXMLID(id)
+			if err != nil {
+				return err
+			}
+			if ref.Type != tp {
+				return fmt.Errorf("node %s in collection %s", ref, tp)
+			}
+			n, err := doc.AddNode(ref.Type, ref.ID)
+			if err != nil {
+				return err
+			}
+			for _, fe := range ne.Children {
+				f, ok := findField(sch.fields[tp], fe.XMLName.Local)
+				if !ok {
+					return fmt.Errorf("unknown field %s in %s", fe.XMLName.Local, tp)
+				}
+				v, err := xmlFieldValue(&fe, f)
+				if err != nil {
+					return fmt.Errorf("field %s of %s: %v", f.name, ref, err)
+				}
+				err = n.SetValue(f.name, v)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return UnmarshalDocument(doc, m, UnmarshalOpts{})
+}
+
+// xmlFieldValue converts a field element into a value suitable for Node.SetValue.
+func xmlFieldValue(fe *xmlElem, f walkedField) (interface{}, error) {
+	switch f.kind {
+	case scalarField:
+		s := fe.Content
+		if enc, ok := fe.attr("encoding"); ok {
+			if enc != "base64" {
+				return nil, fmt.Errorf("unknown encoding %q", enc)
+			}
+			b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+			if err != nil {
+				return nil, err
+			}
+			s = string(b)
+		}
+		return parseScalar(s, f.tp)
+	case refField:
+		return xmlRefValue(fe)
+	case refListField:
+		l := []interface{}{}
+		for _, item := range fe.Children {
+			v, err := xmlRefValue(&item)
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, v)
+		}
+		return l, nil
+	case refMapField:
+		mv := make(map[string]interface{})
+		for _, item := range fe.Children {
+			key, ok := item.attr("key")
+			if !ok {
+				return nil, fmt.Errorf("map item without a key")
+			}
+			v, err := xmlRefValue(&item)
+			if err != nil {
+				return nil, err
+			}
+			mv[key] = v
+		}
+		return mv, nil
+	}
+	return decodeValue([]byte(fe.Content))
+}
+
+// xmlRefValue returns the reference in the idref attribute or nil if there's none.
+func xmlRefValue(e *xmlElem) (interface{}, error) {
+	idref, ok := e.attr("idref")
+	if !ok {
+		return nil, nil
+	}
+	return parseXMLID(idref)
+}
+
+// xmlValidText reports whether s consists only of characters allowed in XML 1.0.
+func xmlValidText(s string) bool {
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			return false
+		}
+		if !(r == '\t' || r == '\n' || r == '\r' || r >= 0x20 && r <= 0xD7FF ||
+			r >= 0xE000 && r <= 0xFFFD || r >= 0x10000 && r <= 0x10FFFF) {
+			return false
+		}
+		i += size
+	}
+	return true
+}
+
+func xmlIDRef(ref Ref) []xml.Attr {
+	return []xml.Attr{{Name: xml.Name{Local: "idref"}, Value: xmlID(ref)}}
+}
+
+// xmlID converts the reference into a valid XML ID. Node IDs may contain
+// characters that are not allowed in XML names, such as '#'. Such characters
+// are escaped as '_' followed by two hex digits per byte. The type and the
+// escaped ID are separated by a dot.
+func xmlID(ref Ref) string {
+	var sb strings.Builder
+	sb.WriteString(ref.Type)
+	sb.WriteByte('.')
+	for i := 0; i < len(ref.ID); i++ {
+		c := ref.ID[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "_%02x", c)
+		}
+	}
+	return sb.String()
+}
+
+// parseXMLID is the inverse of xmlID.
+func parseXMLID(s string) (Ref, error) {
+	parts := strings.SplitN(s, ".", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return Ref{}, fmt.Errorf("malformed XML ID %q", s)
+	}
+	var id []byte
+	for i := 0; i < len(parts[1]); i++ {
+		c := parts[1][i]
+		if c != '_' {
+			id = append(id, c)
+			continue
+		}
+		if i+2 >= len(parts[1]) {
+			return Ref{}, fmt.Errorf("malformed XML ID %q", s)
+		}
+		b, err := strconv.ParseUint(parts[1][i+1:i+3], 16, 8)
+		if err != nil {
+			return Ref{}, fmt.Errorf("malformed XML ID %q", s)
+		}
+		id = append(id, byte(b))
+		i += 2
+	}
+	return Ref{Type: parts[0], ID: string(id)}, nil
+}
@@ -0,0 +1,110 @@
+package grison
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestXMLRoundTrip(t *testing.T) {
+	m := newGraphMaster()
+	b, err := MarshalXML(m, MarshalOpts{Indent: "  "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m2 graphMaster
+	err = UnmarshalXML(b, &m2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j1, err := Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j2, err := Marshal(&m2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(j1) != string(j2) {
+		t.Errorf("unexpected round trip result.\nexpect=%s\nactual=%s", j1, j2)
+	}
+}
+
+func TestXMLOutput(t *testing.T) {
+	b, err := MarshalXML(newGraphMaster(), MarshalOpts{Indent: "  "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		`<!ATTLIST node id ID #REQUIRED>`,
+		`<!ATTLIST Next idref IDREF #IMPLIED>`,
+		`<node id="Nodes._231">`,
+		`<Name>a &lt;&amp;&gt; b</Name>`,
+		`<Next idref="Nodes._232"></Next>`,
+		`<item idref="Nodes._233"></item>`,
+		`<item key="none"></item>`,
+		`<Nested>{&#34;N&#34;:{&#34;$ref&#34;:&#34;Nodes:#2&#34;}}</Nested>`,
+	} {
+		if !strings.Contains(string(b), s) {
+			t.Errorf("missing %s in:\n%s", s, b)
+		}
+	}
+}
+
+func TestXMLInvalidChars(t *testing.T) {
+	m := newGraphMaster()
+	m.Nodes[0].Name = "a\x01b"
+	m.Nodes[1].Name = "c\x1bd\ufffe"
+	m.Nodes[2].Tags = []string{"\x02"}
+	b, err := MarshalXML(m, MarshalOpts{Indent: "  "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		`<!ATTLIST Name encoding (base64) #IMPLIED>`,
+		`<Name encoding="base64">YQFi</Name>`,
+	} {
+		if !strings.Contains(string(b), s) {
+			t.Errorf("missing %s in:\n%s", s, b)
+		}
+	}
+	var m2 graphMaster
+	err = UnmarshalXML(b, &m2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, n := range m.Nodes {
+		if m2.Nodes[i].Name != n.Name {
+			t.Errorf("expected name %q, got %q", n.Name, m2.Nodes[i].Name)
+		}
+	}
+	if len(m2.Nodes[2].Tags) != 1 || m2.Nodes[2].Tags[0] != "\x02" {
+		t.Errorf("unexpected tags %q", m2.Nodes[2].Tags)
+	}
+	m.Nodes[1].Map["\x01"] = nil
+	_, err = MarshalXML(m, MarshalOpts{})
+	if err == nil {
+		t.Errorf("expected an error for an invalid map key")
+	}
+}
+
+func TestXMLID(t *testing.T) {
+	for _, ref := range []Ref{{"A", "#1"}, {"A", "x.y_z"}, {"A", ""}, {"A", "čau"}} {
+		id := xmlID(ref)
+		if strings.Trim(id, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-._") != "" {
+			t.Errorf("invalid XML ID %s", id)
+		}
+		ref2, err := parseXMLID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ref2 != ref {
+			t.Errorf("unexpected ref %v, expected %v", ref2, ref)
+		}
+	}
+	for _, s := range []string{"A", "A._2", "A._zz"} {
+		_, err := parseXMLID(s)
+		if err == nil {
+			t.Errorf("malformed XML ID %s accepted", s)
+		}
+	}
+}
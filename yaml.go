@@ -0,0 +1,790 @@
+/*
+	Copyright (c) 2020 Martin Sustrik
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy
+	of this software and associated documentation files (the "Software"),
+	to deal in the Software without restriction, including without limitation
+	the rights to use, copy, modify, merge, publish, distribute, sublicense,
+	and/or sell copies of the Software, and to permit persons to whom
+	the Software is furnished to do so, subject to the following conditions:
+	The above copyright notice and this permission notice shall be included
+	in all copies or substantial portions of the Software.
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+	LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+	FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+	IN THE SOFTWARE.
+*/
+
+package grison
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// MarshalYAML converts the graph reachable from the master structure into
+// YAML. Instead of {"$ref": ...} objects, references are expressed using
+// YAML anchors and aliases. Given that an alias must follow its anchor,
+// a node is written out in full at the place where it is first encountered,
+// which may be inside another node. The collections then refer to such
+// nodes using aliases. To keep the output from growing quadratically with
+// long chains of references, nodes are nested at most maxYAMLNesting levels
+// deep. Deeper references are written as {"$ref": ...} mappings and the
+// nodes are written out in their collections.
+func MarshalYAML(m interface{}, opts MarshalOpts) ([]byte, error) {
+	doc, err := MarshalDocument(m, opts)
+	if err != nil {
+		return nil, err
+	}
+	return doc.MarshalYAML()
+}
+
+// MarshalYAML converts the document into YAML. See MarshalYAML function for details.
+func (d *Document) MarshalYAML() ([]byte, error) {
+	enc := &yamlEncoder{
+		doc:     d,
+		anchors: make(map[Ref]string),
+		used:    make(map[string]bool),
+	}
+	for _, tp := range d.Types() {
+		enc.buf.WriteString(yamlQuote(tp))
+		enc.buf.WriteString(":")
+		nodes := d.Nodes(tp)
+		if len(nodes) == 0 {
+			enc.buf.WriteString(" {}\n")
+			continue
+		}
+		enc.buf.WriteString("\n")
+		for _, n := range nodes {
+			enc.indent(2)
+			enc.buf.WriteString(yamlQuote(n.ID()))
+			enc.buf.WriteString(":")
+			err := enc.writeValue(n.Ref(), 2)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return enc.buf.Bytes(), nil
+}
+
+// UnmarshalYAML fills in the master structure from YAML produced by MarshalYAML.
+// Nodes are identified by the anchors. The anchor names are arbitrary, the node
+// IDs are taken from the keys of the collections.
+func UnmarshalYAML(b []byte, m interface{}) error {
+	doc, err := ParseYAMLDocument(b)
+	if err != nil {
+		return err
+	}
+	return UnmarshalDocument(doc, m, UnmarshalOpts{})
+}
+
+// ParseYAMLDocument parses YAML produced by MarshalYAML into a document.
+func ParseYAMLDocument(b []byte) (*Document, error) {
+	p, err := newYAMLParser(b)
+	if err != nil {
+		return nil, err
+	}
+	v, err := p.parseDocument()
+	if err != nil {
+		return nil, err
+	}
+	return documentFromShared(v)
+}
+
+// maxYAMLNesting is the maximum number of nodes nested in each other.
+const maxYAMLNesting = 8
+
+type yamlEncoder struct {
+	doc     *Document
+	buf     bytes.Buffer
+	anchors map[Ref]string
+	used    map[string]bool
+	// Number of nodes being written.
+	depth int
+}
+
+func (enc *yamlEncoder) indent(n int) {
+	enc.buf.WriteString(strings.Repeat(" ", n))
+}
+
+// writeValue writes the value following "key:" or "-" at the specified indentation.
+func (enc *yamlEncoder) writeValue(v interface{}, indent int) error {
+	switch v := v.(type) {
+	case Ref:
+		if anchor, ok := enc.anchors[v]; ok {
+			fmt.Fprintf(&enc.buf, " *%s\n", anchor)
+			return nil
+		}
+		n := enc.doc.Resolve(v)
+		if n == nil {
+			return fmt.Errorf("dangling reference %s", v)
+		}
+		if enc.depth >= maxYAMLNesting {
+			enc.buf.WriteString("\n")
+			enc.indent(indent + 2)
+			fmt.Fprintf(&enc.buf, "$ref: %s\n", yamlQuote(v.String()))
+			return nil
+		}
+		anchor := enc.anchor(v)
+		fmt.Fprintf(&enc.buf, " &%s", anchor)
+		names := n.FieldNames()
+		if len(names) == 0 {
+			enc.buf.WriteString(" {}\n")
+			return nil
+		}
+		enc.buf.WriteString("\n")
+		enc.depth++
+		defer func() { enc.depth-- }()
+		for _, name := range names {
+			fv, err := n.Value(name)
+			if err != nil {
+				return err
+			}
+			enc.indent(indent + 2)
+			enc.buf.WriteString(yamlQuote(name))
+			enc.buf.WriteString(":")
+			err = enc.writeValue(fv, indent+2)
+			if err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		if len(v) == 0 {
+			enc.buf.WriteString(" {}\n")
+			return nil
+		}
+		enc.buf.WriteString("\n")
+		for _, k := range sortedKeys(v) {
+			enc.indent(indent + 2)
+			enc.buf.WriteString(yamlQuote(k))
+			enc.buf.WriteString(":")
+			err := enc.writeValue(v[k], indent+2)
+			if err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			enc.buf.WriteString(" []\n")
+			return nil
+		}
+		enc.buf.WriteString("\n")
+		for _, item := range v {
+			enc.indent(indent + 2)
+			enc.buf.WriteString("-")
+			err := enc.writeValue(item, indent+2)
+			if err != nil {
+				return err
+			}
+		}
+	case nil:
+		enc.buf.WriteString(" null\n")
+	case bool:
+		fmt.Fprintf(&enc.buf, " %t\n", v)
+	case json.Number:
+		fmt.Fprintf(&enc.buf, " %s\n", v)
+	case string:
+		fmt.Fprintf(&enc.buf, " %s\n", yamlQuote(v))
+	default:
+		return fmt.Errorf("unexpected value %v", v)
+	}
+	return nil
+}
+
+// anchor generates a unique anchor name for the node.
+func (enc *yamlEncoder) anchor(ref Ref) string {
+	var sb strings.Builder
+	for _, r := range ref.Type + "_" + ref.ID {
+		if r < utf8.RuneSelf && (r == '_' || r == '-' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			sb.WriteRune(r)
+		}
+	}
+	anchor := sb.String()
+	for i := 2; enc.used[anchor]; i++ {
+		anchor = fmt.Sprintf("%s_%d", sb.String(), i)
+	}
+	enc.used[anchor] = true
+	enc.anchors[ref] = anchor
+	return anchor
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var yamlNumber = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$`)
+
+// yamlQuote returns the string as a plain scalar if it can't be mistaken
+// for anything else, double-quoted otherwise.
+func yamlQuote(s string) string {
+	plain := s != "" && s == strings.TrimSpace(s) && !yamlNumber.MatchString(s) &&
+		!strings.ContainsAny(s[:1], "-?:,[]{}#&*!|>'\"%@`") &&
+		!strings.Contains(s, ": ") && !strings.Contains(s, " #") && !strings.HasSuffix(s, ":")
+	if plain {
+		if _, ok := yamlResolvePlain(s).(string); !ok {
+			plain = false
+		}
+	}
+	if plain {
+		for _, r := range s {
+			if r < ' ' || r == 0x7f || r == '\ufeff' {
+				plain = false
+				break
+			}
+		}
+	}
+	if plain {
+		return s
+	}
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// yamlParser parses the subset of YAML that is useful for grison files:
+// block and flow mappings and sequences, plain and quoted scalars, literal
+// and folded block scalars, anchors, aliases and comments. Tags, complex
+// keys, multi-line flow collections and multi-document streams are not
+// supported.
+type yamlParser struct {
+	lines   []yamlLine
+	pos     int
+	anchors map[string]*sharedValue
+}
+
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+func newYAMLParser(b []byte) (*yamlParser, error) {
+	p := &yamlParser{anchors: make(map[string]*sharedValue)}
+	for i, l := range strings.Split(string(b), "\n") {
+		l = strings.TrimSuffix(l, "\r")
+		text := strings.TrimLeft(l, " ")
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("line %d: tabs can't be used for indentation", i+1)
+		}
+		p.lines = append(p.lines, yamlLine{num: i + 1, indent: len(l) - len(text), text: text})
+	}
+	return p, nil
+}
+
+func (p *yamlParser) errorf(format string, a ...interface{}) error {
+	num := len(p.lines)
+	if p.pos < len(p.lines) {
+		num = p.lines[p.pos].num
+	}
+	return fmt.Errorf("line %d: %s", num, fmt.Sprintf(format, a...))
+}
+
+// skip moves past empty lines and comments. It returns false at the end of input.
+func (p *yamlParser) skip() bool {
+	for p.pos < len(p.lines) {
+		if stripYAMLComment(p.lines[p.pos].text) != "" {
+			return true
+		}
+		p.pos++
+	}
+	return false
+}
+
+func (p *yamlParser) parseDocument() (interface{}, error) {
+	if !p.skip() {
+		return nil, nil
+	}
+	if p.lines[p.pos].text == "---" {
+		p.pos++
+		if !p.skip() {
+			return nil, nil
+		}
+	}
+	v, err := p.parseBlock(p.lines[p.pos].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.skip() && p.lines[p.pos].text != "..." {
+		return nil, p.errorf("unexpected content")
+	}
+	return v, nil
+}
+
+// parseBlock parses a block node starting at the current line.
+func (p *yamlParser) parseBlock(indent int) (interface{}, error) {
+	text := stripYAMLComment(p.lines[p.pos].text)
+	if isYAMLSeqEntry(text) {
+		return p.parseSeq(indent)
+	}
+	if _, _, ok := splitYAMLKey(text); ok {
+		return p.parseMap(indent)
+	}
+	return p.parseInlineValue(text, indent, false)
+}
+
+func (p *yamlParser) parseSeq(indent int) (interface{}, error) {
+	s := []interface{}{}
+	for p.skip() {
+		l := p.lines[p.pos]
+		text := stripYAMLComment(l.text)
+		if l.indent < indent || !isYAMLSeqEntry(text) {
+			break
+		}
+		if l.indent > indent {
+			return nil, p.errorf("bad indentation")
+		}
+		rest := strings.TrimLeft(text[1:], " ")
+		// Compact nested collection, e.g. "- a: b" or "- - a".
+		p.lines[p.pos].indent = l.indent + len(text) - len(rest)
+		p.lines[p.pos].text = rest
+		v, err := p.parseInlineValue(rest, indent, true)
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, v)
+	}
+	return s, nil
+}
+
+func (p *yamlParser) parseMap(indent int) (interface{}, error) {
+	m := make(map[string]interface{})
+	for p.skip() {
+		l := p.lines[p.pos]
+		text := stripYAMLComment(l.text)
+		if l.indent < indent || (l.indent == indent && isYAMLSeqEntry(text)) {
+			break
+		}
+		if l.indent > indent {
+			return nil, p.errorf("bad indentation")
+		}
+		key, rest, ok := splitYAMLKey(text)
+		if !ok {
+			return nil, p.errorf("expected a mapping key")
+		}
+		if _, ok := m[key]; ok {
+			return nil, p.errorf("duplicate key %s", key)
+		}
+		p.lines[p.pos].indent = l.indent + len(text) - len(rest)
+		p.lines[p.pos].text = rest
+		v, err := p.parseInlineValue(rest, indent, false)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	return m, nil
+}
+
+// parseInlineValue parses the value following "key:" or "-" on the current
+// line. The value may continue on the following lines. Indent is the
+// indentation of the parent collection.
+func (p *yamlParser) parseInlineValue(text string, indent int, inSeq bool) (interface{}, error) {
+	var node *sharedValue
+	if strings.HasPrefix(text, "&") {
+		name := text[1:]
+		if i := strings.IndexByte(name, ' '); i >= 0 {
+			name = name[:i]
+		}
+		if name == "" {
+			return nil, p.errorf("empty anchor name")
+		}
+		node = &sharedValue{label: name}
+		p.anchors[name] = node
+		text = strings.TrimLeft(text[1+len(name):], " ")
+		p.lines[p.pos].indent += len(p.lines[p.pos].text) - len(text)
+		p.lines[p.pos].text = text
+	}
+	v, err := p.parseUnanchored(text, indent, inSeq)
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return v, nil
+	}
+	node.v = v
+	return node, nil
+}
+
+func (p *yamlParser) parseUnanchored(text string, indent int, inSeq bool) (interface{}, error) {
+	switch {
+	case text == "":
+		p.pos++
+		if !p.skip() {
+			return nil, nil
+		}
+		l := p.lines[p.pos]
+		t := stripYAMLComment(l.text)
+		// Within a mapping, a sequence can be at the same indentation as its key.
+		if l.indent > indent || (l.indent == indent && !inSeq && isYAMLSeqEntry(t)) {
+			return p.parseBlock(l.indent)
+		}
+		return nil, nil
+	case inSeq && (isYAMLSeqEntry(text) || isYAMLKey(text)):
+		return p.parseBlock(p.lines[p.pos].indent)
+	case text[0] == '|' || text[0] == '>':
+		return p.parseBlockScalar(text, indent)
+	}
+	fp := &yamlFlowParser{s: text, anchors: p.anchors}
+	v, err := fp.parse()
+	if err != nil {
+		return nil, p.errorf("%v", err)
+	}
+	fp.skipSpaces()
+	if fp.pos != len(fp.s) {
+		return nil, p.errorf("unexpected %q", fp.s[fp.pos:])
+	}
+	p.pos++
+	return v, nil
+}
+
+func (p *yamlParser) parseBlockScalar(header string, indent int) (interface{}, error) {
+	folded := header[0] == '>'
+	chomp := strings.TrimSpace(header[1:])
+	if chomp != "" && chomp != "-" && chomp != "+" {
+		return nil, p.errorf("unsupported block scalar header %s", header)
+	}
+	p.pos++
+	var lines []string
+	blockIndent := -1
+	for ; p.pos < len(p.lines); p.pos++ {
+		l := p.lines[p.pos]
+		if l.text == "" {
+			lines = append(lines, "")
+			continue
+		}
+		if l.indent <= indent || (blockIndent >= 0 && l.indent < blockIndent) {
+			break
+		}
+		if blockIndent < 0 {
+			blockIndent = l.indent
+		}
+		lines = append(lines, strings.Repeat(" ", l.indent-blockIndent)+l.text)
+	}
+	content := len(lines)
+	for content > 0 && lines[content-1] == "" {
+		content--
+	}
+	var sb strings.Builder
+	for i, l := range lines[:content] {
+		if i > 0 {
+			if folded && l != "" && lines[i-1] != "" && !strings.HasPrefix(l, " ") {
+				sb.WriteString(" ")
+			} else {
+				sb.WriteString("\n")
+			}
+		}
+		sb.WriteString(l)
+	}
+	s := sb.String()
+	switch chomp {
+	case "":
+		if content > 0 {
+			s += "\n"
+		}
+	case "+":
+		s += strings.Repeat("\n", len(lines)-content+1)
+	}
+	return s, nil
+}
+
+func isYAMLSeqEntry(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func isYAMLKey(text string) bool {
+	_, _, ok := splitYAMLKey(text)
+	return ok
+}
+
+// splitYAMLKey splits "key: rest" into the key and the rest.
+func splitYAMLKey(text string) (string, string, bool) {
+	if text == "" {
+		return "", "", false
+	}
+	var key string
+	var i int
+	if text[0] == '"' || text[0] == '\'' {
+		fp := &yamlFlowParser{s: text}
+		s, err := fp.parseQuoted()
+		if err != nil {
+			return "", "", false
+		}
+		key, i = s, fp.pos
+		for i < len(text) && text[i] == ' ' {
+			i++
+		}
+		if i >= len(text) || text[i] != ':' {
+			return "", "", false
+		}
+	} else {
+		if strings.ContainsAny(text[:1], "[{&*!|>%@`") {
+			return "", "", false
+		}
+		i = strings.Index(text, ": ")
+		if i < 0 {
+			if !strings.HasSuffix(text, ":") {
+				return "", "", false
+			}
+			i = len(text) - 1
+		}
+		key = strings.TrimRight(text[:i], " ")
+	}
+	rest := strings.TrimLeft(text[i+1:], " ")
+	if i+1 < len(text) && text[i+1] != ' ' {
+		return "", "", false
+	}
+	return key, rest, true
+}
+
+// stripYAMLComment removes the comment at the end of the line, if any.
+func stripYAMLComment(text string) string {
+	quote := byte(0)
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || strings.IndexByte(" [{,:-", text[i-1]) >= 0 {
+				quote = c
+			}
+		case c == '#' && (i == 0 || text[i-1] == ' '):
+			return strings.TrimRight(text[:i], " ")
+		}
+	}
+	return strings.TrimRight(text, " ")
+}
+
+// yamlFlowParser parses single-line flow values: scalars, aliases
+// and flow collections.
+type yamlFlowParser struct {
+	s       string
+	pos     int
+	anchors map[string]*sharedValue
+}
+
+func (fp *yamlFlowParser) skipSpaces() {
+	for fp.pos < len(fp.s) && fp.s[fp.pos] == ' ' {
+		fp.pos++
+	}
+}
+
+func (fp *yamlFlowParser) parse() (interface{}, error) {
+	return fp.parseValue(false)
+}
+
+func (fp *yamlFlowParser) parseValue(inFlow bool) (interface{}, error) {
+	fp.skipSpaces()
+	if fp.pos >= len(fp.s) {
+		return nil, nil
+	}
+	switch c := fp.s[fp.pos]; c {
+	case '[':
+		return fp.parseFlowSeq()
+	case '{':
+		return fp.parseFlowMap()
+	case '"', '\'':
+		return fp.parseQuoted()
+	case '*':
+		fp.pos++
+		start := fp.pos
+		for fp.pos < len(fp.s) && !strings.ContainsRune(" ,[]{}", rune(fp.s[fp.pos])) {
+			fp.pos++
+		}
+		name := fp.s[start:fp.pos]
+		node, ok := fp.anchors[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown anchor %s", name)
+		}
+		return node, nil
+	case '&':
+		return nil, fmt.Errorf("anchors within flow collections are not supported")
+	case '!':
+		return nil, fmt.Errorf("tags are not supported")
+	}
+	start := fp.pos
+	for fp.pos < len(fp.s) {
+		c := fp.s[fp.pos]
+		if inFlow && strings.IndexByte(",[]{}", c) >= 0 {
+			break
+		}
+		if c == ':' && inFlow && (fp.pos+1 == len(fp.s) || fp.s[fp.pos+1] == ' ') {
+			break
+		}
+		fp.pos++
+	}
+	return yamlResolvePlain(strings.TrimRight(fp.s[start:fp.pos], " ")), nil
+}
+
+func (fp *yamlFlowParser) expect(c byte) error {
+	fp.skipSpaces()
+	if fp.pos >= len(fp.s) || fp.s[fp.pos] != c {
+		return fmt.Errorf("expected '%c'", c)
+	}
+	fp.pos++
+	return nil
+}
+
+func (fp *yamlFlowParser) peek() byte {
+	fp.skipSpaces()
+	if fp.pos >= len(fp.s) {
+		return 0
+	}
+	return fp.s[fp.pos]
+}
+
+func (fp *yamlFlowParser) parseFlowSeq() (interface{}, error) {
+	fp.pos++
+	s := []interface{}{}
+	for fp.peek() != ']' {
+		v, err := fp.parseValue(true)
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, v)
+		if fp.peek() != ',' {
+			break
+		}
+		fp.pos++
+	}
+	return s, fp.expect(']')
+}
+
+func (fp *yamlFlowParser) parseFlowMap() (interface{}, error) {
+	fp.pos++
+	m := make(map[string]interface{})
+	for fp.peek() != '}' {
+		k, err := fp.parseValue(true)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			key = fmt.Sprintf("%v", k)
+		}
+		err = fp.expect(':')
+		if err != nil {
+			return nil, err
+		}
+		v, err := fp.parseValue(true)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+		if fp.peek() != ',' {
+			break
+		}
+		fp.pos++
+	}
+	return m, fp.expect('}')
+}
+
+func (fp *yamlFlowParser) parseQuoted() (string, error) {
+	q := fp.s[fp.pos]
+	fp.pos++
+	var sb strings.Builder
+	for fp.pos < len(fp.s) {
+		c := fp.s[fp.pos]
+		fp.pos++
+		switch {
+		case c == q && q == '\'' && fp.pos < len(fp.s) && fp.s[fp.pos] == '\'':
+			sb.WriteByte('\'')
+			fp.pos++
+		case c == q:
+			return sb.String(), nil
+		case c == '\\' && q == '"':
+			err := fp.parseEscape(&sb)
+			if err != nil {
+				return "", err
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("unterminated string")
+}
+
+func (fp *yamlFlowParser) parseEscape(sb *strings.Builder) error {
+	if fp.pos >= len(fp.s) {
+		return fmt.Errorf("unterminated string")
+	}
+	c := fp.s[fp.pos]
+	fp.pos++
+	simple := map[byte]string{
+		'0': "\x00", 'a': "\a", 'b': "\b", 't': "\t", '\t': "\t", 'n': "\n", 'v': "\v",
+		'f': "\f", 'r': "\r", 'e': "\x1b", ' ': " ", '"': "\"", '/': "/", '\\': "\\",
+		'N': "\u0085", '_': " ", 'L': " ", 'P': " ",
+	}
+	if s, ok := simple[c]; ok {
+		sb.WriteString(s)
+		return nil
+	}
+	digits := map[byte]int{'x': 2, 'u': 4, 'U': 8}[c]
+	if digits == 0 || fp.pos+digits > len(fp.s) {
+		return fmt.Errorf("invalid escape sequence")
+	}
+	r, err := strconv.ParseUint(fp.s[fp.pos:fp.pos+digits], 16, 32)
+	if err != nil {
+		return fmt.Errorf("invalid escape sequence")
+	}
+	fp.pos += digits
+	// Surrogate pairs, as produced by JSON encoders.
+	if r >= 0xd800 && r < 0xdc00 && strings.HasPrefix(fp.s[fp.pos:], "\\u") && fp.pos+6 <= len(fp.s) {
+		lo, err := strconv.ParseUint(fp.s[fp.pos+2:fp.pos+6], 16, 32)
+		if err == nil && lo >= 0xdc00 && lo < 0xe000 {
+			r = 0x10000 + (r-0xd800)<<10 + (lo - 0xdc00)
+			fp.pos += 6
+		}
+	}
+	sb.WriteRune(rune(r))
+	return nil
+}
+
+// yamlResolvePlain determines the type of a plain scalar.
+func yamlResolvePlain(s string) interface{} {
+	switch s {
+	case "", "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+	if yamlNumber.MatchString(s) {
+		if json.Valid([]byte(s)) && s[0] != '+' {
+			return json.Number(s)
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err == nil {
+			return json.Number(strconv.FormatFloat(f, 'g', -1, 64))
+		}
+	}
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0o") {
+		i, err := strconv.ParseInt(s, 0, 64)
+		if err == nil {
+			return json.Number(strconv.FormatInt(i, 10))
+		}
+	}
+	return s
+}
@@ -0,0 +1,196 @@
+package grison
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestYAMLExample(t *testing.T) {
+	doc, err := ParseDocument([]byte(exampleDoc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := doc.MarshalYAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `Children:
+  "#3": &Children_3
+    Age: 10
+    Father: &Parents_2
+      Children:
+        - *Children_3
+      Name: Bob
+      Spouse: &Parents_1
+        Children:
+          - *Children_3
+        Name: Alice
+        Spouse: *Parents_2
+    Mother: *Parents_1
+    Name: Carol
+Parents:
+  "#1": *Parents_1
+  "#2": *Parents_2
+Pets: {}
+`
+	if string(b) != expected {
+		t.Errorf("unexpected YAML.\nexpect=%s\nactual=%s", expected, string(b))
+	}
+	doc2, err := ParseYAMLDocument(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b2, err := doc2.Marshal(MarshalOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b2) != exampleDoc {
+		t.Errorf("unexpected round trip result.\nexpect=%s\nactual=%s", exampleDoc, string(b2))
+	}
+}
+
+func TestYAMLRoundTrip(t *testing.T) {
+	type Node struct {
+		S  string
+		T  []string
+		M  map[string]int
+		B  []byte
+		F  float64
+		N  *Node
+		I  interface{}
+		NN [][]int
+	}
+	type Master struct {
+		Node []*Node
+	}
+	m := &Master{
+		Node: []*Node{
+			{S: "- not a list", T: []string{"true", "", " x", "#1", "a: b", "42", "line\nbreak"}, F: 1e21},
+			{S: "plain text", M: map[string]int{"a b": 1, "#": 2}, B: []byte{1, 2}, NN: [][]int{{1}, {}, nil}},
+		},
+	}
+	m.Node[0].N = m.Node[0]
+	m.Node[0].I = m.Node[1]
+	m.Node[1].N = m.Node[0]
+	b, err := MarshalYAML(m, MarshalOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m2 Master
+	err = UnmarshalYAML(b, &m2)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, b)
+	}
+	if !reflect.DeepEqual(m, &m2) {
+		t.Errorf("unexpected round trip result\n%s", b)
+	}
+}
+
+func TestYAMLLongChain(t *testing.T) {
+	type Node struct {
+		N *Node
+	}
+	type Master struct {
+		Node []*Node
+	}
+	m := &Master{}
+	for i := 0; i < 1000; i++ {
+		m.Node = append(m.Node, &Node{})
+		if i > 0 {
+			m.Node[i-1].N = m.Node[i]
+		}
+	}
+	m.Node[999].N = m.Node[0]
+	b, err := MarshalYAML(m, MarshalOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nodes are not nested all the way down the chain.
+	if len(b) > 100*len(m.Node) {
+		t.Errorf("YAML too long: %d bytes", len(b))
+	}
+	var m2 Master
+	err = UnmarshalYAML(b, &m2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, &m2) {
+		t.Errorf("unexpected round trip result")
+	}
+}
+
+func TestYAMLHandWritten(t *testing.T) {
+	type Node struct {
+		Name  string
+		Tags  []string
+		Text  string
+		Size  int
+		Next  *Node
+		Items []map[string]int
+	}
+	type Master struct {
+		Node []*Node
+	}
+	b := `# Hand-edited graph.
+---
+Node:
+  first: &first   # anchors can be named freely
+    Name: 'it''s'
+    Tags: [a, "b c", d]
+    Text: |
+      line one
+      line two
+    Size: 0x10
+    Next: &second
+      Name: second
+      Next: *first
+      Items:
+      - a: 1
+        b: 2
+      - {c: 3}
+  second: *second
+  third:
+    Name: no anchor needed
+`
+	var m Master
+	err := UnmarshalYAML([]byte(b), &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Node) != 3 {
+		t.Fatalf("unexpected number of nodes %d", len(m.Node))
+	}
+	first, second, third := m.Node[0], m.Node[1], m.Node[2]
+	if first.Name != "it's" || !reflect.DeepEqual(first.Tags, []string{"a", "b c", "d"}) ||
+		first.Text != "line one\nline two\n" || first.Size != 16 || first.Next != second {
+		t.Errorf("unexpected node %+v", first)
+	}
+	if second.Name != "second" || second.Next != first ||
+		!reflect.DeepEqual(second.Items, []map[string]int{{"a": 1, "b": 2}, {"c": 3}}) {
+		t.Errorf("unexpected node %+v", second)
+	}
+	if third.Name != "no anchor needed" {
+		t.Errorf("unexpected node %+v", third)
+	}
+}
+
+func TestYAMLInvalid(t *testing.T) {
+	type Node struct {
+		N *Node
+	}
+	type Master struct {
+		Node []*Node
+	}
+	for _, b := range []string{
+		"Node:\n  a:\n    N: *missing\n",
+		"Node:\n  a:\n    N: x\n   M: y\n",
+		"Node:\n  a: 'unterminated\n",
+		"- a\n- b\n",
+	} {
+		var m Master
+		err := UnmarshalYAML([]byte(b), &m)
+		if err == nil {
+			t.Errorf("invalid YAML was accepted:\n%s", b)
+		}
+	}
+}